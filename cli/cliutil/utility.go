@@ -11,10 +11,13 @@ import (
 	"strconv"
 	"strings"
 	"syscall"
+	"time"
 
 	"github.com/fatih/color"
 	"golang.org/x/crypto/ssh/terminal"
 
+	"github.com/coinbase/dexter/engine"
+	"github.com/coinbase/dexter/engine/helpers"
 	"github.com/coinbase/dexter/util"
 )
 
@@ -54,6 +57,25 @@ func ReadString(prompt, deefalt string, hidden bool) string {
 	return text
 }
 
+//
+// Return the store Dexter is configured to use, exiting if it
+// cannot be opened.
+//
+func Store() engine.Store {
+	store, err := engine.ConfiguredStore()
+	if err != nil {
+		color.HiRed("unable to open dexter store: " + err.Error())
+		os.Exit(1)
+	}
+	// refuse registries older than one this user has already accepted
+	err = engine.UseRegistryFloorFile(helpers.GetDexterRegistryFloorFile())
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	return store
+}
+
 //
 // Ask a command line user to provide a new password, taking the password
 // twice to confirm no errors in typing.
@@ -80,6 +102,39 @@ func CollectPassword() string {
 	return ReadString("Password", "", true)
 }
 
+//
+// Retrieve the PIN of the local investigator's PKCS#11 token from a user.
+//
+func CollectPIN() string {
+	return ReadString("Token PIN", "", true)
+}
+
+//
+// Return the source of the local investigator's keys, exiting if it
+// cannot be opened.
+//
+func LocalKeys() engine.KeySource {
+	keys, err := engine.LocalKeySource(CollectPassword, CollectPIN)
+	if err != nil {
+		color.HiRed("unable to open investigator keys: " + err.Error())
+		os.Exit(1)
+	}
+	return keys
+}
+
+//
+// Return the signer for the local investigator's current key, exiting if
+// it cannot be used.
+//
+func LocalSigner() engine.Signer {
+	signer, err := LocalKeys().Signer()
+	if err != nil {
+		color.HiRed("unable to use investigator key: " + err.Error())
+		os.Exit(1)
+	}
+	return signer
+}
+
 //
 // Prompt the user for a yes or no question, with a default answer defined by the
 // second argument.
@@ -104,6 +159,21 @@ func AskYesNo(str string, defaultTrue bool) bool {
 	}
 }
 
+//
+// Prompt the user for a duration such as "90m" or "24h", with a default answer
+// defined by the second argument.
+//
+func AskDuration(str string, deefalt string) time.Duration {
+	for {
+		answer := ReadString(str, deefalt, false)
+		duration, err := time.ParseDuration(answer)
+		if err == nil && duration >= 0 {
+			return duration
+		}
+		fmt.Println("a positive duration like \"30m\" or \"24h\", please")
+	}
+}
+
 //
 // Prompt the user to selection options from a list, passing a list, a promp string, the default state
 // (true = selected) for all members, and a boolean to indicate if at least one selection is required.
@@ -5,8 +5,13 @@
 package daemon
 
 import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
 	"github.com/coinbase/dexter/engine"
 
+	"github.com/fatih/color"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -21,13 +26,49 @@ receiving investigations to run.
 	Run:  startEngine,
 }
 
+var enrollCmd = &cobra.Command{
+	Use:   "enroll",
+	Short: "Create an enrollment file for this host",
+	Long: `This command creates the identity key this host's daemon will use
+to sign its reports, if it does not exist yet.
+
+A file is generated in the current working directory, which a registry
+admin must enroll with "dexter registry enroll" before investigators
+will trust reports from this host.  The host key fingerprint is printed
+so the admin can check they are enrolling this host's key.`,
+	Args: cobra.MaximumNArgs(0),
+	Run:  enrollHost,
+}
+
 //
 // Return the set of cobra commands used for the daemon subcommand
 //
 func CommandSuite() *cobra.Command {
+	cmd.AddCommand(enrollCmd)
 	return cmd
 }
 
+func enrollHost(_ *cobra.Command, _ []string) {
+	identity, err := engine.LoadHostIdentity()
+	if err != nil {
+		color.HiRed("unable to load host identity key: " + err.Error())
+		os.Exit(1)
+	}
+	data, err := json.MarshalIndent(identity.Host(), "", "  ")
+	if err != nil {
+		color.HiRed("fatal error encoding host: " + err.Error())
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile(identity.Name+".json", data, 0644)
+	if err != nil {
+		color.HiRed("fatal error writing host file: " + err.Error())
+		os.Exit(1)
+	}
+	color.Green("New host file created: " + identity.Name + ".json")
+	color.HiCyan("Host key fingerprint: " + engine.HostKeyFingerprint(identity.PublicKey()))
+	color.Yellow("This must be enrolled by a registry admin with \"dexter registry enroll\".")
+}
+
 func startEngine(_ *cobra.Command, _ []string) {
 	log.SetFormatter(&log.JSONFormatter{
 		FieldMap: log.FieldMap{
@@ -38,5 +79,12 @@ func startEngine(_ *cobra.Command, _ []string) {
 		"at": "daemon.startEngine",
 	}).Info("Starting Dexter Daemon")
 
-	engine.Start()
+	store, err := engine.ConfiguredStore()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "daemon.startEngine",
+			"error": err.Error(),
+		}).Fatal("unable to open dexter store")
+	}
+	engine.Start(store)
 }
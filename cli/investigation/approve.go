@@ -15,7 +15,8 @@ import (
 
 func approveInvestigation(cmd *cobra.Command, args []string) {
 	uuid := args[0]
-	inv, err := engine.InvestigationByID(uuid)
+	store := cliutil.Store()
+	inv, err := engine.InvestigationByID(store, uuid)
 	if err != nil {
 		color.HiRed("error looking up investigation: " + err.Error())
 		return
@@ -35,15 +36,52 @@ func approveInvestigation(cmd *cobra.Command, args []string) {
 	table.Append([]string{"Kill Containers?", strconv.FormatBool(inv.KillContainers)})
 	table.Append([]string{"Kill Host?", strconv.FormatBool(inv.KillHost)})
 	table.Append([]string{"Recipients", strings.Join(inv.RecipientNames, ", ")})
+	table.Append([]string{"Issued At", formatTime(inv.IssuedAt)})
+	table.Append([]string{"Not Before", formatTime(inv.NotBefore)})
+	table.Append([]string{"Expires", expiration(inv)})
 	table.Append([]string{"Approvers", strings.Join(inv.ApproverNames(), ", ")})
 	table.Render()
 
-	inv.Approve(helpers.LoadLocalKey(cliutil.CollectPassword))
-	err = inv.Upload()
+	inv.Approve(cliutil.LocalSigner())
+	err = inv.Upload(store)
 	if err != nil {
 		color.HiRed("Failed to upload approval: " + err.Error())
 		return
 	}
 	success := color.New(color.FgHiGreen, color.Bold)
 	success.Println("Investigation Approved!")
+	printMissingApprovals(store, inv)
+}
+
+//
+// Load the approval policy configured on this machine, with the registry
+// roots its roles are trusted from.
+//
+func loadPolicy() (*engine.Policy, error) {
+	roots, err := engine.LoadRegistryRoots()
+	if err != nil {
+		return nil, err
+	}
+	return engine.LoadPolicy(roots)
+}
+
+//
+// Explain the approvals an investigation still needs under the local
+// approval policy.
+//
+func printMissingApprovals(store engine.Store, inv engine.Investigation) {
+	policy, err := loadPolicy()
+	if err != nil {
+		color.HiRed(err.Error())
+		return
+	}
+	missing := inv.MissingApprovals(engine.NewTrustedInvestigators(store), policy, false)
+	if len(missing) == 0 {
+		color.HiGreen("The investigation has every approval it needs.")
+		return
+	}
+	color.HiYellow("The investigation still needs:")
+	for _, needed := range missing {
+		color.Yellow("  " + needed)
+	}
 }
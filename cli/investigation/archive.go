@@ -3,8 +3,9 @@ package investigation
 import (
 	"os"
 	"path"
+	"strings"
 
-	"github.com/coinbase/dexter/engine/helpers"
+	"github.com/coinbase/dexter/cli/cliutil"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
@@ -12,18 +13,24 @@ import (
 
 // Add an underscore to investigation files to hide them by default
 func archiveInvestigations(cmd *cobra.Command, args []string) {
-	filenames, err := helpers.ListS3Path("investigations/")
-	if err != nil {
-		color.HiRed("unable to list investigations: " + err.Error())
-		os.Exit(1)
-	}
-
-	for _, filename := range filenames {
+	store := cliutil.Store()
+	// archived investigations are skipped, so moving them while the
+	// listing is still being read is safe
+	iterator := store.Iterate("investigations/")
+	for iterator.Next() {
+		filename := iterator.Key()
 		base := path.Base(filename)
-		err = helpers.MoveS3File(filename, "investigations/_"+base)
+		if strings.HasPrefix(base, "_") {
+			continue
+		}
+		err := store.Move(filename, "investigations/_"+base)
 		if err != nil {
 			color.HiRed("error moving file for archive: " + err.Error())
 			os.Exit(1)
 		}
 	}
+	if err := iterator.Err(); err != nil {
+		color.HiRed("unable to list investigations: " + err.Error())
+		os.Exit(1)
+	}
 }
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"os"
 	"strconv"
+	"time"
 )
 
 var titleColor = color.New(color.FgHiGreen, color.Bold)
@@ -41,29 +42,40 @@ func createInvestigation(cmd *cobra.Command, args []string) {
 	titleColor.Println(welcomeMessage)
 
 	// Create a new investigation struct, interacting with the user where required for each field
+	store := cliutil.Store()
+	investigatorNames, err := engine.LoadInvestigatorNames(store)
+	if err != nil {
+		color.HiRed("unable to load investigators: " + err.Error())
+		os.Exit(1)
+	}
 	id := helpers.NewDexterID()
+	issuedAt := time.Now().UTC().Truncate(time.Second)
 	investigation := engine.Investigation{
 		ID:             id,
 		TaskList:       collectTasks(),
 		Scope:          collectFacts(id),
 		KillContainers: cliutil.AskYesNo(color.HiCyanString("Terminate containers in scope after tasks complete?"), false),
 		KillHost:       cliutil.AskYesNo(color.HiCyanString("Terminate hosts in scope after tasks compelte?"), false),
-		RecipientNames: cliutil.SelectFromList(engine.LoadInvestigatorNames(), "Which investigators should be able to access this report?", true, true),
+		RecipientNames: cliutil.SelectFromList(investigatorNames, "Which investigators should be able to access this report?", true, true),
 		Issuer:         engine.Signature{Name: engine.LocalInvestigatorName()},
+		IssuedAt:       issuedAt,
 	}
+	investigation.NotBefore = issuedAt.Add(cliutil.AskDuration(color.HiCyanString("How long should hosts wait before running this investigation?"), "0s"))
+	investigation.ExpiresAt = investigation.NotBefore.Add(cliutil.AskDuration(color.HiCyanString("How long should this investigation remain valid after that?"), "24h"))
 
 	// Sign the investigation, prompting the user to decrypt their key
 	color.Yellow("The investigation will now be signed...")
-	investigation.Sign(helpers.LoadLocalKey(cliutil.CollectPassword))
+	investigation.Sign(cliutil.LocalSigner())
 
-	// Upload the investigation to S3, reporting any errors
-	err := investigation.Upload()
+	// Upload the investigation to the store, reporting any errors
+	err = investigation.Upload(store)
 	if err != nil {
 		color.HiRed("error uploading investigation: " + err.Error())
 		os.Exit(1)
 	} else {
 		titleColor.Println("Investigation Uploaded!")
 	}
+	printMissingApprovals(store, investigation)
 }
 
 // Drop into a command line loop to collect tasks to include in this investigation
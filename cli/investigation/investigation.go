@@ -45,6 +45,15 @@ var approveCmd = &cobra.Command{
 	Run:   approveInvestigation,
 }
 
+var statusCmd = &cobra.Command{
+	Use:   "status [id]",
+	Short: "Show the progress of an investigation on each host",
+	Long: `Print the latest signed status each host has reported for an investigation.
+Statuses not signed by the enrolled key of their host are marked invalid.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  investigationStatus,
+}
+
 func CommandSuite() *cobra.Command {
 	listCmd.PersistentFlags().BoolVar(&showArchived, "archived", false, "show archived investigations")
 
@@ -52,5 +61,6 @@ func CommandSuite() *cobra.Command {
 	cmd.AddCommand(listCmd)
 	cmd.AddCommand(archiveCmd)
 	cmd.AddCommand(approveCmd)
+	cmd.AddCommand(statusCmd)
 	return cmd
 }
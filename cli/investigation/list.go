@@ -4,10 +4,13 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/coinbase/dexter/cli/cliutil"
 	"github.com/coinbase/dexter/engine"
 	"github.com/coinbase/dexter/engine/helpers"
 
+	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
 	"github.com/spf13/cobra"
 )
@@ -21,6 +24,8 @@ func listInvestigations(cmd *cobra.Command, args []string) {
 		"Scope",
 		"Consensus",
 		"Reviewed By",
+		"Not Before",
+		"Expires",
 	})
 
 	table.SetHeaderColor(
@@ -30,6 +35,8 @@ func listInvestigations(cmd *cobra.Command, args []string) {
 		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
 		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
 		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
 	)
 
 	table.SetColumnColor(
@@ -39,24 +46,55 @@ func listInvestigations(cmd *cobra.Command, args []string) {
 		tablewriter.Colors{tablewriter.FgHiYellowColor},
 		tablewriter.Colors{tablewriter.FgHiYellowColor},
 		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
 	)
 
+	store := cliutil.Store()
 	var list []engine.Investigation
 	if showArchived {
-		list = engine.AllInvestigations()
+		list = engine.AllInvestigations(store)
 	} else {
-		list = engine.CurrentInvestigations()
+		list = engine.CurrentInvestigations(store)
 	}
 
+	// investigators and the approval policy are loaded once for every row
+	trusted := engine.NewTrustedInvestigators(store)
+	policy, err := loadPolicy()
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
 	for _, inv := range list {
 		table.Append([]string{
 			inv.ID,
 			inv.Issuer.Name,
 			strings.Join(helpers.TaskStrings(inv.TaskList), ",\n"),
 			strings.Join(inv.ScopeFactsStrings(), ",\n"),
-			fmt.Sprintf("%d/%d", inv.ValidUniqueApprovers(), inv.MinimumConsensus()),
+			fmt.Sprintf("%d/%d", inv.ValidUniqueApprovers(trusted), inv.MinimumConsensus(policy)),
 			strings.Join(inv.ApproverNames(), ",\n"),
+			formatTime(inv.NotBefore),
+			expiration(inv),
 		})
 	}
 	table.Render()
 }
+
+// Format a timestamp for display, or a dash if it is not set
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return t.Local().Format("2006-01-02 15:04 MST")
+}
+
+// Describe when an investigation expires, flagging ones that already have
+func expiration(inv engine.Investigation) string {
+	if inv.ExpiresAt.IsZero() {
+		return "never (will not run)"
+	}
+	if inv.Expired() {
+		return "expired " + formatTime(inv.ExpiresAt)
+	}
+	return formatTime(inv.ExpiresAt)
+}
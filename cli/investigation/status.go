@@ -0,0 +1,71 @@
+package investigation
+
+import (
+	"os"
+	"time"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func investigationStatus(cmd *cobra.Command, args []string) {
+	store := cliutil.Store()
+	uuid, err := engine.ResolveUUID(store, args[0])
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	records, err := engine.StatusRecords(store, uuid)
+	if err != nil {
+		color.HiRed("error downloading status records: " + err.Error())
+		os.Exit(1)
+	}
+	if len(records) == 0 {
+		color.HiYellow("no hosts have reported on investigation " + uuid + " yet")
+		return
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{
+		"Host",
+		"State",
+		"Detail",
+		"Updated",
+		"Signature",
+	})
+
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+	)
+
+	table.SetColumnColor(
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+	)
+
+	for _, record := range records {
+		signature := "valid"
+		if err := record.Verify(store); err != nil {
+			signature = "INVALID: " + err.Error()
+		}
+		table.Append([]string{
+			record.Hostname,
+			record.State,
+			record.Detail,
+			record.Time.Local().Format(time.RFC1123),
+			signature,
+		})
+	}
+	table.Render()
+}
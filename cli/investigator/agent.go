@@ -0,0 +1,62 @@
+package investigator
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
+	"github.com/coinbase/dexter/engine/helpers"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var agentSocket string
+
+func runAgent(cmd *cobra.Command, args []string) {
+	if helpers.AgentSocket() != "" && agentSocket == "" {
+		color.HiRed("DEXTER_AGENT_SOCK is already set, unset it to start a new agent")
+		os.Exit(1)
+	}
+	keys, err := engine.DirectKeySource(cliutil.CollectPassword, cliutil.CollectPIN)
+	if err != nil {
+		color.HiRed("unable to open investigator keys: " + err.Error())
+		os.Exit(1)
+	}
+	// fail now, rather than on the first request, if there is no current key
+	if _, err = keys.Signer(); err != nil {
+		color.HiRed("unable to use investigator key: " + err.Error())
+		os.Exit(1)
+	}
+
+	path := agentSocket
+	if path == "" {
+		path = helpers.GetDexterAgentSocketFile()
+	}
+	path, err = filepath.Abs(filepath.FromSlash(path))
+	if err != nil {
+		color.HiRed("invalid agent socket path: " + err.Error())
+		os.Exit(1)
+	}
+	listener, err := engine.ListenAgent(path)
+	if err != nil {
+		color.HiRed("unable to start agent: " + err.Error())
+		os.Exit(1)
+	}
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, syscall.SIGHUP)
+	go func() {
+		<-signals
+		listener.Close()
+	}()
+
+	fmt.Printf("DEXTER_AGENT_SOCK=%s; export DEXTER_AGENT_SOCK;\n", path)
+	color.Green("Dexter agent listening, press Ctrl+C or send SIGTERM to stop")
+	engine.ServeAgent(listener, keys)
+	os.Remove(path)
+}
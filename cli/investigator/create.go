@@ -17,6 +17,10 @@ import (
 func createInvestigator(cmd *cobra.Command, args []string) {
 	name := args[0] // Cobra ensures arg length, this is safe
 	color.HiCyan("Initializing new investigator \"%s\" on local system...", name)
+	if module := helpers.PKCS11Module(); module != "" {
+		createTokenInvestigator(name, module)
+		return
+	}
 	investigator, privateKeyPEM, err := engine.NewInvestigator(name, cliutil.CollectNewPassword())
 	if err != nil {
 		color.HiRed(err.Error())
@@ -26,7 +30,46 @@ func createInvestigator(cmd *cobra.Command, args []string) {
 	writePublicKey(investigator)
 }
 
+//
+// Create an investigator whose key is generated in, and never leaves, a
+// PKCS#11 token.  No local key file is written.
+//
+func createTokenInvestigator(name, module string) {
+	token, err := engine.OpenPKCS11Token(module, helpers.PKCS11Token(), cliutil.CollectPIN, "")
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	defer token.Close()
+	key, err := token.GenerateKey()
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	investigator := engine.Investigator{Name: name}
+	investigator.AddKey(key)
+	writeInvestigatorFile(investigator)
+	writePublicKey(investigator)
+}
+
 func writePrivateKey(investigator engine.Investigator, privatePEM []byte) {
+	writeInvestigatorFile(investigator)
+
+	// Save the local private key
+	dexterKeyName := helpers.GetDexterKeyFile()
+	if _, err := os.Stat(filepath.FromSlash(dexterKeyName)); err == nil {
+		color.HiRed("\ndexter key file %s already exists", dexterKeyName)
+		color.HiRed("If you would like to replace your key, please remove this file.")
+		os.Exit(1)
+	}
+	err := ioutil.WriteFile(dexterKeyName, privatePEM, 0644)
+	if err != nil {
+		color.HiRed("fatal error writing investigator private key: " + err.Error())
+		os.Exit(1)
+	}
+}
+
+func writeInvestigatorFile(investigator engine.Investigator) {
 	// Create dexter directory if needed
 	dexterDir := helpers.GetDexterDirectory()
 	err := os.MkdirAll(filepath.FromSlash(dexterDir), 0700)
@@ -48,19 +91,6 @@ func writePrivateKey(investigator engine.Investigator, privatePEM []byte) {
 		color.HiRed("fatal error writing local investigator definition: %s", err.Error())
 		os.Exit(1)
 	}
-
-	// Save the local private key
-	dexterKeyName := helpers.GetDexterKeyFile()
-	if _, err = os.Stat(filepath.FromSlash(dexterKeyName)); err == nil {
-		color.HiRed("\ndexter key file %s already exists", dexterKeyName)
-		color.HiRed("If you would like to replace your key, please remove this file.")
-		os.Exit(1)
-	}
-	err = ioutil.WriteFile(dexterKeyName, privatePEM, 0644)
-	if err != nil {
-		color.HiRed("fatal error writing investigator private key: " + err.Error())
-		os.Exit(1)
-	}
 }
 
 func writePublicKey(investigator engine.Investigator) {
@@ -20,10 +20,13 @@ var createCmd = &cobra.Command{
 	Short: "Create a new dexter investigator",
 	Long: `This command creates a new investigator for the local machine.
 
-A RSA key pair is generated which is used to sign investigations.
-This is saved to the local filesystem, and a file is generated in
+An Ed25519 key, used to sign investigations, and an X25519 key, used
+to receive reports, are generated.  These are saved to the local filesystem, and a file is generated in
 the current working directory which can be submitted in a pull
-request to Dexter to add this investigator.`,
+request to Dexter to add this investigator.
+
+If DEXTER_PKCS11_MODULE is set, an RSA key is generated in the PKCS#11
+token instead, and never leaves it.`,
 	Args: cobra.MinimumNArgs(1),
 	Run:  createInvestigator,
 }
@@ -31,13 +34,80 @@ request to Dexter to add this investigator.`,
 var revokeCmd = &cobra.Command{
 	Use:   "revoke [username] <usernames...>",
 	Short: "Revoke dexter investigators",
-	Long:  "Remove investigator(s) from Dexter and delete all reports for the investigator(s).  Reports will be preseved for other users.",
-	Args:  cobra.MinimumNArgs(1),
-	Run:   revokeInvestigator,
+	Long: `This command revokes investigators by uploading a revocation record
+signed by the local investigator.  If registry roots are configured,
+only registry admins can revoke investigators.
+
+Revoked investigators are no longer sent reports, and their signatures
+no longer count towards approving investigations.  Reports already sent
+to them are kept.  With --rekey-to, the keys of the reports sent to them
+that were also sent to you are passed on to another investigator.  With
+--archive-reports, their copies of reports are archived.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  revokeInvestigator,
+}
+
+var rotateCmd = &cobra.Command{
+	Use:   "rotate",
+	Short: "Rotate the local investigator's key",
+	Long: `This command generates a new key for the local investigator.
+
+The new key is added to the local key file, and a new investigator
+file is generated in the current working directory which must be
+uploaded to Dexter by a Dexter administrator.  Once it is uploaded,
+reports are encrypted with the new key only.  Investigations are
+signed with the old key until the new one is activated with
+--activate, which checks the new key has been published and writes
+another investigator file retiring the old keys, to be uploaded the
+same way.  Retired keys only verify investigations issued before they
+were retired, for at most a day afterwards.  Old keys are kept locally
+so old reports can still be read.
+
+If DEXTER_PKCS11_MODULE is set, the new key is generated in the
+PKCS#11 token.`,
+	Args: cobra.NoArgs,
+	Run:  rotateInvestigatorKey,
+}
+
+var passwdCmd = &cobra.Command{
+	Use:   "passwd",
+	Short: "Change the local investigator's password",
+	Long: `This command changes the password protecting the local investigator's
+key file.
+
+The current password is required.  Every key in the key file, including
+rotated keys kept to read old reports, is protected with the new
+password.`,
+	Args: cobra.NoArgs,
+	Run:  changeInvestigatorPassword,
+}
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Hold the local investigator's keys for other commands",
+	Long: `This command unlocks the local investigator's keys once and serves
+them to other dexter commands over a unix socket, in the manner of
+ssh-agent.
+
+The agent prints the DEXTER_AGENT_SOCK variable that other commands
+use to find it.  While it is set, investigations are signed and
+reports are decrypted by the agent, and private keys never leave it.
+The agent can hold keys from the local key file or from a PKCS#11
+token configured with DEXTER_PKCS11_MODULE.`,
+	Args: cobra.NoArgs,
+	Run:  runAgent,
 }
 
 func CommandSuite() *cobra.Command {
+	agentCmd.Flags().StringVar(&agentSocket, "socket", "", "path of the socket to listen on, defaults to ~/.dexter/agent.sock")
+	cmd.AddCommand(agentCmd)
 	cmd.AddCommand(createCmd)
+	revokeCmd.Flags().StringVar(&revokeReason, "reason", "", "reason for the revocation, recorded with it")
+	revokeCmd.Flags().BoolVar(&revokeArchiveReports, "archive-reports", false, "archive the revoked investigators' copies of reports")
+	revokeCmd.Flags().StringVar(&revokeRekeyTo, "rekey-to", "", "pass the keys of the revoked investigators' reports on to this investigator")
 	cmd.AddCommand(revokeCmd)
+	rotateCmd.Flags().BoolVar(&rotateActivate, "activate", false, "start signing with the newest published key")
+	cmd.AddCommand(rotateCmd)
+	cmd.AddCommand(passwdCmd)
 	return cmd
 }
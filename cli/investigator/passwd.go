@@ -0,0 +1,30 @@
+package investigator
+
+import (
+	"os"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
+	"github.com/coinbase/dexter/engine/helpers"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func changeInvestigatorPassword(cmd *cobra.Command, args []string) {
+	investigator := engine.LoadLocalInvestigator()
+	color.HiCyan("Changing the password for investigator \"%s\"...", investigator.Name)
+
+	if helpers.PKCS11Module() != "" {
+		color.HiRed("keys held in a PKCS#11 token are protected by its PIN, which is changed with the token's own tools")
+		os.Exit(1)
+	}
+	keyring := engine.LoadLocalKeyring(cliutil.CollectPassword)
+	keyring.SetPassword(cliutil.CollectNewPassword())
+	err := keyring.Save()
+	if err != nil {
+		color.HiRed("error writing key file: " + err.Error())
+		os.Exit(1)
+	}
+	color.Green("Password changed")
+}
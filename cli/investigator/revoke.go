@@ -1,37 +1,84 @@
 package investigator
 
 import (
-	"strings"
+	"os"
 
-	"github.com/coinbase/dexter/engine/helpers"
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
+var revokeReason string
+var revokeArchiveReports bool
+var revokeRekeyTo string
+
 func revokeInvestigator(cmd *cobra.Command, args []string) {
+	store := cliutil.Store()
+	local := engine.LoadLocalInvestigator()
+	roots, err := engine.LoadRegistryRoots()
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	if roots != nil && !roots.IsAdmin(local) {
+		color.HiRed("only registry admins can revoke investigators")
+		os.Exit(1)
+	}
+	var target engine.Investigator
+	if revokeRekeyTo != "" {
+		target, err = engine.InvestigatorByName(store, revokeRekeyTo)
+		if err != nil {
+			color.HiRed("unable to pass reports on: " + err.Error())
+			os.Exit(1)
+		}
+		for _, name := range args {
+			if name == local.Name || name == target.Name {
+				color.HiRed("reports cannot be passed on by or to a revoked investigator")
+				os.Exit(1)
+			}
+		}
+	}
+
+	keys := cliutil.LocalKeys()
+	signer, err := keys.Signer()
+	if err != nil {
+		color.HiRed("unable to use investigator key: " + err.Error())
+		os.Exit(1)
+	}
 	for _, name := range args {
 		color.HiCyan("Revoking investigator \"%s\" ", name)
-		path := "investigators/" + name + ".json"
-		err := helpers.DeleteS3File(path)
+		revocation := engine.NewRevocation(name, revokeReason)
+		err := revocation.Sign(signer, local.Name)
+		if err == nil {
+			err = revocation.Upload(store)
+		}
 		if err != nil {
 			color.HiRed("error revoking investigator: " + err.Error())
-		} else {
-			color.HiGreen("Investigator Revoked!")
+			continue
+		}
+		color.HiGreen("Investigator Revoked!")
+		if roots != nil {
+			color.Yellow("Remove %s from the registry with \"dexter registry remove %s\" as well.", name, name)
 		}
 
-		color.Yellow("Deleting all old reports for %s", name)
-		files, err := helpers.ListS3Path("reports/")
-		if err != nil {
-			color.HiRed("error listing reports: %s", err)
+		if revokeRekeyTo != "" {
+			rekeyed, skipped, err := engine.RekeyReportsFor(store, name, target, keys, signer, local.Name)
+			if err != nil {
+				color.HiRed("error passing reports on: " + err.Error())
+			}
+			color.Green("Passed %d reports sent to %s on to %s", len(rekeyed), name, target.Name)
+			for _, file := range skipped {
+				color.Yellow("Unable to pass on the report from %s for investigation %s", file.Hostname, file.ID)
+			}
 		}
-		for _, file := range files {
-			if strings.Contains(file, name) {
-				err := helpers.DeleteS3File(file)
-				if err != nil {
-					color.HiRed(err.Error())
-				}
+		if revokeArchiveReports {
+			archived, err := engine.ArchiveReportsFor(store, name)
+			if err != nil {
+				color.HiRed("error archiving reports: " + err.Error())
 			}
+			color.Green("Archived %d reports sent to %s", len(archived), name)
 		}
 	}
 }
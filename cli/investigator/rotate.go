@@ -0,0 +1,126 @@
+package investigator
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
+	"github.com/coinbase/dexter/engine/helpers"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+var rotateActivate bool
+
+func rotateInvestigatorKey(cmd *cobra.Command, args []string) {
+	if rotateActivate {
+		activateInvestigatorKey()
+		return
+	}
+	investigator := engine.LoadLocalInvestigator()
+	color.HiCyan("Rotating the key for investigator \"%s\"...", investigator.Name)
+
+	// old keys are kept so old reports can still be read, and the local
+	// investigator file keeps naming the old key, so investigations are
+	// signed with it until the new key has been published.  Any key
+	// published before it that was never activated is retired.
+	key, err := newLocalKey()
+	if err != nil {
+		color.HiRed("error generating new key: " + err.Error())
+		os.Exit(1)
+	}
+	investigator.RetireKeysBefore(investigator.CurrentKeyID(), time.Now())
+	investigator.AddKey(key)
+	color.Green("New key created: " + key.ID)
+	writePublicKey(investigator)
+	color.Yellow("Once it is uploaded, run \"dexter investigator rotate --activate\" to start signing with the new key.")
+}
+
+//
+// Switch the local investigator to the newest key published for them in
+// the store, once it is a key this investigator holds, and retire the keys
+// published before it.
+//
+func activateInvestigatorKey() {
+	local := engine.LoadLocalInvestigator()
+	published, err := engine.InvestigatorByName(cliutil.Store(), local.Name)
+	if err != nil {
+		color.HiRed("unable to load the published investigator: " + err.Error())
+		os.Exit(1)
+	}
+	keyID := published.CurrentKeyID()
+	if keyID == local.CurrentKeyID() {
+		color.HiYellow("no new key has been published for investigator \"%s\"", local.Name)
+		os.Exit(1)
+	}
+	keys, err := engine.DirectKeySource(cliutil.CollectPassword, cliutil.CollectPIN)
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	if _, err := keys.Decrypter(keyID); err != nil {
+		color.HiRed("the published key is not held locally: " + err.Error())
+		os.Exit(1)
+	}
+
+	published.RetireKeysBefore(keyID, time.Now())
+	investigatorData, err := published.String()
+	if err != nil {
+		color.HiRed("fatal error serializing investigator: %s", err.Error())
+		os.Exit(1)
+	}
+	err = writeFileAtomic(filepath.FromSlash(helpers.GetDexterInvestigatorFile()), investigatorData, 0644)
+	if err != nil {
+		color.HiRed("fatal error writing local investigator definition: %s", err.Error())
+		os.Exit(1)
+	}
+	color.Green("Now signing with key " + keyID)
+	writePublicKey(published)
+	color.Yellow("Once it is uploaded, the old keys are retired and stop verifying new signatures.")
+	if helpers.AgentSocket() != "" {
+		color.Yellow("Restart your dexter agent to start using the new key.")
+	}
+}
+
+//
+// Generate a new key wherever the local investigator's keys are held,
+// returning its public half.
+//
+func newLocalKey() (engine.InvestigatorKey, error) {
+	if module := helpers.PKCS11Module(); module != "" {
+		token, err := engine.OpenPKCS11Token(module, helpers.PKCS11Token(), cliutil.CollectPIN, "")
+		if err != nil {
+			return engine.InvestigatorKey{}, err
+		}
+		defer token.Close()
+		return token.GenerateKey()
+	}
+	keyring := engine.LoadLocalKeyring(cliutil.CollectPassword)
+	key, err := keyring.Rotate()
+	if err != nil {
+		return engine.InvestigatorKey{}, err
+	}
+	err = keyring.Save()
+	if err != nil {
+		return engine.InvestigatorKey{}, errors.New("unable to write key file: " + err.Error())
+	}
+	return key.Public(), nil
+}
+
+//
+// Replace a file in one step, so an interrupted write never leaves it
+// truncated.
+//
+func writeFileAtomic(filename string, data []byte, perm os.FileMode) error {
+	tmp := filename + ".tmp"
+	err := ioutil.WriteFile(tmp, data, perm)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func enrollHosts(cmd *cobra.Command, args []string) {
+	store := cliutil.Store()
+	roots := loadRoots()
+	investigator := engine.LoadLocalInvestigator()
+	if !roots.IsAdmin(investigator) {
+		color.HiRed("investigator " + investigator.Name + " is not a registry admin with their current key")
+		os.Exit(1)
+	}
+	hosts := []engine.Host{}
+	for _, filename := range args {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			color.HiRed("unable to read host file: " + err.Error())
+			os.Exit(1)
+		}
+		var host engine.Host
+		err = json.Unmarshal(data, &host)
+		if err != nil {
+			color.HiRed("unable to parse host file " + filename + ": " + err.Error())
+			os.Exit(1)
+		}
+		host.EnrolledBy = nil
+		err = host.Verify(nil)
+		if err != nil {
+			color.HiRed("host file " + filename + ": " + err.Error())
+			os.Exit(1)
+		}
+		color.HiCyan("  %s (key %s)", host.Name, engine.HostKeyFingerprint(host.PublicKey))
+		hosts = append(hosts, host)
+	}
+	if !cliutil.AskYesNo("Enroll these hosts?", false) {
+		return
+	}
+	signer := cliutil.LocalSigner()
+	for _, host := range hosts {
+		err := host.Sign(signer, investigator.Name)
+		if err == nil {
+			err = host.Upload(store)
+		}
+		if err != nil {
+			color.HiRed("unable to enroll host " + host.Name + ": " + err.Error())
+			os.Exit(1)
+		}
+		color.Green("Host " + host.Name + " enrolled.")
+	}
+}
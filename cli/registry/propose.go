@@ -0,0 +1,214 @@
+package registry
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func addInvestigators(cmd *cobra.Command, args []string) {
+	store := cliutil.Store()
+	roots := loadRoots()
+	current := currentRegistry(store, roots)
+	proposal := baseProposal(store, current)
+	for _, filename := range args {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			color.HiRed("unable to read investigator file: " + err.Error())
+			os.Exit(1)
+		}
+		var investigator engine.Investigator
+		err = json.Unmarshal(data, &investigator)
+		if err != nil {
+			color.HiRed("unable to parse investigator file " + filename + ": " + err.Error())
+			os.Exit(1)
+		}
+		if investigator.Name == "" || (len(investigator.Keys) == 0 && investigator.PublicKey.N == "") {
+			color.HiRed("investigator file " + filename + " has no name or no keys")
+			os.Exit(1)
+		}
+		if len(addRoles) > 0 {
+			investigator.Roles = addRoles
+		} else if existing, ok := proposal.Investigator(investigator.Name); ok {
+			investigator.Roles = existing.Roles
+		} else {
+			investigator.Roles = nil
+		}
+		proposal = proposal.WithInvestigator(investigator)
+	}
+	submitProposal(store, roots, current, proposal)
+}
+
+func removeInvestigators(cmd *cobra.Command, args []string) {
+	store := cliutil.Store()
+	roots := loadRoots()
+	current := currentRegistry(store, roots)
+	proposal := baseProposal(store, current)
+	for _, name := range args {
+		if _, ok := proposal.Investigator(name); !ok {
+			color.HiRed("investigator " + name + " is not in the registry")
+			os.Exit(1)
+		}
+		proposal = proposal.WithoutInvestigator(name)
+	}
+	submitProposal(store, roots, current, proposal)
+}
+
+func signProposal(cmd *cobra.Command, args []string) {
+	store := cliutil.Store()
+	roots := loadRoots()
+	current := currentRegistry(store, roots)
+	proposal, ok := pendingProposal(store, current)
+	if !ok {
+		color.HiRed("there is no proposed registry waiting for signatures")
+		os.Exit(1)
+	}
+	printChanges(current, proposal)
+	signers := proposal.ValidSigners(roots)
+	if len(signers) > 0 {
+		color.HiCyan("Signed by: " + strings.Join(signers, ", "))
+	}
+	if !cliutil.AskYesNo("Sign this registry?", false) {
+		return
+	}
+	submitProposal(store, roots, current, proposal)
+}
+
+//
+// Load the registry roots, which registry commands cannot work without.
+//
+func loadRoots() *engine.RegistryRoots {
+	roots, err := engine.LoadRegistryRoots()
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	if roots == nil {
+		color.HiRed("no registry roots are configured, see \"dexter registry roots\"")
+		os.Exit(1)
+	}
+	return roots
+}
+
+//
+// Return the verified current registry, or an empty registry if none has
+// been published yet.
+//
+func currentRegistry(store engine.Store, roots *engine.RegistryRoots) engine.Registry {
+	if _, err := store.Get(engine.RegistryPath); err != nil {
+		return engine.Registry{}
+	}
+	registry, err := engine.LoadRegistry(store, roots)
+	if err != nil {
+		color.HiRed("the current registry is not trusted: " + err.Error())
+		os.Exit(1)
+	}
+	return registry
+}
+
+//
+// Return the proposal waiting for signatures, if it follows the current
+// registry.
+//
+func pendingProposal(store engine.Store, current engine.Registry) (engine.Registry, bool) {
+	proposal, err := engine.LoadRegistryProposal(store)
+	if err != nil || proposal.Version != current.Version+1 {
+		return engine.Registry{}, false
+	}
+	return proposal, true
+}
+
+//
+// Return the proposal new changes are added to.
+//
+func baseProposal(store engine.Store, current engine.Registry) engine.Registry {
+	proposal, ok := pendingProposal(store, current)
+	if !ok {
+		return current.Next()
+	}
+	if len(proposal.Signatures) > 0 {
+		color.HiYellow("This change is added to the pending proposal, which must be signed again.")
+	}
+	return proposal
+}
+
+//
+// Sign a proposal as the local investigator and upload it, publishing it
+// as the registry once it has enough signatures.
+//
+func submitProposal(store engine.Store, roots *engine.RegistryRoots, current, proposal engine.Registry) {
+	investigator := engine.LoadLocalInvestigator()
+	if !roots.IsAdmin(investigator) {
+		color.HiRed("investigator " + investigator.Name + " is not a registry admin with their current key")
+		os.Exit(1)
+	}
+	err := proposal.Sign(cliutil.LocalSigner(), investigator.Name)
+	if err != nil {
+		color.HiRed("unable to sign registry: " + err.Error())
+		os.Exit(1)
+	}
+	signers := proposal.ValidSigners(roots)
+	if len(signers) < roots.Threshold {
+		err = proposal.Upload(store, engine.RegistryProposalPath)
+		if err != nil {
+			color.HiRed("unable to upload proposed registry: " + err.Error())
+			os.Exit(1)
+		}
+		color.Green(fmt.Sprintf("Proposed registry version %d signed by %d of the %d admins required.",
+			proposal.Version, len(signers), roots.Threshold))
+		return
+	}
+	err = proposal.Upload(store, engine.RegistryPath)
+	if err != nil {
+		color.HiRed("unable to publish registry: " + err.Error())
+		os.Exit(1)
+	}
+	store.Delete(engine.RegistryProposalPath)
+	color.Green("Registry version " + strconv.Itoa(proposal.Version) + " published.")
+}
+
+//
+// Print the investigators a proposal adds, removes, and changes.
+//
+func printChanges(current, proposal engine.Registry) {
+	color.HiCyan("Changes from registry version %d to version %d:", current.Version, proposal.Version)
+	for _, investigator := range proposal.Investigators {
+		existing, ok := current.Investigator(investigator.Name)
+		if !ok {
+			color.Green("  + %s (key %s, roles %s)", investigator.Name, keyDescription(investigator), roleDescription(investigator))
+		} else if existing.CurrentKeyID() != investigator.CurrentKeyID() || len(existing.Keys) != len(investigator.Keys) {
+			color.Yellow("  ~ %s (key %s, was %s)", investigator.Name, keyDescription(investigator), keyDescription(existing))
+		}
+		if ok && roleDescription(existing) != roleDescription(investigator) {
+			color.Yellow("  ~ %s (roles %s, was %s)", investigator.Name, roleDescription(investigator), roleDescription(existing))
+		}
+	}
+	for _, investigator := range current.Investigators {
+		if _, ok := proposal.Investigator(investigator.Name); !ok {
+			color.HiRed("  - %s", investigator.Name)
+		}
+	}
+}
+
+func roleDescription(investigator engine.Investigator) string {
+	if len(investigator.Roles) == 0 {
+		return "none"
+	}
+	return strings.Join(investigator.Roles, ", ")
+}
+
+func keyDescription(investigator engine.Investigator) string {
+	if id := investigator.CurrentKeyID(); id != "" {
+		return id
+	}
+	return "legacy RSA"
+}
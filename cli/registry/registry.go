@@ -0,0 +1,112 @@
+//
+// The registry package contains command line tools for registry admins to
+// change the signed list of investigators.
+//
+package registry
+
+import (
+	"github.com/spf13/cobra"
+)
+
+var addRoles []string
+
+var cmd = &cobra.Command{
+	Use:   "registry [cmd]",
+	Short: "Manage the investigator registry",
+	Long: `This command is used to view and change the investigator registry.
+
+The registry is the signed list of investigators trusted by daemons and
+by the command line.  Each change is proposed as a new version of the
+registry, which takes effect once enough registry admins have signed it.`,
+	Args: cobra.MinimumNArgs(1),
+}
+
+var showCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the investigator registry",
+	Long: `This command lists the investigators in the current registry, and any
+proposed change waiting for admin signatures.`,
+	Args: cobra.NoArgs,
+	Run:  showRegistry,
+}
+
+var addCmd = &cobra.Command{
+	Use:   "add [investigator file] <investigator files...>",
+	Short: "Propose adding investigators to the registry",
+	Long: `This command proposes adding investigators to the registry, or
+replacing their entries, from the files created by "dexter investigator
+init" or "dexter investigator rotate".
+
+Roles used by the approval policy are given with --role, and replace the
+investigators' existing roles.  Without it, investigators already in
+the registry keep their roles, and roles in the files are ignored.
+
+The change is added to any proposal already waiting for signatures and
+signed by the local investigator, who must be a registry admin.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  addInvestigators,
+}
+
+var removeCmd = &cobra.Command{
+	Use:   "remove [username] <usernames...>",
+	Short: "Propose removing investigators from the registry",
+	Long: `This command proposes removing investigators from the registry.
+
+The change is added to any proposal already waiting for signatures and
+signed by the local investigator, who must be a registry admin.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  removeInvestigators,
+}
+
+var signCmd = &cobra.Command{
+	Use:   "sign",
+	Short: "Sign the proposed registry",
+	Long: `This command shows the changes in the proposed registry and signs it
+as the local investigator, who must be a registry admin.  Once enough
+admins have signed, the proposal replaces the current registry.`,
+	Args: cobra.NoArgs,
+	Run:  signProposal,
+}
+
+var enrollCmd = &cobra.Command{
+	Use:   "enroll [host file] <host files...>",
+	Short: "Enroll hosts",
+	Long: `This command enrolls hosts from the files created by "dexter daemon
+enroll", so investigators trust the reports and status records they sign.
+
+Each host's key fingerprint is shown before it is enrolled, and should be
+compared against the output of "dexter daemon enroll" on the host.  The
+enrollment is signed by the local investigator, who must be a registry
+admin, and uploaded to the hosts directory of the store.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  enrollHosts,
+}
+
+var rootsCmd = &cobra.Command{
+	Use:   "roots [threshold] [investigator file] <investigator files...>",
+	Short: "Create a registry roots file",
+	Long: `This command creates the registry roots file from the investigator
+files of the registry admins and the number of them that must sign each
+version of the registry.
+
+The file is written to registry-roots.json in the current working
+directory, and must be installed on every daemon and investigator
+machine, at ~/.dexter/registry-roots.json or the path named by
+DEXTER_REGISTRY_ROOTS.`,
+	Args: cobra.MinimumNArgs(2),
+	Run:  createRoots,
+}
+
+//
+// Return the set of cobra commands used for the registry subcommand
+//
+func CommandSuite() *cobra.Command {
+	cmd.AddCommand(showCmd)
+	addCmd.Flags().StringSliceVar(&addRoles, "role", nil, "role to give the investigators, may be repeated")
+	cmd.AddCommand(addCmd)
+	cmd.AddCommand(removeCmd)
+	cmd.AddCommand(signCmd)
+	cmd.AddCommand(enrollCmd)
+	cmd.AddCommand(rootsCmd)
+	return cmd
+}
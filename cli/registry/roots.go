@@ -0,0 +1,60 @@
+package registry
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strconv"
+
+	"github.com/coinbase/dexter/engine"
+
+	"github.com/fatih/color"
+	"github.com/spf13/cobra"
+)
+
+func createRoots(cmd *cobra.Command, args []string) {
+	threshold, err := strconv.Atoi(args[0])
+	if err != nil {
+		color.HiRed("invalid threshold: " + args[0])
+		os.Exit(1)
+	}
+	roots := engine.RegistryRoots{Threshold: threshold}
+	for _, filename := range args[1:] {
+		data, err := ioutil.ReadFile(filename)
+		if err != nil {
+			color.HiRed("unable to read investigator file: " + err.Error())
+			os.Exit(1)
+		}
+		var investigator engine.Investigator
+		err = json.Unmarshal(data, &investigator)
+		if err != nil {
+			color.HiRed("unable to parse investigator file " + filename + ": " + err.Error())
+			os.Exit(1)
+		}
+		if len(investigator.Keys) == 0 {
+			color.HiRed("investigator " + investigator.Name + " has a legacy RSA key, which must be rotated before they can be an admin")
+			os.Exit(1)
+		}
+		roots.Admins = append(roots.Admins, engine.RegistryAdmin{
+			Name: investigator.Name,
+			Key:  investigator.Keys[len(investigator.Keys)-1],
+		})
+	}
+	data, err := json.MarshalIndent(roots, "", "  ")
+	if err != nil {
+		color.HiRed("fatal error encoding registry roots: " + err.Error())
+		os.Exit(1)
+	}
+	_, err = engine.ParseRegistryRoots(data)
+	if err != nil {
+		color.HiRed(err.Error())
+		os.Exit(1)
+	}
+	err = ioutil.WriteFile("registry-roots.json", data, 0644)
+	if err != nil {
+		color.HiRed("fatal error writing registry roots: " + err.Error())
+		os.Exit(1)
+	}
+	color.Green("Registry roots file created: registry-roots.json")
+	color.Yellow("This must be installed on every daemon and investigator machine.")
+}
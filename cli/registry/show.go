@@ -0,0 +1,57 @@
+package registry
+
+import (
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+func showRegistry(cmd *cobra.Command, args []string) {
+	store := cliutil.Store()
+	roots := loadRoots()
+	current := currentRegistry(store, roots)
+	if current.Version == 0 {
+		color.HiYellow("No registry has been published yet.")
+	} else {
+		color.HiCyan("Registry version %d, issued %s, signed by %s",
+			current.Version, current.IssuedAt.Format("2006-01-02 15:04:05 MST"),
+			strings.Join(current.ValidSigners(roots), ", "))
+		table := tablewriter.NewWriter(os.Stdout)
+		table.SetHeader([]string{"Investigator", "Key", "Keys", "Roles", "Admin"})
+		table.SetHeaderColor(
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+			tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		)
+		for _, investigator := range current.Investigators {
+			admin := ""
+			if roots.IsAdmin(investigator) {
+				admin = "yes"
+			}
+			table.Append([]string{
+				investigator.Name,
+				keyDescription(investigator),
+				strconv.Itoa(len(investigator.Keys)),
+				strings.Join(investigator.Roles, ", "),
+				admin,
+			})
+		}
+		table.Render()
+	}
+
+	proposal, ok := pendingProposal(store, current)
+	if !ok {
+		return
+	}
+	printChanges(current, proposal)
+	color.HiCyan("Proposal signed by %d of the %d admins required: %s",
+		len(proposal.ValidSigners(roots)), roots.Threshold, strings.Join(proposal.ValidSigners(roots), ", "))
+}
@@ -2,27 +2,35 @@ package report
 
 import (
 	"os"
-	"path"
+	"strings"
 
-	"github.com/coinbase/dexter/engine/helpers"
+	"github.com/coinbase/dexter/cli/cliutil"
 
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
 func archiveReports(cmd *cobra.Command, args []string) {
-	files, err := helpers.ListS3Path("reports/")
-	if err != nil {
-		color.HiRed("unable to list reports: " + err.Error())
-		os.Exit(1)
-	}
-
-	for _, file := range files {
-		base := path.Base(file)
-		err = helpers.MoveS3File(file, "reports/_"+base)
+	store := cliutil.Store()
+	// reports are archived by prefixing the first path element with an
+	// underscore, which covers a whole report directory in the current
+	// layout and a single file in the legacy layout.  Archived reports are
+	// skipped, so moving them while the listing is still being read is safe
+	iterator := store.Iterate("reports/")
+	for iterator.Next() {
+		file := iterator.Key()
+		name := strings.TrimPrefix(file, "reports/")
+		if strings.HasPrefix(name, "_") {
+			continue
+		}
+		err := store.Move(file, "reports/_"+name)
 		if err != nil {
 			color.HiRed("error moving file for archive: " + err.Error())
 			os.Exit(1)
 		}
 	}
+	if err := iterator.Err(); err != nil {
+		color.HiRed("unable to list reports: " + err.Error())
+		os.Exit(1)
+	}
 }
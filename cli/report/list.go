@@ -2,17 +2,16 @@ package report
 
 import (
 	"os"
-	"regexp"
 	"strconv"
 	"strings"
 
+	"github.com/coinbase/dexter/cli/cliutil"
 	"github.com/coinbase/dexter/engine"
 	"github.com/coinbase/dexter/engine/helpers"
 	"github.com/coinbase/dexter/util"
 
 	"github.com/fatih/color"
 	"github.com/olekukonko/tablewriter"
-	log "github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
 
@@ -58,9 +57,9 @@ func listReports(cmd *cobra.Command, args []string) {
 
 	var list []Report
 	if showArchived {
-		list = AllReports()
+		list = AllReports(cliutil.Store())
 	} else {
-		list = CurrentReports()
+		list = CurrentReports(cliutil.Store())
 	}
 
 	for _, rep := range list {
@@ -81,53 +80,40 @@ func listReports(cmd *cobra.Command, args []string) {
 //
 // Return all reports, including archived ones.
 //
-func AllReports() []Report {
-	return getReports(true)
+func AllReports(store engine.Store) []Report {
+	return getReports(store, true)
 }
 
 //
 // Return all current reports.
 //
-func CurrentReports() []Report {
-	return getReports(false)
+func CurrentReports(store engine.Store) []Report {
+	return getReports(store, false)
 }
 
 //
 // List all currently available reports.  Accepts a boolean to
 // determine if archived reports should be returned as well.
 //
-func getReports(archived bool) []Report {
+func getReports(store engine.Store, archived bool) []Report {
 	allReportIDs := make([]string, 0)
 	reportedHosts := make(map[string][]string)
 	reportedUsers := make(map[string][]string)
 	reports := make([]Report, 0)
 
-	reportFiles, err := helpers.ListS3Path("reports/")
+	cachedInvestigations := engine.CurrentInvestigations(store)
+	files, err := engine.ReportFiles(store, archived)
 	if err != nil {
 		color.HiRed(err.Error())
 		return []Report{}
 	}
-	cachedInvestigations := engine.CurrentInvestigations()
-	for _, filename := range reportFiles {
-		reportFile := strings.TrimPrefix(filename, "reports/")
-		if string(reportFile[0]) == "_" && !archived {
-			continue
+	for _, file := range files {
+		uuid := file.ID
+		if file.Archived {
+			uuid = "_" + uuid
 		}
-		if !strings.HasSuffix(filename, ".zip.enc") {
-			continue
-		}
-		re := regexp.MustCompile(`reports/(.+?)-(.+)\.(.+)\.zip\.enc`)
-		matches := re.FindStringSubmatch(filename)
-		if len(matches) < 4 {
-			log.WithFields(log.Fields{
-				"at":       "report.CurrentReports",
-				"filename": filename,
-			}).Error("regex mismatch on filename")
-			continue
-		}
-		uuid := matches[1]
-		hostname := matches[2]
-		recipientName := matches[3]
+		hostname := file.Hostname
+		recipientName := file.Recipient
 
 		if !util.StringsInclude(allReportIDs, uuid) {
 			allReportIDs = append(allReportIDs, uuid)
@@ -140,7 +126,7 @@ func getReports(archived bool) []Report {
 		}
 	}
 	for _, uuid := range allReportIDs {
-		investigation, err := engine.InvestigationByIDWithCache(cachedInvestigations, uuid)
+		investigation, err := engine.InvestigationByIDWithCache(store, cachedInvestigations, uuid)
 		if err != nil {
 			color.HiRed("Error loading investigation for report " + uuid + ", investigation was probably archived: " + err.Error())
 		}
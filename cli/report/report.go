@@ -8,6 +8,7 @@ import (
 )
 
 var showArchived bool
+var allowUnsigned bool
 
 var cmd = &cobra.Command{
 	Use:   "report [cmd]",
@@ -35,16 +36,40 @@ var archiveCmd = &cobra.Command{
 var retrieveCmd = &cobra.Command{
 	Use:   "retrieve",
 	Short: "Download and decrypt a report",
-	Long:  `Download a report and decrypt it into a local directory`,
-	Args:  cobra.MinimumNArgs(1),
-	Run:   retrieveReport,
+	Long: `Download a report and decrypt it into a local directory.
+
+Each report is checked against the signature of the host that produced it
+before it is decrypted.  Reports from older daemons are not signed, and are
+skipped unless --allow-unsigned is given.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  retrieveReport,
+}
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify",
+	Short: "Verify the integrity of reports",
+	Long: `Check reports against the manifests and hashes signed by the hosts that
+produced them, printing a pass or fail result for each host.
+
+Arguments may be report directories written by retrieve, which are checked
+file by file against their signed manifest, or investigation IDs, whose
+encrypted reports in the store are checked without being decrypted.
+
+With --offline the store is not used, and the host key recorded in each
+manifest is trusted.  Its fingerprint is printed so it can be compared
+against the host's enrollment.  The command exits non-zero if anything fails.`,
+	Args: cobra.MinimumNArgs(1),
+	Run:  verifyReport,
 }
 
 func CommandSuite() *cobra.Command {
 	listCmd.PersistentFlags().BoolVar(&showArchived, "archived", false, "show archived reports")
+	retrieveCmd.PersistentFlags().BoolVar(&allowUnsigned, "allow-unsigned", false, "also retrieve unsigned reports from older daemons, which cannot be verified")
+	verifyCmd.PersistentFlags().BoolVar(&verifyOffline, "offline", false, "verify report directories without the store, trusting the host keys in their manifests")
 
 	cmd.AddCommand(listCmd)
 	cmd.AddCommand(archiveCmd)
 	cmd.AddCommand(retrieveCmd)
+	cmd.AddCommand(verifyCmd)
 	return cmd
 }
@@ -5,37 +5,27 @@ import (
 	"bytes"
 	"crypto/aes"
 	"crypto/cipher"
+	"crypto/sha256"
 	"encoding/json"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
-	"regexp"
 	"strings"
 
 	"github.com/coinbase/dexter/cli/cliutil"
 	"github.com/coinbase/dexter/engine"
-	"github.com/coinbase/dexter/engine/helpers"
 
-	log "github.com/sirupsen/logrus"
 	"github.com/fatih/color"
 	"github.com/spf13/cobra"
 )
 
-//
-// A ReportFile struct contains all the metadata contained in a report filename.
-//
-type ReportFile struct {
-	ID        string
-	Hostname  string
-	Recipient string
-}
-
-func (file *ReportFile) getDecryptionPayload() engine.DecryptionPayload {
-	decryptFile := "reports/" + file.ID + "-" + file.Hostname + "." + file.Recipient + ".decrypt"
-	decryptData, err := helpers.GetS3File(decryptFile)
+func getDecryptionPayload(store engine.Store, file engine.ReportFile) engine.DecryptionPayload {
+	decryptData, err := store.Get(file.DecryptionPayloadPath())
 	if err != nil {
-		color.HiRed("error getting file from S3: " + err.Error())
+		color.HiRed("error getting file from store: " + err.Error())
 		os.Exit(1)
 	}
 	var decryptPayload engine.DecryptionPayload
@@ -47,81 +37,290 @@ func (file *ReportFile) getDecryptionPayload() engine.DecryptionPayload {
 	return decryptPayload
 }
 
-func (file *ReportFile) getEncryptedBlob() []byte {
-	encryptedZipFile := "reports/" + file.ID + "-" + file.Hostname + "." + file.Recipient + ".zip.enc"
-	data, err := helpers.GetS3File(encryptedZipFile)
+//
+// Download an encrypted report to a temporary file, returning the file and
+// the SHA-256 hash of its contents.  The caller must remove the file.
+//
+func downloadEncryptedReport(store engine.Store, file engine.ReportFile) (*os.File, []byte) {
+	tmp, err := ioutil.TempFile("", "dexter-report-")
 	if err != nil {
-		color.HiRed("error getting file from S3: " + err.Error())
+		color.HiRed("error creating temporary file: " + err.Error())
 		os.Exit(1)
 	}
-	return data
+	_, err = engine.Download(store, file.EncryptedReportPath(), tmp)
+	if err != nil {
+		removeTempFile(tmp)
+		color.HiRed("error downloading report: " + err.Error())
+		os.Exit(1)
+	}
+	// parts of the report may arrive out of order, so it is hashed once
+	// the download is complete
+	hash := sha256.New()
+	_, err = tmp.Seek(0, io.SeekStart)
+	if err == nil {
+		_, err = io.Copy(hash, tmp)
+	}
+	if err != nil {
+		removeTempFile(tmp)
+		color.HiRed("error reading downloaded report: " + err.Error())
+		os.Exit(1)
+	}
+	return tmp, hash.Sum(nil)
+}
+
+//
+// Decrypt a downloaded report to a temporary zip file.  The whole report is
+// authenticated before the zip file is returned, so nothing is extracted
+// from a report that has been tampered with.  The caller must remove the file.
+//
+func decryptReport(encrypted *os.File, payload engine.DecryptionPayload, key []byte) (*os.File, error) {
+	if _, err := encrypted.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+	var plaintext io.Reader
+	switch payload.Format {
+	case engine.StreamFormat:
+		decrypter, err := engine.NewStreamDecrypter(encrypted, key)
+		if err != nil {
+			return nil, err
+		}
+		plaintext = decrypter
+	case "":
+		// reports uploaded before streaming encryption were sealed whole
+		ciphertext, err := ioutil.ReadAll(encrypted)
+		if err != nil {
+			return nil, err
+		}
+		zipData, err := decryptZip(ciphertext, key, payload.Nonce)
+		if err != nil {
+			return nil, err
+		}
+		plaintext = bytes.NewReader(zipData)
+	default:
+		return nil, errors.New("unsupported report format " + payload.Format)
+	}
+	zipFile, err := ioutil.TempFile("", "dexter-report-")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(zipFile, plaintext); err != nil {
+		removeTempFile(zipFile)
+		return nil, err
+	}
+	return zipFile, nil
+}
+
+func removeTempFile(file *os.File) {
+	file.Close()
+	os.Remove(file.Name())
+}
+
+//
+// Return the path a file in a report zip is extracted to, refusing any name
+// that would place it outside the host's report directory.
+//
+func reportFilePath(file engine.ReportFile, name string) (string, error) {
+	cleaned := path.Clean(name)
+	if path.IsAbs(cleaned) || cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") || strings.Contains(name, "\\") {
+		return "", errors.New("report contains a file outside its directory: " + name)
+	}
+	return "DexterReport-" + file.ID + "/" + file.Hostname + "/" + cleaned, nil
+}
+
+//
+// Extract every file in a decrypted report into the report directory.
+//
+func extractReport(file engine.ReportFile, zipFile *os.File) {
+	info, err := zipFile.Stat()
+	if err != nil {
+		color.HiRed("error reading decrypted report: " + err.Error())
+		return
+	}
+	reader, err := zip.NewReader(zipFile, info.Size())
+	if err != nil {
+		color.HiRed("error creating zip reader for report: " + err.Error())
+		os.Exit(1)
+	}
+	for _, zf := range reader.File {
+		destination, err := reportFilePath(file, zf.Name)
+		if err != nil {
+			color.HiRed("skipping file in report from host " + file.Hostname + ": " + err.Error())
+			continue
+		}
+		err = os.MkdirAll(filepath.FromSlash(path.Dir(destination)), 0700)
+		if err != nil {
+			color.HiRed("error creating report directory: " + err.Error())
+			continue
+		}
+		err = extractFile(zf, filepath.FromSlash(destination))
+		if err != nil {
+			color.HiRed("error writing report file: " + err.Error())
+		}
+	}
+}
+
+func extractFile(zf *zip.File, destination string) error {
+	src, err := zf.Open()
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(destination, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//
+// Check the ledger entry a host uploaded with its report records a run of
+// this exact investigation, and save it alongside the report for auditing.
+//
+func saveExecutionRecord(store engine.Store, file engine.ReportFile, investigation engine.Investigation, hostKey []byte) {
+	record, err := engine.ExecutionRecord(store, file)
+	if err != nil {
+		color.HiRed("no execution record found for host " + file.Hostname + ": " + err.Error())
+		return
+	}
+	if !record.SignatureValid() || !bytes.Equal(record.HostPublicKey, hostKey) {
+		color.HiRed("execution record for host " + file.Hostname + " has an invalid signature")
+	} else if !record.Covers(investigation) {
+		color.HiRed("execution record for host " + file.Hostname + " does not match this investigation")
+	}
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		color.HiRed("error encoding execution record: " + err.Error())
+		return
+	}
+	dir := "DexterReport-" + file.ID + "/" + file.Hostname
+	err = os.MkdirAll(filepath.FromSlash(dir), 0700)
+	if err == nil {
+		err = ioutil.WriteFile(filepath.FromSlash(dir+"/execution.json"), data, 0644)
+	}
+	if err != nil {
+		color.HiRed("error writing execution record: " + err.Error())
+	}
+}
+
+//
+// Check an extracted report against the manifest signed by its host.
+//
+func verifyManifest(file engine.ReportFile, hostKey []byte) {
+	dir := "DexterReport-" + file.ID + "/" + file.Hostname
+	_, problems, err := engine.VerifyReportManifest(dir, hostKey, file.ID, file.Hostname, "execution.json")
+	if err == engine.ErrNoManifest {
+		color.HiYellow("report from host " + file.Hostname + " has no manifest, it was produced by an older daemon")
+		return
+	} else if err != nil {
+		color.HiRed("report from host " + file.Hostname + " failed verification: " + err.Error())
+		return
+	}
+	for _, problem := range problems {
+		color.HiRed("report from host " + file.Hostname + ": " + problem)
+	}
+	if len(problems) == 0 {
+		color.HiGreen("Report from host " + file.Hostname + " matches its signed manifest")
+	}
 }
 
 func retrieveReport(cmd *cobra.Command, args []string) {
-	uuid, err := helpers.ResolveUUID(args[0])
+	store := cliutil.Store()
+	uuid, err := engine.ResolveUUID(store, args[0])
 	if err != nil {
 		color.HiRed(err.Error())
 		os.Exit(1)
 	}
 	name := engine.LocalInvestigatorName()
+	investigation, err := engine.InvestigationByID(store, uuid)
+	checkExecutions := err == nil
+	if !checkExecutions {
+		color.HiYellow("unable to load investigation, execution records will not be checked: " + err.Error())
+	}
 
-	files := filterFiles(uuid, name, ReportFiles())
+	reportFiles, err := engine.ReportFiles(store, false)
+	if err != nil {
+		color.HiRed("unable to list reports: " + err.Error())
+		os.Exit(1)
+	}
+	files := filterFiles(uuid, name, reportFiles)
+	// keys are only unlocked once a report needs them
+	var keys engine.KeySource
 	for _, file := range files {
-		dataEncryptionKey := file.getDecryptionPayload().GetEncryptionKey(cliutil.CollectPassword)
-		decryptedZip := decryptZip(file.getEncryptedBlob(), dataEncryptionKey, file.getDecryptionPayload().Nonce)
-		reader, err := zip.NewReader(bytes.NewReader(decryptedZip), int64(len(decryptedZip)))
-		if err != nil {
-			color.HiRed("error creating zip reader for report: " + err.Error())
-			os.Exit(1)
-		}
-		for _, zf := range reader.File {
-			dir := "DexterReport-" + uuid + "/" + file.Hostname + "/" + path.Dir(zf.Name)
-			err = os.MkdirAll(filepath.FromSlash(dir), 0700)
-			if err != nil {
-				color.HiRed("error creating report directory: " + err.Error())
+		payload := getDecryptionPayload(store, file)
+		// reports from older daemons carry no host signature, manifest or
+		// execution record, so nothing about them can be checked
+		unsigned := len(payload.HostSignature) == 0 && payload.Rewrap == nil
+		var hostKey []byte
+		if unsigned {
+			if !allowUnsigned {
+				color.HiRed("skipping unsigned report from host " + file.Hostname + ", reports from older daemons are only retrieved with --allow-unsigned")
 				continue
 			}
-			file := dir + "/" + path.Base(zf.Name)
-			src, err := zf.Open()
+			color.HiYellow("report from host " + file.Hostname + " is not signed and cannot be verified, it was produced by an older daemon")
+		} else {
+			hostKey, err = engine.HostPublicKey(store, file.Hostname)
 			if err != nil {
-				color.HiRed("error opening zipped file: " + err.Error())
+				color.HiRed("skipping report: " + err.Error())
 				continue
 			}
-			buf := new(bytes.Buffer)
-			buf.ReadFrom(src)
-			err = ioutil.WriteFile(file, buf.Bytes(), 0644)
+		}
+		encrypted, reportHash := downloadEncryptedReport(store, file)
+		if !unsigned {
+			err = payload.VerifyReport(store, hostKey, file.ID, file.Hostname, file.Recipient, reportHash)
 			if err != nil {
-				color.HiRed("error writing report file: " + err.Error())
+				removeTempFile(encrypted)
+				color.HiRed("skipping report from host " + file.Hostname + ": " + err.Error())
 				continue
 			}
-			src.Close()
+			if checkExecutions {
+				saveExecutionRecord(store, file, investigation, hostKey)
+			}
+		}
+		if keys == nil {
+			keys = cliutil.LocalKeys()
+		}
+		dataEncryptionKey := payload.GetEncryptionKey(keys)
+		zipFile, err := decryptReport(encrypted, payload, dataEncryptionKey)
+		removeTempFile(encrypted)
+		if err != nil {
+			color.HiRed("error decrypting report from host " + file.Hostname + ": " + err.Error())
+			continue
+		}
+		extractReport(file, zipFile)
+		removeTempFile(zipFile)
+		if !unsigned {
+			verifyManifest(file, hostKey)
 		}
 	}
 }
 
-func decryptZip(ciphertext []byte, key []byte, nonce []byte) []byte {
+//
+// Decrypt a report sealed whole, as older daemons uploaded them.
+//
+func decryptZip(ciphertext []byte, key []byte, nonce []byte) ([]byte, error) {
 	block, err := aes.NewCipher(key)
 	if err != nil {
-		color.HiRed("decryption error creating cipher: " + err.Error())
-		os.Exit(1)
+		return nil, errors.New("decryption error creating cipher: " + err.Error())
 	}
 
 	aesgcm, err := cipher.NewGCM(block)
 	if err != nil {
-		color.HiRed("decryption error creating GCM block: " + err.Error())
-		os.Exit(1)
+		return nil, errors.New("decryption error creating GCM block: " + err.Error())
 	}
 
 	plaintext, err := aesgcm.Open(nil, nonce, ciphertext, nil)
 	if err != nil {
-		color.HiRed("decryption error: " + err.Error())
-		os.Exit(1)
+		return nil, errors.New("decryption error: " + err.Error())
 	}
-	return plaintext
+	return plaintext, nil
 }
 
-func filterFiles(uuid, user string, set []ReportFile) []ReportFile {
-	filtered := make([]ReportFile, 0)
+func filterFiles(uuid, user string, set []engine.ReportFile) []engine.ReportFile {
+	filtered := make([]engine.ReportFile, 0)
 	for _, file := range set {
 		if file.ID == uuid && file.Recipient == user {
 			filtered = append(filtered, file)
@@ -129,49 +328,3 @@ func filterFiles(uuid, user string, set []ReportFile) []ReportFile {
 	}
 	return filtered
 }
-
-//
-// List all files in the Dexter S3 bucket reports directory.
-//
-func ReportFiles() []ReportFile {
-	files := make([]ReportFile, 0)
-	stored := make(map[string]bool)
-
-	filenames, err := helpers.ListS3Path("reports/")
-	if err != nil {
-		color.HiRed("unable to get report file from S3: " + err.Error())
-		os.Exit(1)
-	}
-
-	re := regexp.MustCompile(`reports/(.+?)-(.+)\.(.+)\.zip\.enc`)
-	for _, filename := range filenames {
-		if !strings.HasSuffix(filename, ".zip.enc") {
-			continue
-		}
-		matches := re.FindStringSubmatch(filename)
-		if len(matches) < 4 {
-			log.WithFields(log.Fields{
-				"at":       "report.CurrentReports",
-				"filename": filename,
-			}).Error("regex mismatch on filename")
-			continue
-		}
-		uuid := matches[1]
-		hostname := matches[2]
-		recipientName := matches[3]
-
-		key := uuid + "." + hostname + "." + recipientName
-		if _, present := stored[key]; !present {
-			files = append(
-				files,
-				ReportFile{
-					ID:        uuid,
-					Hostname:  hostname,
-					Recipient: recipientName,
-				},
-			)
-			stored[key] = true
-		}
-	}
-	return files
-}
@@ -0,0 +1,278 @@
+package report
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coinbase/dexter/cli/cliutil"
+	"github.com/coinbase/dexter/engine"
+
+	"github.com/fatih/color"
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+)
+
+var verifyOffline bool
+
+//
+// The outcome of verifying one part of a report.
+//
+type verification struct {
+	host    string
+	checked string
+	hostKey []byte
+	passed  bool
+	detail  string
+}
+
+//
+// Find the host directories of an extracted report and the investigation
+// they belong to.  The directory may be the DexterReport-<id> directory
+// written by retrieve, or one host directory inside it.
+//
+func reportDirectories(dir string) (string, []string, error) {
+	dir = filepath.Clean(dir)
+	if _, err := os.Stat(filepath.Join(dir, engine.ManifestName)); err == nil {
+		id, err := reportID(filepath.Dir(dir))
+		return id, []string{dir}, err
+	}
+	id, err := reportID(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return "", nil, err
+	}
+	hosts := []string{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			hosts = append(hosts, filepath.Join(dir, entry.Name()))
+		}
+	}
+	return id, hosts, nil
+}
+
+func reportID(dir string) (string, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	base := filepath.Base(abs)
+	if !strings.HasPrefix(base, "DexterReport-") {
+		return "", errors.New(dir + " is not named for an investigation")
+	}
+	return strings.TrimPrefix(base, "DexterReport-"), nil
+}
+
+//
+// Verify the files extracted for one host against the manifest it signed,
+// and the execution record saved alongside them.  Offline, the key in the
+// manifest is trusted and its fingerprint must be checked by hand.
+//
+func verifyDirectory(store engine.Store, investigation *engine.Investigation, id, dir string) verification {
+	host := filepath.Base(dir)
+	result := verification{host: host, checked: "extracted files"}
+	if verifyOffline {
+		manifest, err := engine.ReadReportManifest(dir)
+		if err != nil {
+			result.detail = err.Error()
+			return result
+		}
+		result.hostKey = manifest.HostPublicKey
+	} else {
+		key, err := engine.HostPublicKey(store, host)
+		if err != nil {
+			result.detail = err.Error()
+			return result
+		}
+		result.hostKey = key
+	}
+
+	manifest, problems, err := engine.VerifyReportManifest(dir, result.hostKey, id, host, "execution.json")
+	if err == engine.ErrNoManifest {
+		result.detail = "report has no manifest, it was produced by an older daemon"
+		return result
+	} else if err != nil {
+		result.detail = err.Error()
+		return result
+	}
+	if problem := checkSavedExecutionRecord(dir, investigation, id, result.hostKey); problem != "" {
+		problems = append(problems, problem)
+	}
+	if len(problems) > 0 {
+		result.detail = strings.Join(problems, "\n")
+		return result
+	}
+	count := 0
+	for _, run := range manifest.Tasks {
+		count += len(run.Artifacts)
+	}
+	result.passed = true
+	result.detail = strconv.Itoa(count) + " files match the signed manifest"
+	return result
+}
+
+//
+// Check the execution record retrieve saved with a report, if there is one.
+//
+func checkSavedExecutionRecord(dir string, investigation *engine.Investigation, id string, hostKey []byte) string {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "execution.json"))
+	if os.IsNotExist(err) {
+		return ""
+	} else if err != nil {
+		return "execution record could not be read: " + err.Error()
+	}
+	var record engine.LedgerEntry
+	err = json.Unmarshal(data, &record)
+	if err != nil {
+		return "execution record could not be parsed: " + err.Error()
+	}
+	if !record.SignatureValid() || !bytes.Equal(record.HostPublicKey, hostKey) {
+		return "execution record has an invalid signature"
+	}
+	if record.InvestigationID != id || (investigation != nil && !record.Covers(*investigation)) {
+		return "execution record does not match this investigation"
+	}
+	return ""
+}
+
+//
+// Verify the encrypted reports in the store for an investigation against
+// the hashes their hosts signed.  No keys are needed, so every recipient's
+// copy is checked.
+//
+func verifyEncrypted(store engine.Store, uuid string) []verification {
+	reportFiles, err := engine.ReportFiles(store, false)
+	if err != nil {
+		color.HiRed("unable to list reports: " + err.Error())
+		os.Exit(1)
+	}
+	results := []verification{}
+	for _, file := range reportFiles {
+		if file.ID != uuid {
+			continue
+		}
+		result := verification{host: file.Hostname, checked: "encrypted report for " + file.Recipient}
+		hostKey, err := engine.HostPublicKey(store, file.Hostname)
+		if err != nil {
+			result.detail = err.Error()
+			results = append(results, result)
+			continue
+		}
+		result.hostKey = hostKey
+		payload := getDecryptionPayload(store, file)
+		encrypted, reportHash := downloadEncryptedReport(store, file)
+		removeTempFile(encrypted)
+		err = payload.VerifyReport(store, hostKey, file.ID, file.Hostname, file.Recipient, reportHash)
+		if err != nil {
+			result.detail = err.Error()
+		} else {
+			result.passed = true
+			result.detail = "sha256 " + hex.EncodeToString(reportHash)
+		}
+		results = append(results, result)
+	}
+	return results
+}
+
+func verifyReport(cmd *cobra.Command, args []string) {
+	var store engine.Store
+	if !verifyOffline {
+		store = cliutil.Store()
+	}
+	results := []verification{}
+	for _, arg := range args {
+		if info, err := os.Stat(arg); err == nil && info.IsDir() {
+			id, dirs, err := reportDirectories(arg)
+			if err != nil {
+				color.HiRed(arg + " is not a report directory written by dexter report retrieve")
+				os.Exit(1)
+			}
+			var investigation *engine.Investigation
+			if store != nil {
+				if found, err := engine.InvestigationByID(store, id); err == nil {
+					investigation = &found
+				}
+			}
+			for _, dir := range dirs {
+				results = append(results, verifyDirectory(store, investigation, id, dir))
+			}
+			continue
+		}
+		if verifyOffline {
+			color.HiRed(arg + " is not a directory, encrypted reports can only be verified against the store")
+			os.Exit(1)
+		}
+		uuid, err := engine.ResolveUUID(store, arg)
+		if err != nil {
+			color.HiRed(err.Error())
+			os.Exit(1)
+		}
+		results = append(results, verifyEncrypted(store, uuid)...)
+	}
+	if len(results) == 0 {
+		color.HiYellow("no reports found to verify")
+		os.Exit(1)
+	}
+	sort.SliceStable(results, func(i, j int) bool {
+		return results[i].host < results[j].host
+	})
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetAutoWrapText(false)
+	table.SetHeader([]string{
+		"Host",
+		"Checked",
+		"Host Key",
+		"Result",
+		"Detail",
+	})
+
+	table.SetHeaderColor(
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiCyanColor},
+	)
+
+	table.SetColumnColor(
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+		tablewriter.Colors{tablewriter.Bold, tablewriter.FgHiWhiteColor},
+		tablewriter.Colors{tablewriter.FgHiYellowColor},
+	)
+
+	failed := false
+	for _, result := range results {
+		outcome := "PASS"
+		if !result.passed {
+			outcome = "FAIL"
+			failed = true
+		}
+		table.Append([]string{
+			result.host,
+			result.checked,
+			engine.HostKeyFingerprint(result.hostKey),
+			outcome,
+			result.detail,
+		})
+	}
+	table.Render()
+	if verifyOffline {
+		color.HiYellow("verified offline: compare each host key fingerprint against the host's enrollment")
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
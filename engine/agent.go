@@ -0,0 +1,203 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"os"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// A dexter agent is a long running process that holds the local
+// investigator's unlocked keys and uses them on behalf of other dexter
+// commands, in the manner of ssh-agent.  Clients connect to its unix
+// socket and send one request per connection.  Private keys never leave
+// the agent.
+//
+const (
+	agentOperationSign   = "sign"
+	agentOperationUnwrap = "unwrap"
+)
+
+type agentRequest struct {
+	Operation string
+	Name      string            `json:",omitempty"`
+	Digest    []byte            `json:",omitempty"`
+	Payload   DecryptionPayload `json:",omitempty"`
+}
+
+type agentResponse struct {
+	Signature Signature `json:",omitempty"`
+	Key       []byte    `json:",omitempty"`
+	Error     string    `json:",omitempty"`
+}
+
+//
+// Listen on a unix socket for agent clients.  A stale socket left by an
+// agent that did not exit cleanly is replaced, and the socket is only
+// usable by the current user.
+//
+func ListenAgent(path string) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, errors.New(path + " exists and is not a socket")
+		}
+		if conn, err := net.Dial("unix", path); err == nil {
+			conn.Close()
+			return nil, errors.New("an agent is already listening on " + path)
+		}
+		os.Remove(path)
+	}
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	err = os.Chmod(path, 0600)
+	if err != nil {
+		listener.Close()
+		return nil, err
+	}
+	return listener, nil
+}
+
+//
+// Serve agent requests with the keys in a key source until the listener
+// is closed.
+//
+func ServeAgent(listener net.Listener, keys KeySource) error {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ne, ok := err.(net.Error); ok && ne.Temporary() {
+				continue
+			}
+			return err
+		}
+		go serveAgentConn(conn, keys)
+	}
+}
+
+func serveAgentConn(conn net.Conn, keys KeySource) {
+	defer conn.Close()
+	var request agentRequest
+	err := json.NewDecoder(conn).Decode(&request)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.serveAgentConn",
+			"error": err.Error(),
+		}).Error("unable to read agent request")
+		return
+	}
+	response := handleAgentRequest(request, keys)
+	log.WithFields(log.Fields{
+		"at":        "engine.serveAgentConn",
+		"operation": request.Operation,
+		"error":     response.Error,
+	}).Info("agent request handled")
+	err = json.NewEncoder(conn).Encode(response)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.serveAgentConn",
+			"error": err.Error(),
+		}).Error("unable to write agent response")
+	}
+}
+
+func handleAgentRequest(request agentRequest, keys KeySource) agentResponse {
+	switch request.Operation {
+	case agentOperationSign:
+		signer, err := keys.Signer()
+		if err != nil {
+			return agentResponse{Error: err.Error()}
+		}
+		sig, err := signer.Sign(request.Name, request.Digest)
+		if err != nil {
+			return agentResponse{Error: err.Error()}
+		}
+		return agentResponse{Signature: sig}
+	case agentOperationUnwrap:
+		decrypter, err := keys.Decrypter(request.Payload.KeyID)
+		if err != nil {
+			return agentResponse{Error: err.Error()}
+		}
+		key, err := decrypter.UnwrapKey(request.Payload)
+		if err != nil {
+			return agentResponse{Error: err.Error()}
+		}
+		return agentResponse{Key: key}
+	}
+	return agentResponse{Error: "unknown agent operation \"" + request.Operation + "\""}
+}
+
+//
+// An AgentClient is a key source backed by a running dexter agent.
+//
+type AgentClient struct {
+	socket string
+}
+
+//
+// Create a client for the agent listening on a unix socket.
+//
+func NewAgentClient(socket string) *AgentClient {
+	return &AgentClient{socket: socket}
+}
+
+func (client *AgentClient) call(request agentRequest) (agentResponse, error) {
+	conn, err := net.Dial("unix", client.socket)
+	if err != nil {
+		return agentResponse{}, errors.New("unable to reach dexter agent: " + err.Error())
+	}
+	defer conn.Close()
+	err = json.NewEncoder(conn).Encode(request)
+	if err != nil {
+		return agentResponse{}, err
+	}
+	var response agentResponse
+	err = json.NewDecoder(conn).Decode(&response)
+	if err != nil {
+		return agentResponse{}, errors.New("unable to read dexter agent response: " + err.Error())
+	}
+	if response.Error != "" {
+		return agentResponse{}, errors.New("dexter agent: " + response.Error)
+	}
+	return response, nil
+}
+
+//
+// Return a signer that asks the agent to sign with its current key.
+//
+func (client *AgentClient) Signer() (Signer, error) {
+	return agentKey{client}, nil
+}
+
+//
+// Return a decrypter that asks the agent to unwrap report keys.  The agent
+// chooses the key named in each payload.
+//
+func (client *AgentClient) Decrypter(keyID string) (Decrypter, error) {
+	return agentKey{client}, nil
+}
+
+type agentKey struct {
+	client *AgentClient
+}
+
+func (key agentKey) Sign(name string, digest []byte) (Signature, error) {
+	response, err := key.client.call(agentRequest{
+		Operation: agentOperationSign,
+		Name:      name,
+		Digest:    digest,
+	})
+	return response.Signature, err
+}
+
+func (key agentKey) UnwrapKey(payload DecryptionPayload) ([]byte, error) {
+	response, err := key.client.call(agentRequest{
+		Operation: agentOperationUnwrap,
+		Payload:   payload,
+	})
+	return response.Key, err
+}
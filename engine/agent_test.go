@@ -0,0 +1,65 @@
+package engine_test
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAgentSignsAndUnwrapsWithoutExposingKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	investigator, keyPEM, err := engine.NewInvestigator("alice", "password")
+	assert.Nil(err)
+	keyring, err := engine.ParseKeyring(keyPEM, "password")
+	assert.Nil(err)
+
+	dir, err := ioutil.TempDir("", "dexter-agent")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	socket := filepath.Join(dir, "agent.sock")
+	listener, err := engine.ListenAgent(socket)
+	assert.Nil(err)
+	done := make(chan error)
+	go func() { done <- engine.ServeAgent(listener, keyring) }()
+
+	// a second agent cannot take over the socket
+	_, err = engine.ListenAgent(socket)
+	assert.NotNil(err)
+
+	client := engine.NewAgentClient(socket)
+	signer, err := client.Signer()
+	assert.Nil(err)
+	digest := sha256.Sum256([]byte("investigation"))
+	sig, err := signer.Sign("alice", digest[:])
+	assert.Nil(err)
+	assert.Equal("alice", sig.Name)
+	assert.True(investigator.VerifySignature(digest[:], sig))
+
+	payload, err := investigator.WrapKey([]byte("0123456789abcdef"))
+	assert.Nil(err)
+	decrypter, err := client.Decrypter(payload.KeyID)
+	assert.Nil(err)
+	dataKey, err := decrypter.UnwrapKey(payload)
+	assert.Nil(err)
+	assert.Equal([]byte("0123456789abcdef"), dataKey)
+
+	payload.KeyID = "0000000000000000"
+	_, err = decrypter.UnwrapKey(payload)
+	assert.NotNil(err)
+
+	listener.Close()
+	assert.NotNil(<-done)
+	_, err = signer.Sign("alice", digest[:])
+	assert.NotNil(err)
+
+	// the socket left behind is replaced by the next agent
+	listener, err = engine.ListenAgent(socket)
+	assert.Nil(err)
+	listener.Close()
+}
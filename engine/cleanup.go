@@ -15,41 +15,40 @@ import (
 
 func (investigation *Investigation) cleanup() {
 	investigation.removeReportArtifacts()
-	if investigation.KillContainers {
-		killContainers()
-	}
 	log.WithFields(log.Fields{
 		"at":            "engine.cleanup",
 		"investigation": investigation.ID,
 	}).Info("investigation complete")
+}
+
+//
+// Return true if the investigation asks for containers or the host
+// to be terminated once it is complete.
+//
+func (investigation *Investigation) destructive() bool {
+	return investigation.KillContainers || investigation.KillHost
+}
+
+//
+// Terminate containers and the host if the investigation asks for it.
+// The caller must ensure no other investigation is in flight.
+//
+func (investigation *Investigation) destroy() {
+	if investigation.KillContainers {
+		killContainers()
+	}
 	if investigation.KillHost {
 		shutdownHost()
 	}
 }
 
 func (investigation *Investigation) removeReportArtifacts() {
-	err := os.RemoveAll(filepath.FromSlash(investigation.ReportDirectory()))
-	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.removeReportArtifacts",
-			"error": err.Error(),
-		}).Error("error removing report directory")
-	}
-	err = nil
-	err = os.Remove(investigation.ReportZip())
-	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.removeReportArtifacts",
-			"error": err.Error(),
-		}).Error("error removing report zip")
-	}
-	err = nil
-	err = os.Remove(investigation.ReportZip() + ".enc")
+	err := os.RemoveAll(filepath.FromSlash(investigation.Workspace()))
 	if err != nil {
 		log.WithFields(log.Fields{
 			"at":    "engine.removeReportArtifacts",
 			"error": err.Error(),
-		}).Error("error removing encrypted report zip")
+		}).Error("error removing investigation workspace")
 	}
 }
 
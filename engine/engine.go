@@ -4,25 +4,73 @@
 package engine
 
 import (
+	"github.com/coinbase/dexter/engine/helpers"
+
 	log "github.com/sirupsen/logrus"
 )
 
 //
-// Poll for investigations, validate them, and run the tasks if in scope.
+// Poll for investigations in the store, validate them, and run the tasks if in scope.
+// Investigations are handed to a pool of workers so that several can run at once.
 //
-func Start() {
-	for investigation := range NewS3Poller().Poll() {
-		err := investigation.validate()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"at":            "engine.Start",
-				"investigation": investigation.ID,
-			}).Error(err)
-			continue
-		}
-
-		investigation.run()
-		investigation.report()
-		investigation.cleanup()
+func Start(store Store) {
+	identity, err := LoadHostIdentity()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.Start",
+			"error": err.Error(),
+		}).Fatal("unable to load host identity key")
+	}
+	ledger, err := OpenLedger(helpers.GetDexterLedgerFile(), identity)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.Start",
+			"error": err.Error(),
+			"file":  helpers.GetDexterLedgerFile(),
+		}).Fatal("unable to open execution ledger")
+	}
+	roots, err := LoadRegistryRoots()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.Start",
+			"error": err.Error(),
+		}).Fatal("unable to load registry roots")
+	}
+	if roots == nil {
+		log.WithFields(log.Fields{
+			"at": "engine.Start",
+		}).Warn("unsigned investigators are allowed, every investigator file in the store is trusted")
+	}
+	poller, err := NewStorePoller(store, roots, helpers.GetDexterStateFile())
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.Start",
+			"error": err.Error(),
+			"file":  helpers.GetDexterStateFile(),
+		}).Fatal("unable to load daemon state")
+	}
+	policy, err := LoadPolicy(roots)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.Start",
+			"error": err.Error(),
+		}).Fatal("unable to load approval policy")
+	}
+	hostPolicy, err := LoadHostPolicy()
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.Start",
+			"error": err.Error(),
+		}).Fatal("unable to load host policy")
+	}
+	if hostPolicy == nil {
+		log.WithFields(log.Fields{
+			"at": "engine.Start",
+		}).Warn("no host policy configured, every task is allowed on this host")
+	}
+	pool := newWorkerPool(store, identity, ledger, roots, policy, hostPolicy, helpers.WorkerCount(), poller.Evaluated)
+	for investigation := range poller.Poll() {
+		pool.submit(investigation)
 	}
+	pool.close()
 }
@@ -0,0 +1,31 @@
+package engine
+
+//
+// Forget every remembered revocation, so one test's revocations do not
+// carry over to the next test to use the same names.
+//
+func ForgetRevocations() {
+	verifiedRevocations.Lock()
+	defer verifiedRevocations.Unlock()
+	verifiedRevocations.byName = nil
+}
+
+//
+// Forget the newest registry version accepted and any file it is kept in,
+// as if this were a new process.
+//
+func ForgetRegistryFloor() {
+	registryFloor.Lock()
+	defer registryFloor.Unlock()
+	registryFloor.version = 0
+	registryFloor.file = ""
+}
+
+//
+// Validate an investigation as the daemon would with no policies, returning
+// whether a failure should be tried again rather than settled.
+//
+func ValidateInvestigation(investigation *Investigation, store Store) (bool, error) {
+	err := investigation.validate(newTrustedInvestigatorsWithRoots(store, nil), nil, nil)
+	return isTrustLoadError(err), err
+}
@@ -5,32 +5,53 @@ import (
 	"os"
 	"strconv"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
-	"github.com/aws/aws-sdk-go/aws"
 )
 
 var osquerySocket string
 var pollInterval int
+var workerCount int
+var taskTimeout time.Duration
+var consensusTimeout time.Duration
 var stubbedProjectName string
-var s3String *string
+var s3Bucket string
 
 //
-// Return an AWS string containing the S3 bucket defined by the DEXTER_AWS_S3_BUCKET environment variable
+// Defines a local directory to use as the backend for Dexter.
+// Overrides all calls to S3, used for demo purposes.
 //
-func S3Bucket() *string {
-	if LocalDemoPath != "" {
-		return aws.String("local://" + LocalDemoPath)
-	}
-	if s3String == nil {
-		s3String = aws.String(os.Getenv("DEXTER_AWS_S3_BUCKET"))
-		if *s3String == "" {
+var LocalDemoPath = ""
+
+//
+// Return the S3 bucket defined by the DEXTER_AWS_S3_BUCKET environment variable
+//
+func S3Bucket() string {
+	if s3Bucket == "" {
+		s3Bucket = os.Getenv("DEXTER_AWS_S3_BUCKET")
+		if s3Bucket == "" {
 			log.WithFields(log.Fields{
 				"at": "helpers.S3Bucket",
 			}).Fatal("dexter bucket not specified")
 		}
 	}
-	return s3String
+	return s3Bucket
+}
+
+//
+// Return the URL of the store Dexter should use.  The local demo path takes
+// precedence, followed by the DEXTER_STORE environment variable, and finally
+// the S3 bucket defined by DEXTER_AWS_S3_BUCKET.
+//
+func StoreURL() string {
+	if LocalDemoPath != "" {
+		return "file://" + LocalDemoPath
+	}
+	if url := os.Getenv("DEXTER_STORE"); url != "" {
+		return url
+	}
+	return "s3://" + S3Bucket()
 }
 
 //
@@ -67,6 +88,107 @@ func PollInterval() int {
 	return pollInterval
 }
 
+//
+// Lookup and cache the number of investigations the daemon may run at once
+//
+func WorkerCount() int {
+	if workerCount > 0 {
+		return workerCount
+	}
+
+	envarName := "DEXTER_WORKERS"
+	countStr := os.Getenv(envarName)
+	if countStr == "" {
+		log.WithFields(log.Fields{
+			"at":    "helpers.WorkerCount",
+			"envar": envarName,
+		}).Warn("worker count envar not set, using 4 workers")
+		workerCount = 4
+		return 4
+	}
+
+	count, err := strconv.Atoi(countStr)
+	if err != nil || count < 1 {
+		log.WithFields(log.Fields{
+			"at":    "helpers.WorkerCount",
+			"value": countStr,
+		}).Warn("unable to convert worker count to a positive int, using 4 workers")
+		workerCount = 4
+		return 4
+	}
+
+	workerCount = count
+	return workerCount
+}
+
+//
+// Lookup and cache the longest time a single task may run before it is
+// abandoned and recorded as an error in the report
+//
+func TaskTimeout() time.Duration {
+	if taskTimeout > 0 {
+		return taskTimeout
+	}
+
+	envarName := "DEXTER_TASK_TIMEOUT_SECONDS"
+	timeoutStr := os.Getenv(envarName)
+	if timeoutStr == "" {
+		log.WithFields(log.Fields{
+			"at":    "helpers.TaskTimeout",
+			"envar": envarName,
+		}).Warn("task timeout envar not set, using 3600 seconds")
+		taskTimeout = 3600 * time.Second
+		return taskTimeout
+	}
+
+	timeout, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeout < 1 {
+		log.WithFields(log.Fields{
+			"at":    "helpers.TaskTimeout",
+			"value": timeoutStr,
+		}).Warn("unable to convert task timeout to a positive int, using 3600 seconds")
+		taskTimeout = 3600 * time.Second
+		return taskTimeout
+	}
+
+	taskTimeout = time.Duration(timeout) * time.Second
+	return taskTimeout
+}
+
+//
+// Lookup and cache how long the daemon keeps re-checking an investigation
+// that is waiting for approvals before giving up on it
+//
+func ConsensusTimeout() time.Duration {
+	if consensusTimeout > 0 {
+		return consensusTimeout
+	}
+
+	envarName := "DEXTER_CONSENSUS_TIMEOUT_SECONDS"
+	timeoutStr := os.Getenv(envarName)
+	if timeoutStr == "" {
+		log.WithFields(log.Fields{
+			"at":    "helpers.ConsensusTimeout",
+			"envar": envarName,
+		}).Warn("consensus timeout envar not set, using 86400 seconds")
+		consensusTimeout = 86400 * time.Second
+		return consensusTimeout
+	}
+
+	timeout, err := strconv.Atoi(timeoutStr)
+	if err != nil || timeout < 1 {
+		log.WithFields(log.Fields{
+			"at":    "helpers.ConsensusTimeout",
+			"value": timeoutStr,
+		}).Warn("unable to convert consensus timeout to a positive int, using 86400 seconds")
+		consensusTimeout = 86400 * time.Second
+		return consensusTimeout
+	}
+
+	consensusTimeout = time.Duration(timeout) * time.Second
+	return consensusTimeout
+}
+
 //
 // Lookup and cache the osquery socket
 //
@@ -134,3 +256,103 @@ func ProjectName() string {
 	}).Fatal("error parsing project name configuration")
 	return ""
 }
+
+//
+// Lookup the size, in megabytes, of each part used to transfer large files
+// to and from S3.  Parts must be at least 5 MB, the smallest part S3 accepts.
+//
+func S3PartSizeMB() int {
+	envarName := "DEXTER_S3_PART_SIZE_MB"
+	sizeStr := os.Getenv(envarName)
+	if sizeStr == "" {
+		return 16
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size < 5 {
+		log.WithFields(log.Fields{
+			"at":    "helpers.S3PartSizeMB",
+			"value": sizeStr,
+		}).Warn("unable to convert part size to an int of at least 5, using 16 MB")
+		return 16
+	}
+	return size
+}
+
+//
+// Return true if investigators may be trusted from the investigators
+// directory of the store when no registry roots are configured, set with
+// DEXTER_ALLOW_UNSIGNED_INVESTIGATORS.  Anyone who can write to the store
+// can then add investigators, so this is only meant for migrating.
+//
+func AllowUnsignedInvestigators() bool {
+	allowed, _ := strconv.ParseBool(os.Getenv("DEXTER_ALLOW_UNSIGNED_INVESTIGATORS"))
+	return allowed
+}
+
+//
+// Return the socket of a running dexter agent that holds the local
+// investigator's keys, set with DEXTER_AGENT_SOCK.  An empty string means
+// no agent is used.
+//
+func AgentSocket() string {
+	return os.Getenv("DEXTER_AGENT_SOCK")
+}
+
+//
+// Return the path of the PKCS#11 module used to reach the token holding
+// the local investigator's keys, set with DEXTER_PKCS11_MODULE.  An empty
+// string means keys are held in the local key file.
+//
+func PKCS11Module() string {
+	return os.Getenv("DEXTER_PKCS11_MODULE")
+}
+
+//
+// Return the label of the PKCS#11 token holding the local investigator's
+// keys, set with DEXTER_PKCS11_TOKEN.  An empty string selects the first
+// token found.
+//
+func PKCS11Token() string {
+	return os.Getenv("DEXTER_PKCS11_TOKEN")
+}
+
+//
+// Lookup the size, in megabytes, of the largest file get-file will collect,
+// set with DEXTER_GET_FILE_MAX_FILE_MB.  Larger files are truncated.
+//
+func GetFileMaxFileMB() int {
+	return sizeLimitMB("DEXTER_GET_FILE_MAX_FILE_MB", "helpers.GetFileMaxFileMB", 1024)
+}
+
+//
+// Lookup the size, in megabytes, of all the files one run of get-file will
+// collect, set with DEXTER_GET_FILE_MAX_TOTAL_MB.
+//
+func GetFileMaxTotalMB() int {
+	return sizeLimitMB("DEXTER_GET_FILE_MAX_TOTAL_MB", "helpers.GetFileMaxTotalMB", 4096)
+}
+
+//
+// Lookup the size, in megabytes, of all the memory one run of
+// process-memory will collect, set with DEXTER_PROCESS_MEMORY_MAX_MB.
+//
+func ProcessMemoryMaxMB() int {
+	return sizeLimitMB("DEXTER_PROCESS_MEMORY_MAX_MB", "helpers.ProcessMemoryMaxMB", 8192)
+}
+
+func sizeLimitMB(envarName, at string, defaultMB int) int {
+	sizeStr := os.Getenv(envarName)
+	if sizeStr == "" {
+		return defaultMB
+	}
+	size, err := strconv.Atoi(sizeStr)
+	if err != nil || size < 1 {
+		log.WithFields(log.Fields{
+			"at":      at,
+			"value":   sizeStr,
+			"default": defaultMB,
+		}).Warn("unable to convert size limit to a positive int, using the default")
+		return defaultMB
+	}
+	return size
+}
@@ -1,23 +1,12 @@
 package helpers
 
 import (
-	"crypto/rsa"
-	"crypto/x509"
-	"encoding/pem"
-	"errors"
-	"io/ioutil"
 	"os"
 	"os/user"
-	"strings"
 
 	"github.com/fatih/color"
-
-	"github.com/coinbase/dexter/util"
 )
 
-var keyCached = false
-var cachedKey *rsa.PrivateKey
-
 //
 // Find the configuration directory for Dexter.
 //
@@ -38,104 +27,98 @@ func GetDexterKeyFile() string {
 }
 
 //
-// Return the full path for the file that stores the local investigator data.
+// Return the full path for the file that stores this host's identity key
+// when running as a daemon.  This can be overridden with the
+// DEXTER_HOST_KEY_FILE environment variable.
 //
-func GetDexterInvestigatorFile() string {
-	return GetDexterDirectory() + "/investigator.json"
+func GetDexterHostKeyFile() string {
+	if location := os.Getenv("DEXTER_HOST_KEY_FILE"); location != "" {
+		return location
+	}
+	return GetDexterDirectory() + "/host.key"
 }
 
 //
-// Load the local investigator's private key and decrypt it by getting the password
-// from user interaction.
+// Return the full path for the file the daemon records its progress in, so
+// it can resume after a restart.  This can be overridden with the
+// DEXTER_STATE_FILE environment variable.
 //
-func LoadLocalKey(passwordRetriever func() string) *rsa.PrivateKey {
-	if keyCached {
-		return cachedKey
-	}
-
-	keyPEM, err := ioutil.ReadFile(GetDexterKeyFile())
-	if err != nil {
-		color.HiRed("Error reading local key file: " + err.Error())
-		os.Exit(1)
+func GetDexterStateFile() string {
+	if location := os.Getenv("DEXTER_STATE_FILE"); location != "" {
+		return location
 	}
+	return GetDexterDirectory() + "/daemon-state.json"
+}
 
-	block, _ := pem.Decode(keyPEM)
-	for {
-		keyDer, err := x509.DecryptPEMBlock(block, []byte(passwordRetriever()))
-		if err != nil {
-			color.Red("Decryption error in x509.DecryptPEMBlock: " + err.Error())
-			color.HiRed("Try again")
-			continue
-		}
-		privateKey, err := x509.ParsePKCS1PrivateKey(keyDer)
-		if err != nil {
-			color.Red("Parsing error in x509.ParsePKCS1PrivateKey: " + err.Error())
-			os.Exit(1)
-			continue
-		}
-		cachedKey = privateKey
-		keyCached = true
-		return privateKey
+//
+// Return the full path for the daemon's execution ledger, the append-only
+// record of every investigation this host has run.  This can be overridden
+// with the DEXTER_LEDGER_FILE environment variable.
+//
+func GetDexterLedgerFile() string {
+	if location := os.Getenv("DEXTER_LEDGER_FILE"); location != "" {
+		return location
 	}
+	return GetDexterDirectory() + "/ledger.jsonl"
 }
 
 //
-// Given a prefix for an ID in Dexter, return the full ID if there is enough
-// specificity.  If there is too much ambiguity in the ID, and there are
-// multiple possible matches, return an error.  This function works for both
-// investigation and report IDs.
+// Return the full path for the file listing the registry admins whose
+// signatures are trusted to change the investigator registry.  This can be
+// overridden with the DEXTER_REGISTRY_ROOTS environment variable.
 //
-func ResolveUUID(partial string) (string, error) {
-	allUUIDs := investigationUUIDs()
-	allUUIDs = util.AppendUnique(allUUIDs, reportUUIDs())
-
-	possibleMatches := make([]string, 0)
-	for _, uuid := range allUUIDs {
-		if strings.HasPrefix(uuid, partial) {
-			possibleMatches = append(possibleMatches, uuid)
-		}
+func GetDexterRegistryRootsFile() string {
+	if location := os.Getenv("DEXTER_REGISTRY_ROOTS"); location != "" {
+		return location
 	}
+	return GetDexterDirectory() + "/registry-roots.json"
+}
 
-	if len(possibleMatches) == 1 {
-		return possibleMatches[0], nil
-	} else if len(possibleMatches) > 1 {
-		return "", errors.New("too many possible UUID matches")
+//
+// Return the full path for the file the command line records the newest
+// registry version it has accepted in.  This can be overridden with the
+// DEXTER_REGISTRY_FLOOR_FILE environment variable.
+//
+func GetDexterRegistryFloorFile() string {
+	if location := os.Getenv("DEXTER_REGISTRY_FLOOR_FILE"); location != "" {
+		return location
 	}
-	return "", errors.New("no possible UUID matches")
+	return GetDexterDirectory() + "/registry-floor"
 }
 
-func investigationUUIDs() []string {
-	investigations, err := ListS3Path("investigations/")
-	if err != nil {
-		color.HiRed(err.Error())
+//
+// Return the full path for the approval policy file.  This can be
+// overridden with the DEXTER_POLICY_FILE environment variable.
+//
+func GetDexterPolicyFile() string {
+	if location := os.Getenv("DEXTER_POLICY_FILE"); location != "" {
+		return location
 	}
+	return GetDexterDirectory() + "/policy.json"
+}
 
-	seenUUIDs := make(map[string]bool)
-	allUUIDs := make([]string, 0)
-	for _, filename := range investigations {
-		uuid := filename[15:23]
-		if _, present := seenUUIDs[uuid]; !present {
-			seenUUIDs[uuid] = true
-			allUUIDs = append(allUUIDs, uuid)
-		}
+//
+// Return the full path for the host policy file, which limits what
+// investigations the daemon runs on this host.  This can be overridden
+// with the DEXTER_HOST_POLICY_FILE environment variable.
+//
+func GetDexterHostPolicyFile() string {
+	if location := os.Getenv("DEXTER_HOST_POLICY_FILE"); location != "" {
+		return location
 	}
-	return allUUIDs
+	return GetDexterDirectory() + "/host-policy.json"
 }
 
-func reportUUIDs() []string {
-	reports, err := ListS3Path("reports/")
-	if err != nil {
-		color.HiRed(err.Error())
-	}
+//
+// Return the default path of the socket a dexter agent listens on.
+//
+func GetDexterAgentSocketFile() string {
+	return GetDexterDirectory() + "/agent.sock"
+}
 
-	seenUUIDs := make(map[string]bool)
-	allUUIDs := make([]string, 0)
-	for _, filename := range reports {
-		uuid := filename[8:16]
-		if _, present := seenUUIDs[uuid]; !present {
-			seenUUIDs[uuid] = true
-			allUUIDs = append(allUUIDs, uuid)
-		}
-	}
-	return allUUIDs
+//
+// Return the full path for the file that stores the local investigator data.
+//
+func GetDexterInvestigatorFile() string {
+	return GetDexterDirectory() + "/investigator.json"
 }
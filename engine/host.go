@@ -0,0 +1,222 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/coinbase/dexter/engine/helpers"
+
+	"golang.org/x/crypto/ed25519"
+)
+
+//
+// A host identity is the key pair a Dexter daemon uses to sign
+// everything it uploads, so investigators can tell which host
+// produced a record.
+//
+type HostIdentity struct {
+	Name       string
+	privateKey ed25519.PrivateKey
+}
+
+//
+// A host is a daemon that has been enrolled in Dexter by an administrator,
+// defined by its hostname and the public half of its identity key.  Hosts
+// are stored in the hosts directory of the store, each signed by the
+// registry admin who enrolled it, and only reports signed by an enrolled
+// host are trusted.
+//
+type Host struct {
+	Name       string
+	PublicKey  []byte
+	EnrolledBy *Signature `json:",omitempty"`
+}
+
+//
+// Load this host's identity key from disk, generating and saving
+// a new one if none exists yet.
+//
+func LoadHostIdentity() (*HostIdentity, error) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, err
+	}
+	keyFile := helpers.GetDexterHostKeyFile()
+	keyPEM, err := ioutil.ReadFile(filepath.FromSlash(keyFile))
+	if os.IsNotExist(err) {
+		return newHostIdentity(hostname, keyFile)
+	} else if err != nil {
+		return nil, err
+	}
+	block, _ := pem.Decode(keyPEM)
+	if block == nil || block.Type != "DEXTER HOST KEY" || len(block.Bytes) != ed25519.PrivateKeySize {
+		return nil, errors.New("host key file " + keyFile + " is not a valid dexter host key")
+	}
+	return &HostIdentity{
+		Name:       hostname,
+		privateKey: ed25519.PrivateKey(block.Bytes),
+	}, nil
+}
+
+func newHostIdentity(hostname, keyFile string) (*HostIdentity, error) {
+	_, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	err = os.MkdirAll(filepath.Dir(filepath.FromSlash(keyFile)), 0700)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{
+		Type:  "DEXTER HOST KEY",
+		Bytes: privateKey,
+	})
+	err = ioutil.WriteFile(filepath.FromSlash(keyFile), keyPEM, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &HostIdentity{
+		Name:       hostname,
+		privateKey: privateKey,
+	}, nil
+}
+
+//
+// Return the public half of this host's identity key.
+//
+func (identity *HostIdentity) PublicKey() []byte {
+	return []byte(identity.privateKey.Public().(ed25519.PublicKey))
+}
+
+//
+// Sign a message with this host's identity key.
+//
+func (identity *HostIdentity) Sign(message []byte) []byte {
+	return ed25519.Sign(identity.privateKey, message)
+}
+
+//
+// Return the enrollment record for this host.
+//
+func (identity *HostIdentity) Host() Host {
+	return Host{
+		Name:      identity.Name,
+		PublicKey: identity.PublicKey(),
+	}
+}
+
+//
+// Return the data a registry admin signs to enroll a host.
+//
+func (host Host) digest() []byte {
+	blob := []byte("dexter host")
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(host.Name)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, host.PublicKey...)
+	sum := sha256.Sum256(blob)
+	return sum[:]
+}
+
+//
+// Sign a host's enrollment as a registry admin.
+//
+func (host *Host) Sign(signer Signer, name string) error {
+	sig, err := signer.Sign(name, host.digest())
+	if err != nil {
+		return err
+	}
+	host.EnrolledBy = &sig
+	return nil
+}
+
+//
+// Check a host's enrollment is well formed and signed by one of the
+// registry admins.  Without registry roots, when unsigned investigators are
+// allowed, unsigned enrollments in the store are trusted too.
+//
+func (host Host) Verify(roots *RegistryRoots) error {
+	if host.Name == "" || len(host.PublicKey) != ed25519.PublicKeySize {
+		return errors.New("enrollment for host " + host.Name + " is invalid")
+	}
+	if roots == nil {
+		return nil
+	}
+	if host.EnrolledBy == nil {
+		return errors.New("enrollment for host " + host.Name + " is not signed by a registry admin")
+	}
+	admin, ok := roots.admin(*host.EnrolledBy)
+	if !ok || !admin.Key.Verify(host.digest(), *host.EnrolledBy) {
+		return errors.New("enrollment for host " + host.Name + " is not signed by a registry admin")
+	}
+	return nil
+}
+
+//
+// Upload a host's enrollment to the hosts directory of the store.
+//
+func (host Host) Upload(store Store) error {
+	data, err := json.MarshalIndent(host, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put("hosts/"+host.Name+".json", bytes.NewReader(data))
+}
+
+//
+// Look up the public key of an enrolled host, trusting only enrollments
+// signed by the registry admins configured on this machine.
+//
+func HostPublicKey(store Store, hostname string) ([]byte, error) {
+	roots, err := LoadRegistryRoots()
+	if err != nil {
+		return []byte{}, err
+	}
+	data, err := store.Get("hosts/" + hostname + ".json")
+	if err != nil {
+		return []byte{}, errors.New("host " + hostname + " is not enrolled")
+	}
+	var host Host
+	err = json.Unmarshal(data, &host)
+	if err != nil {
+		return []byte{}, errors.New("unable to parse enrollment for host " + hostname + ": " + err.Error())
+	}
+	if host.Name != hostname {
+		return []byte{}, errors.New("enrollment for host " + hostname + " is invalid")
+	}
+	err = host.Verify(roots)
+	if err != nil {
+		return []byte{}, err
+	}
+	return host.PublicKey, nil
+}
+
+//
+// Return a short fingerprint of a host public key, for comparing against
+// enrollment records kept elsewhere.
+//
+func HostKeyFingerprint(key []byte) string {
+	if len(key) == 0 {
+		return "-"
+	}
+	sum := sha256.Sum256(key)
+	return hex.EncodeToString(sum[:8])
+}
+
+//
+// Verify a message was signed by the holder of a host public key.
+//
+func VerifyHostSignature(publicKey, message, signature []byte) bool {
+	if len(publicKey) != ed25519.PublicKeySize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(publicKey), message, signature)
+}
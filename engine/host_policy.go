@@ -0,0 +1,179 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/coinbase/dexter/engine/helpers"
+	"github.com/coinbase/dexter/tasks"
+)
+
+//
+// The host policy is a file kept on each host, outside of the store, that
+// limits what investigations the daemon will run however many
+// investigators approve them.  It lists the tasks the host accepts, and
+// for each task optionally the glob patterns its arguments must match.  In
+// patterns, * and ? match within one path element and ** matches across
+// them.  Killing containers or the host must be allowed explicitly.
+//
+// A host without a policy file accepts every investigation.
+//
+type HostPolicy struct {
+	Tasks               map[string]HostTaskPolicy
+	AllowKillContainers bool
+	AllowKillHost       bool
+}
+
+//
+// The arguments a host accepts for a task.  An empty list accepts any
+// arguments.
+//
+type HostTaskPolicy struct {
+	Arguments []string `json:",omitempty"`
+	patterns  []*regexp.Regexp
+}
+
+//
+// Parse and check a host policy file.
+//
+func ParseHostPolicy(data []byte) (*HostPolicy, error) {
+	var policy HostPolicy
+	err := json.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, err
+	}
+	for name, task := range policy.Tasks {
+		if _, ok := tasks.Tasks[name]; !ok {
+			return nil, errors.New("host policy allows unknown task \"" + name + "\"")
+		}
+		for _, glob := range task.Arguments {
+			task.patterns = append(task.patterns, globPattern(glob))
+		}
+		policy.Tasks[name] = task
+	}
+	return &policy, nil
+}
+
+//
+// Load the host policy of this host.  If none is configured, nil is
+// returned and every investigation is accepted.
+//
+func LoadHostPolicy() (*HostPolicy, error) {
+	path := helpers.GetDexterHostPolicyFile()
+	data, err := ioutil.ReadFile(filepath.FromSlash(path))
+	if os.IsNotExist(err) && os.Getenv("DEXTER_HOST_POLICY_FILE") == "" {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("unable to read host policy: " + err.Error())
+	}
+	policy, err := ParseHostPolicy(data)
+	if err != nil {
+		return nil, errors.New("invalid host policy " + path + ": " + err.Error())
+	}
+	return policy, nil
+}
+
+//
+// Compile a glob pattern into a regular expression matching the whole
+// argument.
+//
+func globPattern(glob string) *regexp.Regexp {
+	expression := "^"
+	for i := 0; i < len(glob); i++ {
+		switch {
+		case strings.HasPrefix(glob[i:], "**"):
+			expression += ".*"
+			i++
+		case glob[i] == '*':
+			expression += "[^/]*"
+		case glob[i] == '?':
+			expression += "[^/]"
+		default:
+			expression += regexp.QuoteMeta(glob[i : i+1])
+		}
+	}
+	return regexp.MustCompile(expression + "$")
+}
+
+//
+// Return true if an argument matches one of the task's patterns.  Paths
+// must be clean, so ".." cannot climb out of an allowed directory, and a
+// path through a symlink must also be allowed where the symlink leads.
+//
+func (task HostTaskPolicy) allows(argument string) bool {
+	if len(task.patterns) == 0 {
+		return true
+	}
+	candidates := []string{argument}
+	if strings.HasPrefix(argument, "/") {
+		if filepath.Clean(argument) != argument {
+			return false
+		}
+		if resolved, err := filepath.EvalSymlinks(argument); err == nil && resolved != argument {
+			candidates = append(candidates, resolved)
+		}
+	}
+	for _, candidate := range candidates {
+		matched := false
+		for _, pattern := range task.patterns {
+			if pattern.MatchString(candidate) {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// Return a filter limiting the files a task collects to paths its
+// argument patterns allow, or nil if its arguments are not limited.
+// Arguments that expand to many files, such as globs and directories, are
+// checked file by file as they are collected.
+//
+func (policy *HostPolicy) PathFilter(name string) tasks.PathFilter {
+	task, ok := policy.Tasks[name]
+	if !ok || len(task.patterns) == 0 {
+		return nil
+	}
+	return task.allows
+}
+
+//
+// Return why the host policy rejects an investigation, or an empty string
+// if it accepts it.
+//
+func (policy *HostPolicy) Rejects(investigation Investigation) string {
+	if investigation.KillContainers && !policy.AllowKillContainers {
+		return "host policy does not allow killing containers"
+	}
+	if investigation.KillHost && !policy.AllowKillHost {
+		return "host policy does not allow killing the host"
+	}
+	names := make([]string, 0, len(investigation.TaskList))
+	for name := range investigation.TaskList {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		task, ok := policy.Tasks[name]
+		if !ok {
+			return "host policy does not allow task " + name
+		}
+		for _, argument := range investigation.TaskList[name] {
+			if !task.allows(argument) {
+				return "host policy does not allow task " + name + " with argument " + argument
+			}
+		}
+	}
+	return ""
+}
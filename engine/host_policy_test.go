@@ -0,0 +1,50 @@
+package engine_test
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHostPolicyLimitsTasksAndArguments(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "dexter-host-policy")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	// patterns name paths as they are once symlinks are resolved
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(dir+"/evidence.log", []byte("evidence"), 0644))
+	assert.Nil(os.Symlink("/etc/hosts", dir+"/link.log"))
+
+	_, err = engine.ParseHostPolicy([]byte(`{"Tasks": {"no-such-task": {}}}`))
+	assert.NotNil(err)
+	policy, err := engine.ParseHostPolicy([]byte(`{"Tasks": {
+		"example-task": {},
+		"get-file": {"Arguments": ["/var/log/**", "` + dir + `/*.log"]}
+	}}`))
+	assert.Nil(err)
+
+	investigation := func(task string, args ...string) engine.Investigation {
+		return engine.Investigation{TaskList: map[string][]string{task: args}}
+	}
+	assert.Equal("", policy.Rejects(investigation("example-task", "anything")))
+	assert.Equal("", policy.Rejects(investigation("get-file", "/var/log/dexter-test/access.log", dir+"/evidence.log")))
+	assert.NotEqual("", policy.Rejects(investigation("get-file", "/etc/shadow")))
+	assert.NotEqual("", policy.Rejects(investigation("get-file", "/var/log/../../etc/shadow")))
+	assert.NotEqual("", policy.Rejects(investigation("get-file", dir+"/nested/evidence.log")))
+	assert.NotEqual("", policy.Rejects(investigation("get-file", dir+"/link.log")), "symlinks must lead somewhere allowed")
+	assert.Equal("host policy does not allow task osquery-collect", policy.Rejects(investigation("osquery-collect", "select 1")))
+
+	destructive := investigation("example-task")
+	destructive.KillHost = true
+	assert.Equal("host policy does not allow killing the host", policy.Rejects(destructive))
+	policy.AllowKillHost = true
+	assert.Equal("", policy.Rejects(destructive))
+	destructive.KillContainers = true
+	assert.Equal("host policy does not allow killing containers", policy.Rejects(destructive))
+}
@@ -3,11 +3,8 @@ package engine
 import (
 	"archive/zip"
 	"bytes"
-	"crypto"
-	"crypto/aes"
-	"crypto/cipher"
+	"context"
 	"crypto/rand"
-	"crypto/rsa"
 	"crypto/sha256"
 	"encoding/json"
 	"errors"
@@ -18,6 +15,8 @@ import (
 	"path/filepath"
 	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/coinbase/dexter/engine/helpers"
 	"github.com/coinbase/dexter/facts"
@@ -43,44 +42,197 @@ type Investigation struct {
 	Issuer         Signature
 	Approvers      []Signature
 	RecipientNames []string
+
+	// The window in which daemons will run the investigation.  These are
+	// covered by the issuer's signature, and an investigation without an
+	// expiration time is never run.
+	IssuedAt  time.Time
+	NotBefore time.Time
+	ExpiresAt time.Time
+
+	// The private directory this host uses while running the
+	// investigation, so concurrent investigations never share files.
+	workspace string
 }
 
 //
 // A signature consists of the name of the investigator who did
-// the signing, and the signature data.
+// the signing, the ID of the key they used, and the signature data.
+// Signatures made with a legacy RSA key have no key ID.
 //
 type Signature struct {
-	Name string
-	Data []byte
+	Name  string
+	KeyID string `json:",omitempty"`
+	Data  []byte
 }
 
 //
 // A decryption payload contains all the information needed for an
-// investigator to decrypt an investigation, a nonce for data
-// encryption and the encrypted data encryption key.
+// investigator to decrypt an investigation, the format of the encrypted
+// report and the encrypted data encryption key.  Reports encrypted before
+// the streaming format existed have no format and carry a nonce instead.
+// The key ID names the recipient key the data encryption key was wrapped
+// for, along with the ephemeral key used to wrap it, and is empty for keys
+// wrapped for a legacy RSA key.  It also carries the hash of the encrypted
+// report, and the signature of the host that produced it over both.  Keys
+// passed on by an investigator rather than the host carry a rewrap instead
+// of a host signature.
 //
 type DecryptionPayload struct {
+	Format                     string
 	Nonce                      []byte
+	KeyID                      string `json:",omitempty"`
+	EphemeralKey               []byte `json:",omitempty"`
 	EncryptedDataEncryptionKey []byte
+	ReportHash                 []byte
+	HostSignature              []byte
+	Rewrap                     *Rewrap `json:",omitempty"`
+}
+
+//
+// A rewrap records an investigator passing the key of a report they can
+// read on to another investigator, such as when the report's recipient is
+// revoked.  Source is the host signed payload the key was sent to them in,
+// which vouches for the report, and the investigator signs the new payload
+// along with it.
+//
+type Rewrap struct {
+	Source          DecryptionPayload
+	SourceRecipient string
+	Signature       Signature
+}
+
+//
+// Return the data a host signs for a report, binding the encrypted report
+// and the payload to the investigation, host, and recipient named in the
+// report's filename.
+//
+func (payload DecryptionPayload) digest(investigationID, hostname, recipient string) []byte {
+	blob := make([]byte, 0)
+	for _, field := range []string{investigationID, hostname, recipient, payload.Format} {
+		blob = append(blob, []byte(field)...)
+		blob = append(blob, 0x00)
+	}
+	blob = append(blob, payload.Nonce...)
+	blob = append(blob, 0x00)
+	blob = append(blob, payload.EncryptedDataEncryptionKey...)
+	blob = append(blob, 0x00)
+	blob = append(blob, payload.ReportHash...)
+	if payload.KeyID != "" {
+		blob = append(blob, 0x00)
+		blob = append(blob, []byte(payload.KeyID)...)
+		blob = append(blob, 0x00)
+		blob = append(blob, payload.EphemeralKey...)
+	}
+	sum := sha256.Sum256(blob)
+	return sum[:]
+}
+
+//
+// Sign the SHA-256 hash of an encrypted report and this payload with a
+// host's identity key.
+//
+func (payload *DecryptionPayload) SignReport(identity *HostIdentity, investigationID, recipient string, reportHash []byte) {
+	payload.ReportHash = reportHash
+	payload.HostSignature = identity.Sign(payload.digest(investigationID, identity.Name, recipient))
+}
+
+//
+// Verify an encrypted report, given its SHA-256 hash, and this payload were
+// produced by the host holding a public key, returning an error describing
+// the first problem found.  Payloads passed on by an investigator must be
+// signed by one who is still trusted.
+//
+func (payload DecryptionPayload) VerifyReport(store Store, hostPublicKey []byte, investigationID, hostname, recipient string, reportHash []byte) error {
+	if !bytes.Equal(reportHash, payload.ReportHash) {
+		return errors.New("encrypted report does not match the hash in its decryption payload")
+	}
+	if payload.Rewrap != nil {
+		return payload.verifyRewrap(store, hostPublicKey, investigationID, hostname, recipient, reportHash)
+	}
+	if !VerifyHostSignature(hostPublicKey, payload.digest(investigationID, hostname, recipient), payload.HostSignature) {
+		return errors.New("report signature is not valid for host " + hostname)
+	}
+	return nil
+}
+
+//
+// Return the data an investigator signs when passing on a report key,
+// binding the new payload to the host signed payload it came from.
+//
+func (payload DecryptionPayload) rewrapDigest(investigationID, hostname, recipient string) []byte {
+	blob := []byte("dexter rewrap")
+	blob = append(blob, 0x00)
+	blob = append(blob, payload.digest(investigationID, hostname, recipient)...)
+	blob = append(blob, payload.Rewrap.Source.digest(investigationID, hostname, payload.Rewrap.SourceRecipient)...)
+	blob = append(blob, []byte(payload.Rewrap.SourceRecipient)...)
+	sum := sha256.Sum256(blob)
+	return sum[:]
+}
+
+func (payload DecryptionPayload) verifyRewrap(store Store, hostPublicKey []byte, investigationID, hostname, recipient string, reportHash []byte) error {
+	rewrap := payload.Rewrap
+	if rewrap.Source.Rewrap != nil {
+		return errors.New("report key was passed on from a key that was itself passed on")
+	}
+	if payload.Format != rewrap.Source.Format || !bytes.Equal(payload.Nonce, rewrap.Source.Nonce) {
+		return errors.New("passed on report key does not match the report format")
+	}
+	err := rewrap.Source.VerifyReport(store, hostPublicKey, investigationID, hostname, rewrap.SourceRecipient, reportHash)
+	if err != nil {
+		return err
+	}
+	rewrapper, err := InvestigatorByName(store, rewrap.Signature.Name)
+	if err != nil {
+		return errors.New("report key was passed on by an untrusted investigator: " + err.Error())
+	}
+	if !rewrapper.VerifySignature(payload.rewrapDigest(investigationID, hostname, recipient), rewrap.Signature) {
+		return errors.New("report key passed on by " + rewrap.Signature.Name + " has an invalid signature")
+	}
+	return nil
+}
+
+//
+// Wrap the key of a report the signer can read for another investigator.
+// The source payload must be the host signed payload the key was sent in,
+// or a payload passed on from one, whose source is used instead.
+//
+func RewrapPayload(source DecryptionPayload, sourceRecipient string, dataKey []byte, investigationID, hostname string, recipient Investigator, signer Signer, signerName string) (DecryptionPayload, error) {
+	if source.Rewrap != nil {
+		sourceRecipient = source.Rewrap.SourceRecipient
+		source = source.Rewrap.Source
+	}
+	payload, err := recipient.WrapKey(dataKey)
+	if err != nil {
+		return DecryptionPayload{}, err
+	}
+	payload.Format = source.Format
+	payload.Nonce = source.Nonce
+	payload.ReportHash = source.ReportHash
+	payload.Rewrap = &Rewrap{Source: source, SourceRecipient: sourceRecipient}
+	payload.Rewrap.Signature, err = signer.Sign(signerName, payload.rewrapDigest(investigationID, hostname, recipient.Name))
+	if err != nil {
+		return DecryptionPayload{}, err
+	}
+	return payload, nil
 }
 
 //
 // Decrypt the encrypted data encryption key using the local investigator's
-// key.  A password function is used to retrieve the password for the local
-// investigator's private key.
-//
-func (payload DecryptionPayload) GetEncryptionKey(passwordFunc func() string) []byte {
-	var data []byte
-	var err error
-	for {
-		data, err = rsa.DecryptOAEP(sha256.New(), rand.Reader, helpers.LoadLocalKey(passwordFunc), payload.EncryptedDataEncryptionKey, []byte{})
-		if err == nil {
-			return data
-		} else {
-			color.HiRed("Decryption error in DecryptionPayload: " + err.Error())
-			os.Exit(1)
-		}
+// key it was wrapped for, which may be a key that has since been rotated.
+//
+func (payload DecryptionPayload) GetEncryptionKey(keys KeySource) []byte {
+	key, err := keys.Decrypter(payload.KeyID)
+	if err != nil {
+		color.HiRed("Decryption error in DecryptionPayload: " + err.Error())
+		os.Exit(1)
+	}
+	data, err := key.UnwrapKey(payload)
+	if err != nil {
+		color.HiRed("Decryption error in DecryptionPayload: " + err.Error())
+		os.Exit(1)
 	}
+	return data
 }
 
 //
@@ -94,12 +246,34 @@ func (investigation *Investigation) ApproverNames() []string {
 	return names
 }
 
+//
+// Return the local filesystem directory holding everything this host
+// creates while running the investigation.  Each run gets its own
+// directory, created the first time this is called.
+//
+func (investigation *Investigation) Workspace() string {
+	if investigation.workspace != "" {
+		return investigation.workspace
+	}
+	dir, err := ioutil.TempDir("", "DexterReport-"+investigation.ID+"-")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":            "engine.Workspace",
+			"error":         err.Error(),
+			"investigation": investigation.ID,
+		}).Error("unable to create investigation workspace")
+		dir = os.TempDir() + "/DexterReport-" + investigation.ID
+	}
+	investigation.workspace = filepath.ToSlash(dir)
+	return investigation.workspace
+}
+
 //
 // Return the path on the local filesystem for the zipped report that
 // resulted from this investigation.
 //
 func (investigation *Investigation) ReportZip() string {
-	return os.TempDir() + "/DexterReport-" + investigation.ID + ".zip"
+	return investigation.Workspace() + "/DexterReport-" + investigation.ID + ".zip"
 }
 
 //
@@ -107,15 +281,55 @@ func (investigation *Investigation) ReportZip() string {
 // artifacts during this investigation.
 //
 func (investigation *Investigation) ReportDirectory() string {
-	return os.TempDir() + "/DexterReport-" + investigation.ID + "/"
+	return investigation.Workspace() + "/DexterReport-" + investigation.ID + "/"
 }
 
-func (investigation *Investigation) validate() error {
-	// Verify the issuer has a valid signature
-	if !investigation.validateSignature(investigation.Issuer) {
+//
+// Returned from validate when a fact in the investigation's
+// scope does not apply to this host.
+//
+type outOfScopeError struct {
+	fact string
+}
+
+func (err outOfScopeError) Error() string {
+	return "host is not in scope, fact " + err.fact + " does not apply"
+}
+
+//
+// Returned from validate when this host's policy does not allow an
+// investigation, however many investigators approve it.
+//
+type hostPolicyError struct {
+	reason string
+}
+
+func (err hostPolicyError) Error() string {
+	return err.reason
+}
+
+//
+// Returned from validate when an investigation has not been approved
+// by enough investigators yet.
+//
+var errAwaitingConsensus = errors.New("investigation has not yet reached consensus")
+
+func (investigation *Investigation) validate(trusted *TrustedInvestigators, policy *Policy, hostPolicy *HostPolicy) error {
+	// Verify the issuer has a valid signature, unless the investigators
+	// trusted could not be loaded to check it
+	if _, err := trusted.byName(investigation.Issuer.Name); isTrustLoadError(err) {
+		return err
+	}
+	if !investigation.validateSignature(trusted, investigation.Issuer) {
 		return errors.New("issuer signature invalid")
 	}
 
+	// Verify the investigation is inside the window it was issued for
+	err := investigation.checkValidityWindow(time.Now())
+	if err != nil {
+		return err
+	}
+
 	// Load the tasks to run as defined in the TaskList
 	if investigation.countValidTasks() == 0 {
 		return errors.New("unable to load any tasks for investigation")
@@ -132,19 +346,53 @@ func (investigation *Investigation) validate() error {
 		}
 		inScope := factChecker.Assert(value)
 		if !inScope {
-			return errors.New("host is not in scope, fact " + attribute + " does not apply")
+			return outOfScopeError{fact: attribute}
+		}
+	}
+
+	// Verify this host allows what the investigation would do
+	if hostPolicy != nil {
+		if reason := hostPolicy.Rejects(*investigation); reason != "" {
+			return hostPolicyError{reason: reason}
 		}
 	}
 
 	// Verify this action has been approved with +n consensus
-	if !investigation.consensusRequirementsMet() {
-		return errors.New("investigation has not yet reached consensus")
+	if !investigation.consensusRequirementsMet(trusted, policy) {
+		return errAwaitingConsensus
 	}
 
 	return nil
 }
 
-func (investigation *Investigation) run() {
+//
+// Return an error if an investigation may not run at a given time, either
+// because it has no expiration, has expired, or is not valid yet.
+//
+func (investigation *Investigation) checkValidityWindow(now time.Time) error {
+	if investigation.ExpiresAt.IsZero() {
+		return errors.New("investigation has no expiration time")
+	}
+	if now.After(investigation.ExpiresAt) {
+		return errors.New("investigation expired at " + investigation.ExpiresAt.UTC().Format(time.RFC3339))
+	}
+	if now.Before(investigation.NotBefore) {
+		return errors.New("investigation is not valid before " + investigation.NotBefore.UTC().Format(time.RFC3339))
+	}
+	return nil
+}
+
+//
+// Return true if the investigation's expiration time has passed.
+//
+func (investigation *Investigation) Expired() bool {
+	return !investigation.ExpiresAt.IsZero() && time.Now().After(investigation.ExpiresAt)
+}
+
+//
+// Run the investigation's tasks, returning the manifest of each.
+//
+func (investigation *Investigation) run(hostPolicy *HostPolicy) []tasks.TaskManifest {
 	err := os.MkdirAll(filepath.FromSlash(investigation.ReportDirectory()), 0700)
 	if err != nil {
 		log.WithFields(log.Fields{
@@ -152,16 +400,35 @@ func (investigation *Investigation) run() {
 			"error": err.Error(),
 			"path":  investigation.ReportDirectory(),
 		}).Error("unable to create report directory")
-		return
+		return nil
 	}
 
 	log.WithFields(log.Fields{
 		"at":            "engine.run",
 		"investigation": investigation.ID,
 	}).Info("running investigation")
+	// the host policy was checked when the investigation was validated,
+	// and is applied again to every file tasks collect
+	dir := investigation.ReportDirectory()
+	var running sync.WaitGroup
+	var lock sync.Mutex
+	runs := []tasks.TaskManifest{}
 	for taskName, taskArgs := range investigation.TaskList {
 		if task, ok := tasks.Tasks[taskName]; ok {
-			task.Run(investigation.ReportDirectory(), taskArgs)
+			running.Add(1)
+			ctx := context.Background()
+			if hostPolicy != nil {
+				if filter := hostPolicy.PathFilter(taskName); filter != nil {
+					ctx = tasks.WithPathFilter(ctx, filter)
+				}
+			}
+			go func(task tasks.Task, args []string) {
+				defer running.Done()
+				run := task.Run(ctx, dir, args)
+				lock.Lock()
+				runs = append(runs, run)
+				lock.Unlock()
+			}(task, taskArgs)
 		} else {
 			log.WithFields(log.Fields{
 				"at":   "engine.taskListToTask",
@@ -169,10 +436,12 @@ func (investigation *Investigation) run() {
 			}).Error("task name is not a known task")
 		}
 	}
+	running.Wait()
 	log.WithFields(log.Fields{
 		"at":            "engine.run",
 		"investigation": investigation.ID,
 	}).Info(fmt.Sprintf("finished %d tasks", len(investigation.TaskList)))
+	return runs
 }
 
 func (investigation *Investigation) uniqueApprovers() []Signature {
@@ -199,69 +468,91 @@ func (investigation *Investigation) uniqueApprovers() []Signature {
 // set of investigators and are valid.  This is equivalent to the current
 // consensus level.
 //
-func (investigation *Investigation) ValidUniqueApprovers() int {
-	signatures := investigation.uniqueApprovers()
-	achieved := 0
-	for _, sig := range signatures {
-		if investigation.validateSignature(sig) {
-			achieved += 1
+func (investigation *Investigation) ValidUniqueApprovers(trusted *TrustedInvestigators) int {
+	return len(investigation.validApprovers(trusted))
+}
+
+//
+// Return the investigators with valid approvals on an investigation.
+//
+func (investigation *Investigation) validApprovers(trusted *TrustedInvestigators) []Investigator {
+	approvers := []Investigator{}
+	for _, sig := range investigation.uniqueApprovers() {
+		investigator, err := trusted.byName(sig.Name)
+		if err == nil && investigation.signedBy(investigator, sig) {
+			approvers = append(approvers, investigator)
 		} else {
 			log.WithFields(log.Fields{
-				"at":            "engine.consensusRequirementsMet",
+				"at":            "engine.validApprovers",
 				"name":          sig.Name,
 				"investigation": investigation.ID,
 			}).Error("approver signature invalid")
 		}
 	}
-	return achieved
+	return approvers
 }
 
-func (investigation *Investigation) consensusRequirementsMet() bool {
-	return investigation.ValidUniqueApprovers() >= investigation.MinimumConsensus()
+//
+// Check the investigation has every approval the approval policy requires
+// to run on this host.
+//
+func (investigation *Investigation) consensusRequirementsMet(trusted *TrustedInvestigators, policy *Policy) bool {
+	missing := investigation.MissingApprovals(trusted, policy, true)
+	if len(missing) > 0 {
+		log.WithFields(log.Fields{
+			"at":            "engine.consensusRequirementsMet",
+			"investigation": investigation.ID,
+			"missing":       strings.Join(missing, "; "),
+		}).Info("investigation is missing approvals")
+		return false
+	}
+	return true
 }
 
 //
-// Each task has different consensus requirements, return the highest value
-// from all the tasks.  That will be the amount of consensus required for
-// this investigation.
+// Each task and approval policy rule has different consensus
+// requirements, return the highest value from all of them.  That will be
+// the amount of consensus required for this investigation under a policy,
+// though some of it may have to come from investigators with particular
+// roles.
 //
-func (investigation *Investigation) MinimumConsensus() int {
+func (investigation *Investigation) MinimumConsensus(policy *Policy) int {
 	required := 1
-	for taskName, _ := range investigation.TaskList {
-		task, ok := tasks.Tasks[taskName]
-		if !ok {
-			log.WithFields(log.Fields{
-				"at":        "engine.MinimumConsensus",
-				"task_name": taskName,
-			}).Error("named task not found")
-			continue
-		}
-		if task.ConsensusRequirement > required {
-			required = task.ConsensusRequirement
+	for _, requirement := range investigation.ApprovalRequirements(policy, false) {
+		if requirement.Count > required {
+			required = requirement.Count
 		}
 	}
 	return required
 }
 
-func (investigation *Investigation) allSignaturesValid() bool {
-	if !investigation.validateSignature(investigation.Issuer) {
+func (investigation *Investigation) allSignaturesValid(trusted *TrustedInvestigators) bool {
+	if !investigation.validateSignature(trusted, investigation.Issuer) {
 		return false
 	}
 	for _, approver := range investigation.Approvers {
-		if !investigation.validateSignature(approver) {
+		if !investigation.validateSignature(trusted, approver) {
 			return false
 		}
 	}
 	return true
 }
 
-func (investigation *Investigation) validateSignature(sig Signature) bool {
-	publicKey, err := GetPublicKeyForInvestigator(sig.Name)
+func (investigation *Investigation) validateSignature(trusted *TrustedInvestigators, sig Signature) bool {
+	investigator, err := trusted.byName(sig.Name)
 	if err != nil {
 		return false
 	}
-	err = rsa.VerifyPSS(publicKey, crypto.SHA256, investigation.digest(), sig.Data, &rsa.PSSOptions{})
-	return err == nil
+	return investigation.signedBy(investigator, sig)
+}
+
+//
+// Verify an issuer or approver signature on the investigation.  It is
+// taken to have been made when the investigation was issued, and is relied
+// on until the investigation expires.
+//
+func (investigation *Investigation) signedBy(investigator Investigator, sig Signature) bool {
+	return investigator.VerifySignatureWithin(investigation.digest(), sig, investigation.IssuedAt, investigation.ExpiresAt)
 }
 
 func (investigation *Investigation) digest() []byte {
@@ -287,12 +578,26 @@ func (investigation *Investigation) digest() []byte {
 	for _, recipient := range investigation.RecipientNames {
 		blob = append(blob, []byte(recipient)...)
 	}
+	// timestamps are only included when set, so investigations signed
+	// before they existed keep the same digest
+	blob = append(blob, timestampData("IssuedAt", investigation.IssuedAt)...)
+	blob = append(blob, timestampData("NotBefore", investigation.NotBefore)...)
+	blob = append(blob, timestampData("ExpiresAt", investigation.ExpiresAt)...)
 
 	// Create a SHA-256 hash of this data and return it
 	sum := sha256.Sum256(blob)
 	return sum[:]
 }
 
+// Return a labeled timestamp for use in the investigation digest,
+// or nothing if the timestamp is not set
+func timestampData(label string, timestamp time.Time) []byte {
+	if timestamp.IsZero() {
+		return []byte{}
+	}
+	return []byte("\x00" + label + "=" + timestamp.UTC().Format(time.RFC3339Nano))
+}
+
 // Take all the map data and return it in a consistent order
 // for use in the investigation digest
 func orderedMapData(data map[string][]string) []byte {
@@ -312,55 +617,93 @@ func orderedMapData(data map[string][]string) []byte {
 	return ret
 }
 
-func (investigation *Investigation) report() {
+//
+// Zip, encrypt, sign, and upload the report along with the ledger entry
+// recording this run.  The report is encrypted and uploaded once, and its
+// key is wrapped separately for each recipient.  Returns an error if the
+// report could not be delivered to any one of them.
+//
+func (investigation *Investigation) report(trusted *TrustedInvestigators, identity *HostIdentity, execution LedgerEntry, runs []tasks.TaskManifest) error {
+	store := trusted.store
 	log.WithFields(log.Fields{
 		"at":            "engine.report",
 		"investigation": investigation.ID,
 	}).Info("reporting investigation")
 
-	// create a zip of the entire report directory
+	location := ReportFile{
+		ID:       investigation.ID,
+		Hostname: identity.Name,
+	}
+	var failure error
+	executionData, err := json.MarshalIndent(execution, "", "  ")
+	if err == nil {
+		err = store.Put(location.ExecutionRecordPath(), bytes.NewReader(executionData))
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":            "engine.report",
+			"error":         err.Error(),
+			"investigation": investigation.ID,
+		}).Error("unable to upload execution record")
+		failure = err
+	}
+
+	// sign a manifest of everything the tasks collected, then create a zip
+	// of the entire report directory, and encrypt it once
+	err = investigation.writeManifest(identity, runs)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":            "engine.report",
+			"error":         err.Error(),
+			"investigation": investigation.ID,
+		}).Error("unable to write report manifest")
+		return err
+	}
 	investigation.zip()
-	// encrypt that zip file to each recipient
-	for _, investigator := range investigation.RecipientNames {
-		decryptionPayload := investigation.encrypt(investigator)
+	key, reportHash, err := investigation.encrypt()
+	if err != nil {
 		log.WithFields(log.Fields{
 			"at":            "engine.report",
+			"error":         err.Error(),
 			"investigation": investigation.ID,
-			"recipient":     investigator,
-		}).Info("uploading report")
+		}).Error("unable to encrypt report")
+		return err
+	}
 
-		//s3 upload encrypted zip
-		hostname, err := os.Hostname()
-		if err != nil {
-			log.WithFields(log.Fields{
-				"at":            "engine.report",
-				"error":         err.Error(),
-				"investigation": investigation.ID,
-			}).Error("unable to retrieve hostname")
-			continue
-		}
-		encryptedZip, err := os.Open(filepath.FromSlash(investigation.ReportZip()) + ".enc")
-		if err != nil {
-			log.WithFields(log.Fields{
-				"at":            "engine.report",
-				"error":         err.Error(),
-				"investigation": investigation.ID,
-				"investigator":  investigator,
-			}).Error("error opening encrypted report for upload")
-			continue
-		}
-		reportUploadPath := "reports/" + investigation.ID + "-" + hostname + "." + investigator + ".zip.enc"
-		err = helpers.UploadS3File(reportUploadPath, encryptedZip)
+	// the encrypted report is uploaded before any key that can decrypt it,
+	// so a recipient never sees a key without its report.  The store
+	// retries failed requests itself.
+	log.WithFields(log.Fields{
+		"at":            "engine.report",
+		"investigation": investigation.ID,
+	}).Info("uploading report")
+	encryptedZip, err := os.Open(filepath.FromSlash(investigation.ReportZip()) + ".enc")
+	if err == nil {
+		err = store.Put(location.EncryptedReportPath(), encryptedZip)
+		encryptedZip.Close()
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":            "engine.report",
+			"error":         err.Error(),
+			"investigation": investigation.ID,
+		}).Error("unable to upload encrypted zip")
+		return err
+	}
+
+	for _, investigator := range investigation.RecipientNames {
+		location.Recipient = investigator
+		decryptionPayload, err := investigation.wrapKey(trusted, investigator, identity, key, reportHash)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"at":            "engine.report",
 				"error":         err.Error(),
 				"investigation": investigation.ID,
 				"user":          investigator,
-			}).Error("unable to upload encrypted zip")
+			}).Error("unable to wrap report key")
+			failure = err
 			continue
 		}
-		// s3 upload decryption package
 		decryptionData, err := json.Marshal(decryptionPayload)
 		if err != nil {
 			log.WithFields(log.Fields{
@@ -369,10 +712,10 @@ func (investigation *Investigation) report() {
 				"investigation": investigation.ID,
 				"user":          investigator,
 			}).Error("unable to marshal decryption payload")
+			failure = err
 			continue
 		}
-		decryptionPayloadPath := "reports/" + investigation.ID + "-" + hostname + "." + investigator + ".decrypt"
-		err = helpers.UploadS3File(decryptionPayloadPath, bytes.NewReader(decryptionData))
+		err = store.Put(location.DecryptionPayloadPath(), bytes.NewReader(decryptionData))
 		if err != nil {
 			log.WithFields(log.Fields{
 				"at":            "engine.report",
@@ -380,88 +723,57 @@ func (investigation *Investigation) report() {
 				"investigation": investigation.ID,
 				"user":          investigator,
 			}).Error("unable to upload decryption payload")
+			failure = err
 			continue
 		}
 	}
+	return failure
 }
 
 //
-// Encrypt an investigation for a specific investigator, returning the encrypted
-// zip as well as the encrypted data encryption key
+// Encrypt the report zip with a new data encryption key, returning the key
+// and the hash of the encrypted report.
 //
-func (investigation Investigation) encrypt(user string) DecryptionPayload {
-	clearZipData, err := ioutil.ReadFile(investigation.ReportZip())
-	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.encrypt",
-			"error": err.Error(),
-			"file":  investigation.ReportZip(),
-		}).Fatal("unable to open report zip for encryption")
+func (investigation Investigation) encrypt() ([]byte, []byte, error) {
+	key := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, nil, err
 	}
-	key, nonce, ciphertext := aesgcmEncrypt(clearZipData)
-	err = ioutil.WriteFile(investigation.ReportZip()+".enc", ciphertext, 0644)
+	reportHash, err := encryptFile(investigation.ReportZip(), investigation.ReportZip()+".enc", key)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.encrypt",
-			"error": err.Error(),
-			"file":  investigation.ReportZip() + ".enc",
-		}).Fatal("unable to open file for encrypted zip")
+		return nil, nil, err
 	}
+	return key, reportHash, nil
+}
 
-	userPubKey, err := GetPublicKeyForInvestigator(user)
+//
+// Wrap the data encryption key of a report for a specific investigator,
+// returning the encrypted key signed by this host.
+//
+func (investigation Investigation) wrapKey(trusted *TrustedInvestigators, user string, identity *HostIdentity, key, reportHash []byte) (DecryptionPayload, error) {
+	investigator, err := trusted.byName(user)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "Engine.encrypt",
-			"error": err.Error(),
-		}).Error("error getting public key for encrypted report")
-		return DecryptionPayload{}
+		return DecryptionPayload{}, err
 	}
-	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, userPubKey, key, []byte{})
+	payload, err := investigator.WrapKey(key)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.encrypt",
-			"error": err.Error(),
-		}).Fatal("encryption error")
-	}
-
-	return DecryptionPayload{
-		Nonce:                      nonce,
-		EncryptedDataEncryptionKey: encryptedKey,
+		return DecryptionPayload{}, err
 	}
+	payload.Format = StreamFormat
+	payload.SignReport(identity, investigation.ID, user, reportHash)
+	return payload, nil
 }
 
-func aesgcmEncrypt(cleartext []byte) (key, nonce, ciphertext []byte) {
-	key = make([]byte, 16)
-	if _, err := io.ReadFull(rand.Reader, key); err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.aesgcmEncrypt",
-			"error": err.Error(),
-		}).Fatal("unable to generate random key")
-	}
-	nonce = make([]byte, 12)
-	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.aesgcmEncrypt",
-			"error": err.Error(),
-		}).Fatal("unable to generate random nonce")
-	}
-
-	// Create AES-GCM block cipher
-	block, err := aes.NewCipher(key)
+func (investigation *Investigation) writeManifest(identity *HostIdentity, runs []tasks.TaskManifest) error {
+	manifest, err := NewReportManifest(identity, investigation, runs)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.aesgcmEncrypt",
-			"error": err.Error(),
-		}).Fatal("error calling aes.NewCipher")
+		return err
 	}
-	aesgcm, err := cipher.NewGCM(block)
+	data, err := manifest.Marshal()
 	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.aesgcmEncrypt",
-			"error": err.Error(),
-		}).Fatal("error calling cipher.NewGCM")
+		return err
 	}
-	return key, nonce, aesgcm.Seal(nil, nonce, cleartext, nil)
+	return ioutil.WriteFile(filepath.FromSlash(investigation.ReportDirectory()+ManifestName), data, 0644)
 }
 
 func (investigation *Investigation) zip() {
@@ -503,8 +815,10 @@ func (investigation *Investigation) zip() {
 				"at":    "engine.zip",
 				"error": err.Error(),
 			}).Error("error opening report file for zip")
+			return nil
 		}
 		_, err = io.Copy(file, src)
+		src.Close()
 		if err != nil {
 			log.WithFields(log.Fields{
 				"at":    "engine.zip",
@@ -535,27 +849,30 @@ func (investigation *Investigation) countValidTasks() int {
 	return count
 }
 
-func (investigation *Investigation) Sign(privateKey *rsa.PrivateKey) {
-	hash := investigation.digest()
-	sig, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, hash, &rsa.PSSOptions{})
+//
+// Sign the investigation as its issuer with the local investigator's
+// current key.
+//
+func (investigation *Investigation) Sign(signer Signer) {
+	sig, err := signer.Sign(investigation.Issuer.Name, investigation.digest())
 	if err != nil {
 		color.HiRed("Error signing investigation: " + err.Error())
 		os.Exit(1)
 	}
-	investigation.Issuer.Data = sig
+	investigation.Issuer = sig
 }
 
-func (investigation *Investigation) Approve(privateKey *rsa.PrivateKey) {
-	hash := investigation.digest()
-	sig, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, hash, &rsa.PSSOptions{})
+//
+// Approve the investigation as the local investigator with their current
+// key.
+//
+func (investigation *Investigation) Approve(signer Signer) {
+	sig, err := signer.Sign(LocalInvestigatorName(), investigation.digest())
 	if err != nil {
 		color.HiRed("Error signing investigation: " + err.Error())
 		os.Exit(1)
 	}
-	investigation.Approvers = append(investigation.Approvers, Signature{
-		Name: LocalInvestigatorName(),
-		Data: sig,
-	})
+	investigation.Approvers = append(investigation.Approvers, sig)
 }
 
 //
@@ -582,23 +899,57 @@ func (investigation *Investigation) ScopeFactsToString() string {
 }
 
 //
-// Upload this investigation to S3.
+// Approvals are uploaded as separate copies of an investigation, one per
+// approver.  Add the approvals from another copy of this investigation to
+// this one, returning true if any new approvals were added.  Copies that
+// do not have the same contents and issuer are ignored.
+//
+func (investigation *Investigation) MergeApprovals(other Investigation) bool {
+	if investigation.ID != other.ID ||
+		investigation.Issuer.Name != other.Issuer.Name ||
+		!bytes.Equal(investigation.digest(), other.digest()) {
+		log.WithFields(log.Fields{
+			"at":            "engine.MergeApprovals",
+			"investigation": investigation.ID,
+			"issuer":        other.Issuer.Name,
+		}).Error("refusing to merge approvals from a copy with different contents")
+		return false
+	}
+	added := false
+	for _, approval := range other.Approvers {
+		known := false
+		for _, existing := range investigation.Approvers {
+			if existing.Name == approval.Name && bytes.Equal(existing.Data, approval.Data) {
+				known = true
+				break
+			}
+		}
+		if !known {
+			investigation.Approvers = append(investigation.Approvers, approval)
+			added = true
+		}
+	}
+	return added
+}
+
+//
+// Upload this investigation to the store.
 //
-func (investigation *Investigation) Upload() error {
+func (investigation *Investigation) Upload(store Store) error {
 	investigationBytes, _ := json.MarshalIndent(investigation, "", "  ")
 	uploadPath := "investigations/" + investigation.ID + "." + LocalInvestigatorName()
-	return helpers.UploadS3File(uploadPath, bytes.NewReader(investigationBytes))
+	return store.Put(uploadPath, bytes.NewReader(investigationBytes))
 }
 
 //
 // Lookup an investigation by ID, or partial ID.
 //
-func InvestigationByID(uuid string) (Investigation, error) {
-	full, err := helpers.ResolveUUID(uuid)
+func InvestigationByID(store Store, uuid string) (Investigation, error) {
+	full, err := ResolveUUID(store, uuid)
 	if err != nil {
 		return Investigation{}, err
 	}
-	all := CurrentInvestigations()
+	all := CurrentInvestigations(store)
 	for _, inv := range all {
 		if inv.ID == full {
 			return inv, nil
@@ -610,8 +961,8 @@ func InvestigationByID(uuid string) (Investigation, error) {
 //
 // Lookup an investigation by ID, or partial ID, using an already downloaded list of investigation.
 //
-func InvestigationByIDWithCache(cache []Investigation, uuid string) (Investigation, error) {
-	full, err := helpers.ResolveUUID(uuid)
+func InvestigationByIDWithCache(store Store, cache []Investigation, uuid string) (Investigation, error) {
+	full, err := ResolveUUID(store, uuid)
 	if err != nil {
 		return Investigation{}, err
 	}
@@ -626,35 +977,33 @@ func InvestigationByIDWithCache(cache []Investigation, uuid string) (Investigati
 //
 // Get all investigations, including archived ones
 //
-func AllInvestigations() []Investigation {
-	return getInvestigations(true)
+func AllInvestigations(store Store) []Investigation {
+	return getInvestigations(store, true)
 }
 
 //
 // Get current investigations.
 //
-func CurrentInvestigations() []Investigation {
-	return getInvestigations(false)
+func CurrentInvestigations(store Store) []Investigation {
+	return getInvestigations(store, false)
 }
 
 //
-// Download all investigations currently on S3.  Accepts a boolean
+// Download all investigations currently in the store.  Accepts a boolean
 // to indicate if archived investigations should be returned as well.
 //
-func getInvestigations(archived bool) []Investigation {
+func getInvestigations(store Store, archived bool) []Investigation {
 	knownInvestigations := make(map[string]Investigation)
+	trusted := NewTrustedInvestigators(store)
 
-	investigations, err := helpers.ListS3Path("investigations/")
-	if err != nil {
-		color.HiRed(err.Error())
-	}
-
-	for _, filename := range investigations {
+	iterator := store.Iterate("investigations/")
+	for iterator.Next() {
+		filename := iterator.Key()
 		investigationFile := strings.TrimPrefix(filename, "investigations/")
 		if string(investigationFile[0]) == "_" && !archived {
 			continue
 		}
-		data, err := helpers.GetS3File(filename)
+		data, err := store.Get(filename)
 		if err != nil {
 			color.HiRed(err.Error())
 		}
@@ -664,18 +1013,20 @@ func getInvestigations(archived bool) []Investigation {
 			color.HiRed("unable to unmarshal investigation json: " + err.Error())
 			continue
 		}
-		if !inv.allSignaturesValid() {
+		if !inv.allSignaturesValid(trusted) {
 			color.HiRed("investigation contains invalid signatures")
 			continue
 		}
 		if check, ok := knownInvestigations[inv.ID]; ok {
-			if len(inv.Approvers) > len(check.Approvers) {
-				knownInvestigations[inv.ID] = inv
-			}
+			check.MergeApprovals(inv)
+			knownInvestigations[inv.ID] = check
 		} else {
 			knownInvestigations[inv.ID] = inv
 		}
 	}
+	if iterator.Err() != nil {
+		color.HiRed(iterator.Err().Error())
+	}
 	set := []Investigation{}
 	for _, v := range knownInvestigations {
 		set = append(set, v)
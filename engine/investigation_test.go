@@ -0,0 +1,109 @@
+package engine_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInvestigationWorkspacesAreIsolated(t *testing.T) {
+	assert := assert.New(t)
+
+	first := engine.Investigation{ID: "abcd1234"}
+	second := engine.Investigation{ID: "abcd1234"}
+	defer os.RemoveAll(first.Workspace())
+	defer os.RemoveAll(second.Workspace())
+
+	assert.NotEqual(first.ReportDirectory(), second.ReportDirectory())
+	assert.NotEqual(first.ReportZip(), second.ReportZip())
+	assert.Equal(first.ReportDirectory(), first.ReportDirectory())
+	assert.True(strings.HasPrefix(first.ReportDirectory(), first.Workspace()))
+}
+
+func TestValidityWindowIsSigned(t *testing.T) {
+	assert := assert.New(t)
+
+	investigator, keyPEM, err := engine.NewInvestigator("alice", "password")
+	assert.Nil(err)
+	keyring, err := engine.ParseKeyring(keyPEM, "password")
+	assert.Nil(err)
+	store := engine.NewMemoryStore()
+	data, err := investigator.String()
+	assert.Nil(err)
+	assert.Nil(store.Put("investigators/alice.json", bytes.NewReader(data)))
+
+	issued := time.Now().UTC().Truncate(time.Second)
+	inv := engine.Investigation{
+		ID:        "abcd1234",
+		TaskList:  map[string][]string{"example": {}},
+		Issuer:    engine.Signature{Name: "alice"},
+		IssuedAt:  issued,
+		NotBefore: issued,
+		ExpiresAt: issued.Add(time.Hour),
+	}
+	inv.Sign(keyring.Current())
+	assert.False(inv.Expired())
+
+	// extending the expiration invalidates the issuer's signature
+	extended := inv
+	extended.ExpiresAt = issued.Add(24 * time.Hour)
+	data, err = json.Marshal(extended)
+	assert.Nil(err)
+	assert.Nil(store.Put("investigations/abcd1234.alice", bytes.NewReader(data)))
+	assert.Len(engine.CurrentInvestigations(store), 0)
+
+	data, err = json.Marshal(inv)
+	assert.Nil(err)
+	assert.Nil(store.Put("investigations/abcd1234.alice", bytes.NewReader(data)))
+	found := engine.CurrentInvestigations(store)
+	assert.Len(found, 1)
+	assert.True(found[0].ExpiresAt.Equal(inv.ExpiresAt))
+
+	expired := engine.Investigation{ExpiresAt: time.Now().Add(-time.Minute)}
+	assert.True(expired.Expired())
+}
+
+func TestInvestigationsAreRetriedWhenTrustCannotBeLoaded(t *testing.T) {
+	assert := assert.New(t)
+
+	store := &unreadableStore{Store: engine.NewMemoryStore()}
+	_, keyring := putTestInvestigator(t, store, "alice")
+	issued := time.Now().UTC().Truncate(time.Second)
+	inv := engine.Investigation{
+		ID:        "abcd1234",
+		TaskList:  map[string][]string{"example-task": {}},
+		Issuer:    engine.Signature{Name: "alice"},
+		IssuedAt:  issued,
+		NotBefore: issued,
+		ExpiresAt: issued.Add(time.Hour),
+	}
+	inv.Sign(keyring.Current())
+
+	// an issuer that cannot be read is not taken as an invalid signature
+	store.unreadable = "investigators/alice.json"
+	retry, err := engine.ValidateInvestigation(&inv, store)
+	assert.NotNil(err)
+	assert.True(retry)
+
+	// once it can be read only the approvals are missing
+	store.unreadable = ""
+	retry, err = engine.ValidateInvestigation(&inv, store)
+	assert.False(retry)
+	assert.EqualError(err, "investigation has not yet reached consensus")
+
+	forged := inv
+	forged.Issuer.Data = []byte("forged")
+	retry, err = engine.ValidateInvestigation(&forged, store)
+	assert.NotNil(err)
+	assert.False(retry)
+
+	retry, err = engine.ValidateInvestigation(&engine.Investigation{Issuer: engine.Signature{Name: "mallory"}}, store)
+	assert.NotNil(err)
+	assert.False(retry, "investigators that do not exist are never trusted")
+}
@@ -1,12 +1,11 @@
 package engine
 
 import (
-	"bytes"
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
-	"crypto/x509"
+	"crypto/sha256"
 	"encoding/json"
-	"encoding/pem"
 	"errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/coinbase/dexter/engine/helpers"
@@ -15,6 +14,7 @@ import (
 	"math/big"
 	"os"
 	"strconv"
+	"time"
 )
 
 //
@@ -25,28 +25,50 @@ type PublicKey struct {
 }
 
 //
-// An investigator is defined by their name and public key.
+// An investigator is defined by their name and public keys.  Keys are
+// listed oldest first, and only the newest is used to sign investigations
+// and receive reports.  Older keys verify signatures until they are
+// retired, and afterwards only what was signed before then.  Investigators
+// created before versioned keys existed only have an RSA PublicKey, which
+// stops verifying once they publish a versioned key.
 //
 type Investigator struct {
 	PublicKey PublicKey
 	Name      string
+	Keys      []InvestigatorKey `json:",omitempty"`
+	Roles     []string          `json:",omitempty"`
 }
 
 //
-// Create a new investigator object and the encrypted private key PEM block
+// Return true if the investigator holds a role used by the approval
+// policy.
+//
+func (investigator Investigator) HasRole(role string) bool {
+	for _, held := range investigator.Roles {
+		if held == role {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Create a new investigator object and the encrypted key file holding
+// their private key
 //
 func NewInvestigator(name, password string) (Investigator, []byte, error) {
-	privateKeyPEM, publicKey, err := generateInvestigatorKeys(password)
+	keyring := &Keyring{password: password}
+	key, err := keyring.Rotate()
 	if err != nil {
-		return Investigator{}, []byte{}, err
+		return Investigator{}, []byte{}, errors.New("fatal error generating investigator keys: " + err.Error())
 	}
-	return Investigator{
-		Name: name,
-		PublicKey: PublicKey{
-			N: publicKey.N.String(),
-			E: strconv.Itoa(publicKey.E),
-		},
-	}, privateKeyPEM, nil
+	privateKeyPEM, err := keyring.Encode()
+	if err != nil {
+		return Investigator{}, []byte{}, errors.New("fatal error encrypting investigator keys: " + err.Error())
+	}
+	investigator := Investigator{Name: name}
+	investigator.AddKey(key.Public())
+	return investigator, privateKeyPEM, nil
 }
 
 //
@@ -57,85 +79,167 @@ func (investigator Investigator) String() ([]byte, error) {
 }
 
 //
-// Generate the keys for an investigator
+// Publish a new key for an investigator, making it their current key.
 //
-func generateInvestigatorKeys(password string) ([]byte, *rsa.PublicKey, error) {
-	// Generate new RSA key
-	privateKey, err := rsa.GenerateKey(rand.Reader, 4096)
-	if err != nil {
-		return []byte{}, nil, errors.New("fatal error generating RSA keys: " + err.Error())
+func (investigator *Investigator) AddKey(key InvestigatorKey) {
+	key.Created = time.Now().UTC().Truncate(time.Second)
+	investigator.Keys = append(investigator.Keys, key)
+}
+
+//
+// Return the ID of the key used to sign investigations and receive reports.
+// Legacy RSA keys have an empty ID.
+//
+func (investigator Investigator) CurrentKeyID() string {
+	if len(investigator.Keys) == 0 {
+		return ""
 	}
+	return investigator.Keys[len(investigator.Keys)-1].ID
+}
 
-	// Encode private key as password-protected PEM file
-	privateBytes := x509.MarshalPKCS1PrivateKey(privateKey)
-	if err != nil {
-		return []byte{}, nil, errors.New("fatal error encoding RSA private key: " + err.Error())
+//
+// How long after a key is retired an investigation it signed before then
+// may still be valid for.  Investigations in flight when a key is rotated
+// can finish, while a stolen key cannot backdate investigations that run
+// any later than this.
+//
+const RetiredKeyGracePeriod = 24 * time.Hour
+
+//
+// Retire every key published before a key, as of a time.  Keys already
+// retired keep their earlier retirement.
+//
+func (investigator *Investigator) RetireKeysBefore(keyID string, at time.Time) {
+	at = at.UTC().Truncate(time.Second)
+	for i, key := range investigator.Keys {
+		if key.ID == keyID {
+			return
+		}
+		if key.Retired == nil {
+			investigator.Keys[i].Retired = &at
+		}
 	}
-	pemBlock, err := x509.EncryptPEMBlock(rand.Reader, "ENCRYPTED PRIVATE KEY", privateBytes, []byte(password), x509.PEMCipherAES128)
-	if err != nil {
-		return []byte{}, nil, errors.New("fatal error PEM encoding RSA private key: " + err.Error())
+}
+
+//
+// Verify a signature over a digest was made now by one of this
+// investigator's keys that has not been retired.  Legacy RSA keys have an
+// empty ID.
+//
+func (investigator Investigator) VerifySignature(digest []byte, sig Signature) bool {
+	now := time.Now()
+	return investigator.VerifySignatureWithin(digest, sig, now, now)
+}
+
+//
+// Verify a signature over a digest that was made at a time and is relied
+// on until a later one.  A retired key verifies it only if it was signed
+// before the key was retired, and is not relied on for longer than the
+// grace period after that.
+//
+func (investigator Investigator) VerifySignatureWithin(digest []byte, sig Signature, signedAt, usedUntil time.Time) bool {
+	if sig.KeyID == "" {
+		if len(investigator.Keys) > 0 {
+			return false
+		}
+		publicKey, err := investigator.rsaPublicKey()
+		if err != nil {
+			return false
+		}
+		return rsa.VerifyPSS(publicKey, crypto.SHA256, digest, sig.Data, &rsa.PSSOptions{}) == nil
 	}
-	blockBuffer := bytes.NewBuffer([]byte{})
-	err = pem.Encode(blockBuffer, pemBlock)
-	if err != nil {
-		return []byte{}, nil, errors.New("fatal error PEM encoding RSA private key: " + err.Error())
-	}
-	privateKeyFileData := blockBuffer.Bytes()
-
-	// Generate the public key
-	publicKey := privateKey.Public().(*rsa.PublicKey)
-
-	return privateKeyFileData, publicKey, nil
-}
-
-//
-// Lookup an embedded investigator and parse their public key into
-// an *rsa.PublicKey.
-//
-func GetPublicKeyForInvestigator(name string) (*rsa.PublicKey, error) {
-	set := LoadInvestigators()
-	for _, investigator := range set {
-		if investigator.Name == name {
-			n := new(big.Int)
-			n, ok := n.SetString(investigator.PublicKey.N, 10)
-			if !ok {
-				log.WithFields(log.Fields{
-					"investigator": investigator.Name,
-					"N":            investigator.PublicKey.N,
-					"at":           "engine.getPublicKeyForInvestigator",
-				}).Error("error parsing N value")
-				return &rsa.PublicKey{}, errors.New("error parsing N value")
-			}
-			e, err := strconv.Atoi(investigator.PublicKey.E)
-			if err != nil {
-				log.WithFields(log.Fields{
-					"at":    "engine.getPublicKeyForInvestigator",
-					"error": err.Error(),
-				}).Error("error parsing E value")
-				return &rsa.PublicKey{}, errors.New("error parsing E value")
-			}
-			return &rsa.PublicKey{
-				N: n,
-				E: e,
-			}, nil
+	for _, key := range investigator.Keys {
+		if key.ID != sig.KeyID {
+			continue
 		}
+		if key.Retired != nil && (!signedAt.Before(*key.Retired) || usedUntil.After(key.Retired.Add(RetiredKeyGracePeriod))) {
+			return false
+		}
+		return key.Verify(digest, sig)
 	}
-	log.WithFields(log.Fields{
-		"at":   "engine.getPublicKeyForInvestigator",
-		"name": name,
-	}).Fatal("named investigator not found")
-	return &rsa.PublicKey{}, errors.New("named investigator not found")
+	return false
+}
+
+//
+// Wrap a report's data encryption key for this investigator's current key,
+// returning an unsigned decryption payload.
+//
+func (investigator Investigator) WrapKey(dataKey []byte) (DecryptionPayload, error) {
+	keyID := investigator.CurrentKeyID()
+	if keyID == "" {
+		publicKey, err := investigator.rsaPublicKey()
+		if err != nil {
+			return DecryptionPayload{}, err
+		}
+		encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, dataKey, []byte{})
+		return DecryptionPayload{EncryptedDataEncryptionKey: encryptedKey}, err
+	}
+	key := investigator.Keys[len(investigator.Keys)-1]
+	if key.Type == KeyTypeRSA {
+		publicKey, err := key.rsaPublicKey()
+		if err != nil {
+			return DecryptionPayload{}, err
+		}
+		encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, publicKey, dataKey, []byte{})
+		return DecryptionPayload{KeyID: keyID, EncryptedDataEncryptionKey: encryptedKey}, err
+	}
+	ephemeralKey, encryptedKey, err := wrapDataKey(key, dataKey)
+	return DecryptionPayload{
+		KeyID:                      keyID,
+		EphemeralKey:               ephemeralKey,
+		EncryptedDataEncryptionKey: encryptedKey,
+	}, err
+}
+
+//
+// Parse the investigator's legacy RSA public key.
+//
+func (investigator Investigator) rsaPublicKey() (*rsa.PublicKey, error) {
+	n, ok := new(big.Int).SetString(investigator.PublicKey.N, 10)
+	if !ok {
+		log.WithFields(log.Fields{
+			"investigator": investigator.Name,
+			"N":            investigator.PublicKey.N,
+			"at":           "engine.rsaPublicKey",
+		}).Error("error parsing N value")
+		return &rsa.PublicKey{}, errors.New("error parsing N value")
+	}
+	e, err := strconv.Atoi(investigator.PublicKey.E)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.rsaPublicKey",
+			"error": err.Error(),
+		}).Error("error parsing E value")
+		return &rsa.PublicKey{}, errors.New("error parsing E value")
+	}
+	return &rsa.PublicKey{
+		N: n,
+		E: e,
+	}, nil
+}
+
+//
+// Lookup an investigator by name.  If registry roots are configured, only
+// investigators in the signed registry are found.  Revoked investigators
+// are not found.
+//
+func InvestigatorByName(store Store, name string) (Investigator, error) {
+	return NewTrustedInvestigators(store).byName(name)
 }
 
 //
 // Return the list of embedded investigators.
 //
-func LoadInvestigatorNames() (list []string) {
-	set := LoadInvestigators()
+func LoadInvestigatorNames(store Store) ([]string, error) {
+	set, err := LoadInvestigators(store)
+	if err != nil {
+		return nil, err
+	}
+	list := []string{}
 	for _, member := range set {
 		list = append(list, member.Name)
 	}
-	return
+	return list, nil
 }
 
 //
@@ -166,20 +270,50 @@ func LocalInvestigatorName() string {
 }
 
 //
-// Load the investigator structs from the embedded files and
-// return a slice of investigators.
+// Load the investigator structs from the store and
+// return a slice of investigators.  If registry roots are configured,
+// these are the investigators in the signed registry.  Revoked
+// investigators are left out.
 //
-func LoadInvestigators() (list []Investigator) {
-	investigatorFiles, err := helpers.ListS3Path("investigators/")
+func LoadInvestigators(store Store) ([]Investigator, error) {
+	trusted := NewTrustedInvestigators(store)
+	people, err := loadInvestigators(trusted)
 	if err != nil {
-		log.WithFields(log.Fields{
-			"at":    "engine.LoadInvestigators",
-			"error": err.Error(),
-		}).Error("unable to list investigators")
-		return []Investigator{}
+		return nil, err
+	}
+	list := []Investigator{}
+	for _, person := range people {
+		_, revoked, err := trusted.revocation(person.Name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":    "engine.LoadInvestigators",
+				"error": err.Error(),
+				"name":  person.Name,
+			}).Error("leaving out investigator whose revocation could not be checked")
+		} else if !revoked {
+			list = append(list, person)
+		}
+	}
+	return list, nil
+}
+
+func loadInvestigators(trusted *TrustedInvestigators) ([]Investigator, error) {
+	if trusted.rootsErr != nil {
+		return nil, errors.New("unable to load registry roots: " + trusted.rootsErr.Error())
+	}
+	store := trusted.store
+	if trusted.roots != nil {
+		registry, err := trusted.loadRegistry()
+		if err != nil {
+			return nil, err
+		}
+		return registry.Investigators, nil
 	}
-	for _, filename := range investigatorFiles {
-		investigatorJSON, err := helpers.GetS3File(filename)
+	list := []Investigator{}
+	iterator := store.Iterate("investigators/")
+	for iterator.Next() {
+		filename := iterator.Key()
+		investigatorJSON, err := store.Get(filename)
 		if err != nil {
 			log.WithFields(log.Fields{
 				"at":    "engine.LoadInvestigators",
@@ -200,11 +334,11 @@ func LoadInvestigators() (list []Investigator) {
 			list = append(list, person)
 		}
 	}
-
+	if iterator.Err() != nil {
+		return nil, errors.New("unable to list investigators: " + iterator.Err().Error())
+	}
 	if len(list) == 0 {
-		log.WithFields(log.Fields{
-			"at": "engine.LoadInvestigators",
-		}).Fatal("no investigators loaded")
+		return nil, errors.New("no investigators loaded")
 	}
-	return
+	return list, nil
 }
@@ -1,10 +1,20 @@
 package engine_test
 
 import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"strconv"
+	"strings"
+
 	"github.com/coinbase/dexter/engine"
 	"github.com/stretchr/testify/assert"
 
 	"testing"
+	"time"
 )
 
 func TestInvestigatorCreationSetsName(t *testing.T) {
@@ -25,3 +35,191 @@ func TestInvestigatorCanMakeString(t *testing.T) {
 	_, err = investigator.String()
 	assert.Nil(err)
 }
+
+func TestInvestigatorKeysRequirePassword(t *testing.T) {
+	assert := assert.New(t)
+
+	investigator, keyPEM, err := engine.NewInvestigator("alice", "password")
+	assert.Nil(err)
+	assert.Len(investigator.Keys, 1)
+	assert.Equal(engine.KeyTypeEd25519X25519, investigator.Keys[0].Type)
+
+	_, err = engine.ParseKeyring(keyPEM, "wrong")
+	assert.Equal(engine.ErrIncorrectPassword, err)
+	keyring, err := engine.ParseKeyring(keyPEM, "password")
+	assert.Nil(err)
+	assert.False(keyring.Legacy())
+	assert.Equal(investigator.CurrentKeyID(), keyring.Current().ID)
+
+	// the key derivation parameters cannot be changed without the password
+	tampered := strings.Replace(string(keyPEM), "Argon2-Time: 3", "Argon2-Time: 4", 1)
+	assert.NotEqual(string(keyPEM), tampered)
+	_, err = engine.ParseKeyring([]byte(tampered), "password")
+	assert.Equal(engine.ErrIncorrectPassword, err)
+
+	keyring.SetPassword("new password")
+	keyPEM, err = keyring.Encode()
+	assert.Nil(err)
+	_, err = engine.ParseKeyring(keyPEM, "password")
+	assert.Equal(engine.ErrIncorrectPassword, err)
+	changed, err := engine.ParseKeyring(keyPEM, "new password")
+	assert.Nil(err)
+	assert.Equal(keyring.Current().ID, changed.Current().ID)
+}
+
+func TestRotatedKeysDecryptOldReports(t *testing.T) {
+	assert := assert.New(t)
+
+	investigator, keyPEM, err := engine.NewInvestigator("alice", "password")
+	assert.Nil(err)
+	keyring, err := engine.ParseKeyring(keyPEM, "password")
+	assert.Nil(err)
+	dataKey := []byte("0123456789abcdef")
+	digest := sha256.Sum256([]byte("investigation"))
+
+	oldPayload, err := investigator.WrapKey(dataKey)
+	assert.Nil(err)
+	assert.Equal(investigator.CurrentKeyID(), oldPayload.KeyID)
+	oldSignature, err := keyring.Current().Sign("alice", digest[:])
+	assert.Nil(err)
+	assert.True(investigator.VerifySignature(digest[:], oldSignature))
+
+	newKey, err := keyring.Rotate()
+	assert.Nil(err)
+	investigator.AddKey(newKey.Public())
+	assert.Len(investigator.Keys, 2)
+	assert.Equal(newKey.ID, investigator.CurrentKeyID())
+
+	// new reports are wrapped for the new key, and old ones still unwrap
+	newPayload, err := investigator.WrapKey(dataKey)
+	assert.Nil(err)
+	assert.Equal(newKey.ID, newPayload.KeyID)
+	keyPEM, err = keyring.Encode()
+	assert.Nil(err)
+	reloaded, err := engine.ParseKeyring(keyPEM, "password")
+	assert.Nil(err)
+	for _, payload := range []engine.DecryptionPayload{oldPayload, newPayload} {
+		key, ok := reloaded.Key(payload.KeyID)
+		assert.True(ok)
+		unwrapped, err := key.UnwrapKey(payload)
+		assert.Nil(err)
+		assert.Equal(dataKey, unwrapped)
+	}
+	oldKey, _ := reloaded.Key(oldPayload.KeyID)
+	_, err = oldKey.UnwrapKey(newPayload)
+	assert.NotNil(err)
+
+	// signatures made before the rotation stay valid
+	assert.True(investigator.VerifySignature(digest[:], oldSignature))
+	newSignature, err := reloaded.Current().Sign("alice", digest[:])
+	assert.Nil(err)
+	assert.True(investigator.VerifySignature(digest[:], newSignature))
+	newSignature.KeyID = oldSignature.KeyID
+	assert.False(investigator.VerifySignature(digest[:], newSignature))
+
+	// once retired, the old key only verifies what it signed before then,
+	// and only for as long as the grace period
+	retired := time.Now().Add(-time.Hour)
+	investigator.RetireKeysBefore(newKey.ID, retired)
+	assert.NotNil(investigator.Keys[0].Retired)
+	assert.Nil(investigator.Keys[1].Retired)
+	assert.False(investigator.VerifySignature(digest[:], oldSignature))
+	assert.True(investigator.VerifySignatureWithin(digest[:], oldSignature, retired.Add(-time.Minute), retired.Add(time.Hour)))
+	assert.False(investigator.VerifySignatureWithin(digest[:], oldSignature, retired.Add(time.Minute), retired.Add(time.Hour)))
+	assert.False(investigator.VerifySignatureWithin(digest[:], oldSignature, retired.Add(-time.Minute), retired.Add(engine.RetiredKeyGracePeriod+time.Minute)))
+	newSignature, err = reloaded.Current().Sign("alice", digest[:])
+	assert.Nil(err)
+	assert.True(investigator.VerifySignature(digest[:], newSignature))
+
+	// the keyring keeps signing with the published key until the local
+	// investigator switches to the new one
+	reloaded.UseSigningKey(oldSignature.KeyID)
+	signer, err := reloaded.Signer()
+	assert.Nil(err)
+	signature, err := signer.Sign("alice", digest[:])
+	assert.Nil(err)
+	assert.Equal(oldSignature.KeyID, signature.KeyID)
+	reloaded.UseSigningKey("unknown")
+	_, err = reloaded.Signer()
+	assert.NotNil(err)
+}
+
+func TestLegacyRSAKeysAreRotated(t *testing.T) {
+	assert := assert.New(t)
+
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	block, err := x509.EncryptPEMBlock(rand.Reader, "ENCRYPTED PRIVATE KEY", x509.MarshalPKCS1PrivateKey(privateKey), []byte("password"), x509.PEMCipherAES128)
+	assert.Nil(err)
+	keyPEM := pem.EncodeToMemory(block)
+	investigator := engine.Investigator{
+		Name: "alice",
+		PublicKey: engine.PublicKey{
+			N: privateKey.N.String(),
+			E: strconv.Itoa(privateKey.E),
+		},
+	}
+	_, err = engine.ParseKeyring(keyPEM, "wrong")
+	assert.Equal(engine.ErrIncorrectPassword, err)
+	keyring, err := engine.ParseKeyring(keyPEM, "password")
+	assert.Nil(err)
+	assert.True(keyring.Legacy())
+	digest := sha256.Sum256([]byte("investigation"))
+
+	signature, err := keyring.Current().Sign("alice", digest[:])
+	assert.Nil(err)
+	assert.Equal("", signature.KeyID)
+	assert.True(investigator.VerifySignature(digest[:], signature))
+	payload, err := investigator.WrapKey([]byte("0123456789abcdef"))
+	assert.Nil(err)
+	assert.Equal("", payload.KeyID)
+
+	newKey, err := keyring.Rotate()
+	assert.Nil(err)
+	investigator.AddKey(newKey.Public())
+	assert.False(investigator.VerifySignature(digest[:], signature), "legacy keys stop verifying once a versioned key is published")
+
+	// the legacy key is migrated into the new key file format
+	keyPEM, err = keyring.Encode()
+	assert.Nil(err)
+	assert.True(strings.HasPrefix(string(keyPEM), "-----BEGIN DEXTER KEYRING-----"))
+	keyring, err = engine.ParseKeyring(keyPEM, "password")
+	assert.Nil(err)
+	assert.False(keyring.Legacy())
+	assert.Equal(newKey.ID, keyring.Current().ID)
+	legacyKey, ok := keyring.Key("")
+	assert.True(ok)
+	unwrapped, err := legacyKey.UnwrapKey(payload)
+	assert.Nil(err)
+	assert.Equal([]byte("0123456789abcdef"), unwrapped)
+}
+
+func TestTokenRSAKeysSignAndReceiveReports(t *testing.T) {
+	assert := assert.New(t)
+
+	// RSA keys are held in tokens, so stand in for one with a local key
+	privateKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(err)
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	assert.Nil(err)
+	investigator := engine.Investigator{Name: "alice"}
+	investigator.AddKey(engine.InvestigatorKey{
+		ID:            "0123456789abcdef",
+		Type:          engine.KeyTypeRSA,
+		SigningKey:    der,
+		EncryptionKey: der,
+	})
+
+	digest := sha256.Sum256([]byte("investigation"))
+	data, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	assert.Nil(err)
+	assert.True(investigator.VerifySignature(digest[:], engine.Signature{Name: "alice", KeyID: "0123456789abcdef", Data: data}))
+	assert.False(investigator.VerifySignature(digest[:], engine.Signature{Name: "alice", Data: data}))
+
+	payload, err := investigator.WrapKey([]byte("0123456789abcdef"))
+	assert.Nil(err)
+	assert.Equal("0123456789abcdef", payload.KeyID)
+	dataKey, err := rsa.DecryptOAEP(sha256.New(), rand.Reader, privateKey, payload.EncryptedDataEncryptionKey, []byte{})
+	assert.Nil(err)
+	assert.Equal([]byte("0123456789abcdef"), dataKey)
+}
@@ -0,0 +1,368 @@
+package engine
+
+import (
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"github.com/coinbase/dexter/engine/helpers"
+
+	"github.com/fatih/color"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/ed25519"
+)
+
+//
+// The local key file holds a single envelope, a PEM block whose contents
+// are the investigator's private keys sealed with ChaCha20-Poly1305 under a
+// key derived from their password with Argon2id.  The Argon2id parameters,
+// salt, and nonce are kept in the block's headers and authenticated along
+// with the keys.
+//
+// Key files written by older versions of Dexter hold one PEM block per key,
+// each encrypted with the legacy PEM encryption scheme.  These are migrated
+// to an envelope the next time they are unlocked.
+//
+const (
+	keyringBlockType = "DEXTER KEYRING"
+	rsaKeyBlockType  = "RSA PRIVATE KEY"
+	legacyBlockType  = "ENCRYPTED PRIVATE KEY"
+
+	argon2Time    = 3
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+
+	// limits on the parameters accepted from a key file, so a modified
+	// file cannot make unlocking it exhaust the machine
+	argon2MaxTime   = 64
+	argon2MaxMemory = 4 * 1024 * 1024
+)
+
+//
+// Returned when a key file cannot be decrypted with a password.
+//
+var ErrIncorrectPassword = errors.New("incorrect password")
+
+//
+// A keyring holds every private key the local investigator has had, so
+// reports wrapped for a key that has since been rotated can still be read.
+// The last key in the keyring is the current one.
+//
+type Keyring struct {
+	keys     []*PrivateKey
+	password string
+	legacy   bool
+
+	// the key used for signing, if it is not the newest one
+	signingKeyID *string
+}
+
+//
+// Parse a keyring from a key file, decrypting it with a password.
+//
+func ParseKeyring(data []byte, password string) (*Keyring, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no keys found in key file")
+	}
+	if block.Type != keyringBlockType {
+		return parseLegacyKeyring(data, password)
+	}
+	aead, aad, err := envelopeAEAD(block.Headers, password)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(block.Headers["Nonce"])
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, errors.New("key file has an invalid nonce")
+	}
+	plaintext, err := aead.Open(nil, nonce, block.Bytes, aad)
+	if err != nil {
+		return nil, ErrIncorrectPassword
+	}
+	keyring := &Keyring{password: password}
+	for {
+		block, plaintext = pem.Decode(plaintext)
+		if block == nil {
+			break
+		}
+		key, err := parsePrivateKey(block.Type, block.Bytes, block.Headers[keyIDHeader])
+		if err != nil {
+			return nil, err
+		}
+		keyring.keys = append(keyring.keys, key)
+	}
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("no keys found in key file")
+	}
+	return keyring, nil
+}
+
+//
+// Derive the cipher sealing an envelope from a password and the Argon2id
+// parameters in its headers, returning it with the data it authenticates.
+//
+func envelopeAEAD(headers map[string]string, password string) (cipher.AEAD, []byte, error) {
+	if headers["Kdf"] != "argon2id" {
+		return nil, nil, errors.New("unsupported key derivation function " + headers["Kdf"])
+	}
+	time, err := strconv.ParseUint(headers["Argon2-Time"], 10, 32)
+	if err != nil || time < 1 || time > argon2MaxTime {
+		return nil, nil, errors.New("key file has invalid argon2 parameters")
+	}
+	memory, err := strconv.ParseUint(headers["Argon2-Memory"], 10, 32)
+	if err != nil || memory < 8 || memory > argon2MaxMemory {
+		return nil, nil, errors.New("key file has invalid argon2 parameters")
+	}
+	threads, err := strconv.ParseUint(headers["Argon2-Threads"], 10, 8)
+	if err != nil || threads < 1 {
+		return nil, nil, errors.New("key file has invalid argon2 parameters")
+	}
+	salt, err := hex.DecodeString(headers["Salt"])
+	if err != nil || len(salt) < 16 {
+		return nil, nil, errors.New("key file has an invalid salt")
+	}
+	key := argon2.IDKey([]byte(password), salt, uint32(time), uint32(memory), uint8(threads), chacha20poly1305.KeySize)
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	aad := []byte{}
+	for _, name := range []string{"Kdf", "Argon2-Time", "Argon2-Memory", "Argon2-Threads", "Salt"} {
+		aad = append(aad, []byte(name+"="+headers[name])...)
+		aad = append(aad, 0x00)
+	}
+	return aead, aad, nil
+}
+
+func parseLegacyKeyring(data []byte, password string) (*Keyring, error) {
+	keyring := &Keyring{password: password, legacy: true}
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		keyData, err := x509.DecryptPEMBlock(block, []byte(password))
+		if err == x509.IncorrectPasswordError {
+			return nil, ErrIncorrectPassword
+		} else if err != nil {
+			return nil, err
+		}
+		blockType := block.Type
+		if blockType == legacyBlockType {
+			blockType = rsaKeyBlockType
+		}
+		key, err := parsePrivateKey(blockType, keyData, block.Headers[keyIDHeader])
+		if err != nil {
+			// a wrong password can occasionally decrypt to garbage with
+			// valid padding
+			return nil, ErrIncorrectPassword
+		}
+		keyring.keys = append(keyring.keys, key)
+	}
+	if len(keyring.keys) == 0 {
+		return nil, errors.New("no keys found in key file")
+	}
+	return keyring, nil
+}
+
+func parsePrivateKey(blockType string, keyData []byte, id string) (*PrivateKey, error) {
+	switch blockType {
+	case rsaKeyBlockType:
+		rsaKey, err := x509.ParsePKCS1PrivateKey(keyData)
+		if err != nil {
+			return nil, err
+		}
+		return &PrivateKey{rsaKey: rsaKey}, nil
+	case investigatorKeyBlockType:
+		if len(keyData) != ed25519.SeedSize+32 {
+			return nil, errors.New("investigator key has an invalid length")
+		}
+		key := &PrivateKey{signingKey: ed25519.NewKeyFromSeed(keyData[:ed25519.SeedSize])}
+		copy(key.encryptionKey[:], keyData[ed25519.SeedSize:])
+		key.ID = key.Public().ID
+		if key.ID != id {
+			return nil, errors.New("investigator key does not match its key ID")
+		}
+		return key, nil
+	}
+	return nil, errors.New("unknown key type " + blockType)
+}
+
+//
+// Return the unencrypted PEM block for a private key.
+//
+func (key *PrivateKey) block() *pem.Block {
+	if key.rsaKey != nil {
+		return &pem.Block{
+			Type:  rsaKeyBlockType,
+			Bytes: x509.MarshalPKCS1PrivateKey(key.rsaKey),
+		}
+	}
+	return &pem.Block{
+		Type:    investigatorKeyBlockType,
+		Headers: map[string]string{keyIDHeader: key.ID},
+		Bytes:   append(append([]byte{}, key.signingKey.Seed()...), key.encryptionKey[:]...),
+	}
+}
+
+//
+// Encode the keyring as a key file, sealed with the keyring's password.
+//
+func (keyring *Keyring) Encode() ([]byte, error) {
+	salt := make([]byte, 16)
+	if _, err := io.ReadFull(rand.Reader, salt); err != nil {
+		return nil, err
+	}
+	headers := map[string]string{
+		"Kdf":            "argon2id",
+		"Argon2-Time":    strconv.Itoa(argon2Time),
+		"Argon2-Memory":  strconv.Itoa(argon2Memory),
+		"Argon2-Threads": strconv.Itoa(argon2Threads),
+		"Salt":           hex.EncodeToString(salt),
+	}
+	aead, aad, err := envelopeAEAD(headers, keyring.password)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, err
+	}
+	headers["Nonce"] = hex.EncodeToString(nonce)
+	plaintext := []byte{}
+	for _, key := range keyring.keys {
+		plaintext = append(plaintext, pem.EncodeToMemory(key.block())...)
+	}
+	return pem.EncodeToMemory(&pem.Block{
+		Type:    keyringBlockType,
+		Headers: headers,
+		Bytes:   aead.Seal(nil, nonce, plaintext, aad),
+	}), nil
+}
+
+//
+// Return true if the keyring was read from a key file that uses legacy
+// PEM encryption, and should be saved again as an envelope.
+//
+func (keyring *Keyring) Legacy() bool {
+	return keyring.legacy
+}
+
+//
+// Change the password the keyring is sealed with when it is next saved.
+//
+func (keyring *Keyring) SetPassword(password string) {
+	keyring.password = password
+}
+
+//
+// Save the keyring as the local investigator's key file, replacing the
+// existing file in one step.
+//
+func (keyring *Keyring) Save() error {
+	data, err := keyring.Encode()
+	if err != nil {
+		return err
+	}
+	keyFile := filepath.FromSlash(helpers.GetDexterKeyFile())
+	tmp := keyFile + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, keyFile)
+	if err == nil {
+		keyring.legacy = false
+	}
+	return err
+}
+
+var cachedKeyring *Keyring
+
+//
+// Load the local investigator's keyring, decrypting it with a password
+// collected from the user.  The password is asked for again until it is
+// correct.  Key files using legacy PEM encryption are migrated to an
+// envelope once unlocked.
+//
+func LoadLocalKeyring(passwordFunc func() string) *Keyring {
+	if cachedKeyring != nil {
+		return cachedKeyring
+	}
+	data, err := ioutil.ReadFile(filepath.FromSlash(helpers.GetDexterKeyFile()))
+	if err != nil {
+		color.HiRed("Error reading local key file: " + err.Error())
+		os.Exit(1)
+	}
+	for {
+		keyring, err := ParseKeyring(data, passwordFunc())
+		if err == ErrIncorrectPassword {
+			color.HiRed("Incorrect password, try again")
+			continue
+		} else if err != nil {
+			color.HiRed("Error loading local key file: " + err.Error())
+			os.Exit(1)
+		}
+		if keyring.Legacy() {
+			err = keyring.Save()
+			if err != nil {
+				color.HiRed("Unable to migrate local key file to the new format: " + err.Error())
+			} else {
+				color.HiYellow("Local key file migrated to Argon2id password protection")
+			}
+		}
+		cachedKeyring = keyring
+		return keyring
+	}
+}
+
+//
+// Sign with the key with an ID rather than the newest key, so a new key is
+// not used until it has been published.
+//
+func (keyring *Keyring) UseSigningKey(id string) {
+	keyring.signingKeyID = &id
+}
+
+//
+// Return the newest key in the keyring.
+//
+func (keyring *Keyring) Current() *PrivateKey {
+	return keyring.keys[len(keyring.keys)-1]
+}
+
+//
+// Return the key with an ID, if it is in the keyring.
+//
+func (keyring *Keyring) Key(id string) (*PrivateKey, bool) {
+	for _, key := range keyring.keys {
+		if key.ID == id {
+			return key, true
+		}
+	}
+	return nil, false
+}
+
+//
+// Add a new key to the keyring, making it the current key.  The keyring
+// must be saved for the new key to be kept.
+//
+func (keyring *Keyring) Rotate() (*PrivateKey, error) {
+	key, err := newPrivateKey()
+	if err != nil {
+		return nil, err
+	}
+	keyring.keys = append(keyring.keys, key)
+	return key, nil
+}
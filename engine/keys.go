@@ -0,0 +1,245 @@
+package engine
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/ed25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+//
+// Investigator keys pair an Ed25519 key, used to sign investigations, with
+// an X25519 key, used to wrap the keys of reports sent to the investigator.
+// Investigators created before these keys existed have a single RSA key,
+// identified by an empty key ID.
+//
+const KeyTypeEd25519X25519 = "ed25519-x25519"
+
+//
+// Keys held in hardware tokens are RSA keys, which sign with RSA-PSS and
+// receive reports with RSA-OAEP.  Both public key fields of an RSA key hold
+// the same PKIX encoded public key.
+//
+const KeyTypeRSA = "rsa"
+
+const (
+	investigatorKeyBlockType = "DEXTER INVESTIGATOR KEY"
+	keyIDHeader              = "Key-Id"
+	keyWrapInfo              = "dexter x25519 key wrap"
+)
+
+//
+// The public half of one of an investigator's keys.  A key that has been
+// rotated away is retired: it still receives nothing and only verifies
+// what was signed before it was retired.
+//
+type InvestigatorKey struct {
+	ID            string
+	Type          string
+	SigningKey    []byte
+	EncryptionKey []byte
+	Created       time.Time
+	Retired       *time.Time `json:",omitempty"`
+}
+
+func keyID(keyType string, signingKey, encryptionKey []byte) string {
+	blob := make([]byte, 0)
+	blob = append(blob, []byte(keyType)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, signingKey...)
+	blob = append(blob, 0x00)
+	blob = append(blob, encryptionKey...)
+	sum := sha256.Sum256(blob)
+	return hex.EncodeToString(sum[:8])
+}
+
+//
+// Verify a signature over a digest was made with this key.
+//
+func (key InvestigatorKey) Verify(digest []byte, sig Signature) bool {
+	if sig.KeyID != key.ID {
+		return false
+	}
+	switch key.Type {
+	case KeyTypeEd25519X25519:
+		if len(key.SigningKey) != ed25519.PublicKeySize {
+			return false
+		}
+		return ed25519.Verify(ed25519.PublicKey(key.SigningKey), digest, sig.Data)
+	case KeyTypeRSA:
+		publicKey, err := key.rsaPublicKey()
+		if err != nil {
+			return false
+		}
+		return rsa.VerifyPSS(publicKey, crypto.SHA256, digest, sig.Data, &rsa.PSSOptions{}) == nil
+	}
+	return false
+}
+
+//
+// Return the public half of an RSA key held outside of the key file.
+//
+func rsaInvestigatorKey(publicKey *rsa.PublicKey) (InvestigatorKey, error) {
+	der, err := x509.MarshalPKIXPublicKey(publicKey)
+	if err != nil {
+		return InvestigatorKey{}, err
+	}
+	return InvestigatorKey{
+		ID:            keyID(KeyTypeRSA, der, der),
+		Type:          KeyTypeRSA,
+		SigningKey:    der,
+		EncryptionKey: der,
+	}, nil
+}
+
+//
+// Parse the public key of an RSA investigator key.
+//
+func (key InvestigatorKey) rsaPublicKey() (*rsa.PublicKey, error) {
+	parsed, err := x509.ParsePKIXPublicKey(key.SigningKey)
+	if err != nil {
+		return nil, err
+	}
+	publicKey, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("key " + key.ID + " is not an RSA key")
+	}
+	return publicKey, nil
+}
+
+//
+// One of the local investigator's private keys.
+//
+type PrivateKey struct {
+	ID            string
+	rsaKey        *rsa.PrivateKey
+	signingKey    ed25519.PrivateKey
+	encryptionKey [32]byte
+}
+
+func newPrivateKey() (*PrivateKey, error) {
+	_, signingKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	key := &PrivateKey{signingKey: signingKey}
+	if _, err := io.ReadFull(rand.Reader, key.encryptionKey[:]); err != nil {
+		return nil, err
+	}
+	key.ID = key.Public().ID
+	return key, nil
+}
+
+//
+// Return the public half of a key.  Legacy RSA keys are published in the
+// investigator's PublicKey field instead.
+//
+func (key *PrivateKey) Public() InvestigatorKey {
+	var encryptionKey [32]byte
+	curve25519.ScalarBaseMult(&encryptionKey, &key.encryptionKey)
+	signingKey := []byte(key.signingKey.Public().(ed25519.PublicKey))
+	return InvestigatorKey{
+		ID:            keyID(KeyTypeEd25519X25519, signingKey, encryptionKey[:]),
+		Type:          KeyTypeEd25519X25519,
+		SigningKey:    signingKey,
+		EncryptionKey: encryptionKey[:],
+	}
+}
+
+//
+// Sign a digest, returning a signature naming the key used.
+//
+func (key *PrivateKey) Sign(name string, digest []byte) (Signature, error) {
+	if key.rsaKey != nil {
+		data, err := rsa.SignPSS(rand.Reader, key.rsaKey, crypto.SHA256, digest, &rsa.PSSOptions{})
+		return Signature{Name: name, Data: data}, err
+	}
+	return Signature{
+		Name:  name,
+		KeyID: key.ID,
+		Data:  ed25519.Sign(key.signingKey, digest),
+	}, nil
+}
+
+//
+// Recover the data encryption key of a report from a decryption payload
+// wrapped for this key.
+//
+func (key *PrivateKey) UnwrapKey(payload DecryptionPayload) ([]byte, error) {
+	if payload.KeyID != key.ID {
+		return nil, errors.New("report key was not wrapped for key " + key.ID)
+	}
+	if key.rsaKey != nil {
+		return rsa.DecryptOAEP(sha256.New(), rand.Reader, key.rsaKey, payload.EncryptedDataEncryptionKey, []byte{})
+	}
+	if len(payload.EphemeralKey) != 32 {
+		return nil, errors.New("report key has an invalid ephemeral key")
+	}
+	var ephemeral [32]byte
+	copy(ephemeral[:], payload.EphemeralKey)
+	public := key.Public()
+	aead, err := keyWrapAEAD(&key.encryptionKey, &ephemeral, ephemeral[:], public.EncryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	wrapped := payload.EncryptedDataEncryptionKey
+	if len(wrapped) < aead.NonceSize() {
+		return nil, errors.New("report key is too short")
+	}
+	return aead.Open(nil, wrapped[:aead.NonceSize()], wrapped[aead.NonceSize():], []byte(key.ID))
+}
+
+//
+// Derive the key used to wrap a report key from an X25519 exchange, bound
+// to both public keys taking part in it.
+//
+func keyWrapAEAD(private, public *[32]byte, ephemeralKey, recipientKey []byte) (cipher.AEAD, error) {
+	var shared [32]byte
+	curve25519.ScalarMult(&shared, private, public)
+	if bytes.Equal(shared[:], make([]byte, 32)) {
+		return nil, errors.New("invalid x25519 public key")
+	}
+	salt := append(append([]byte{}, ephemeralKey...), recipientKey...)
+	kek := make([]byte, chacha20poly1305.KeySize)
+	if _, err := io.ReadFull(hkdf.New(sha256.New, shared[:], salt, []byte(keyWrapInfo)), kek); err != nil {
+		return nil, err
+	}
+	return chacha20poly1305.New(kek)
+}
+
+//
+// Wrap a report's data encryption key for an X25519 public key, returning
+// the ephemeral public key used and the wrapped key.
+//
+func wrapDataKey(recipient InvestigatorKey, dataKey []byte) ([]byte, []byte, error) {
+	if len(recipient.EncryptionKey) != 32 {
+		return nil, nil, errors.New("invalid x25519 public key")
+	}
+	var ephemeralPrivate, ephemeralPublic, recipientPublic [32]byte
+	if _, err := io.ReadFull(rand.Reader, ephemeralPrivate[:]); err != nil {
+		return nil, nil, err
+	}
+	curve25519.ScalarBaseMult(&ephemeralPublic, &ephemeralPrivate)
+	copy(recipientPublic[:], recipient.EncryptionKey)
+	aead, err := keyWrapAEAD(&ephemeralPrivate, &recipientPublic, ephemeralPublic[:], recipient.EncryptionKey)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, nil, err
+	}
+	return ephemeralPublic[:], aead.Seal(nonce, nonce, dataKey, []byte(recipient.ID)), nil
+}
@@ -0,0 +1,214 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+//
+// A ledger entry records that a host ran an investigation.  Entries are
+// signed by the host and chained together by hash, so any change to the
+// ledger other than appending a new entry can be detected.
+//
+type LedgerEntry struct {
+	Sequence        int
+	InvestigationID string
+	Digest          []byte
+	Hostname        string
+	ExecutedAt      time.Time
+	PreviousHash    []byte
+	HostPublicKey   []byte
+	Signature       []byte
+}
+
+func (entry *LedgerEntry) digest() []byte {
+	blob := make([]byte, 0)
+	blob = append(blob, []byte(strconv.Itoa(entry.Sequence))...)
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(entry.InvestigationID)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, entry.Digest...)
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(entry.Hostname)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(entry.ExecutedAt.UTC().Format(time.RFC3339Nano))...)
+	blob = append(blob, 0x00)
+	blob = append(blob, entry.PreviousHash...)
+	blob = append(blob, 0x00)
+	blob = append(blob, entry.HostPublicKey...)
+	sum := sha256.Sum256(blob)
+	return sum[:]
+}
+
+//
+// Return the hash the next entry in the ledger must refer to.
+//
+func (entry *LedgerEntry) hash() []byte {
+	sum := sha256.Sum256(append(entry.digest(), entry.Signature...))
+	return sum[:]
+}
+
+//
+// Return true if the entry was signed by the key it carries.
+//
+func (entry *LedgerEntry) SignatureValid() bool {
+	return VerifyHostSignature(entry.HostPublicKey, entry.digest(), entry.Signature)
+}
+
+//
+// Return true if this entry records a run of the given investigation, with
+// exactly the contents it has now.
+//
+func (entry *LedgerEntry) Covers(investigation Investigation) bool {
+	return entry.InvestigationID == investigation.ID && bytes.Equal(entry.Digest, investigation.digest())
+}
+
+//
+// Returned when asked to run an investigation the ledger shows has already run.
+//
+var errAlreadyExecuted = errors.New("investigation has already been executed on this host")
+
+//
+// The ledger is the daemon's local, append-only record of every investigation
+// it has run.  It is stored as one signed JSON entry per line.
+//
+type Ledger struct {
+	path     string
+	identity *HostIdentity
+	lock     sync.Mutex
+	last     *LedgerEntry
+	executed map[string]bool
+}
+
+//
+// Open the ledger at a path, verifying every entry was signed by this host
+// and that none have been removed or altered.
+//
+func OpenLedger(path string, identity *HostIdentity) (*Ledger, error) {
+	ledger := &Ledger{
+		path:     filepath.FromSlash(path),
+		identity: identity,
+		executed: make(map[string]bool),
+	}
+	file, err := os.Open(ledger.path)
+	if os.IsNotExist(err) {
+		return ledger, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		var entry LedgerEntry
+		err = json.Unmarshal(scanner.Bytes(), &entry)
+		if err != nil {
+			return nil, errors.New("unable to parse ledger entry: " + err.Error())
+		}
+		err = ledger.verifyNext(entry)
+		if err != nil {
+			return nil, err
+		}
+		ledger.last = &entry
+		ledger.executed[hex.EncodeToString(entry.Digest)] = true
+	}
+	return ledger, scanner.Err()
+}
+
+func (ledger *Ledger) verifyNext(entry LedgerEntry) error {
+	sequence := strconv.Itoa(entry.Sequence)
+	if !bytes.Equal(entry.HostPublicKey, ledger.identity.PublicKey()) {
+		return errors.New("ledger entry " + sequence + " was not signed by this host's key")
+	}
+	if !entry.SignatureValid() {
+		return errors.New("ledger entry " + sequence + " has an invalid signature")
+	}
+	expectedSequence := 0
+	var expectedHash []byte
+	if ledger.last != nil {
+		expectedSequence = ledger.last.Sequence + 1
+		expectedHash = ledger.last.hash()
+	}
+	if entry.Sequence != expectedSequence || !bytes.Equal(entry.PreviousHash, expectedHash) {
+		return errors.New("ledger entry " + sequence + " does not follow the previous entry")
+	}
+	return nil
+}
+
+//
+// Record that this host is about to run an investigation, returning the new
+// ledger entry.  The entry is written to disk before returning, and an
+// error is returned if an investigation with the same digest already ran.
+//
+func (ledger *Ledger) Claim(investigation Investigation) (LedgerEntry, error) {
+	ledger.lock.Lock()
+	defer ledger.lock.Unlock()
+
+	digest := investigation.digest()
+	if ledger.executed[hex.EncodeToString(digest)] {
+		return LedgerEntry{}, errAlreadyExecuted
+	}
+	entry := LedgerEntry{
+		InvestigationID: investigation.ID,
+		Digest:          digest,
+		Hostname:        ledger.identity.Name,
+		ExecutedAt:      time.Now().UTC(),
+		HostPublicKey:   ledger.identity.PublicKey(),
+	}
+	if ledger.last != nil {
+		entry.Sequence = ledger.last.Sequence + 1
+		entry.PreviousHash = ledger.last.hash()
+	}
+	entry.Signature = ledger.identity.Sign(entry.digest())
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+	err = os.MkdirAll(filepath.Dir(ledger.path), 0700)
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+	file, err := os.OpenFile(ledger.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+	defer file.Close()
+	_, err = file.Write(append(line, '\n'))
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+	err = file.Sync()
+	if err != nil {
+		return LedgerEntry{}, err
+	}
+
+	ledger.last = &entry
+	ledger.executed[hex.EncodeToString(digest)] = true
+	return entry, nil
+}
+
+//
+// Download and parse the ledger entry a host uploaded with its report.
+//
+func ExecutionRecord(store Store, file ReportFile) (LedgerEntry, error) {
+	var entry LedgerEntry
+	data, err := store.Get(file.ExecutionRecordPath())
+	if err != nil {
+		return entry, err
+	}
+	err = json.Unmarshal(data, &entry)
+	return entry, err
+}
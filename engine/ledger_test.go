@@ -0,0 +1,57 @@
+package engine_test
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLedgerRefusesReplays(t *testing.T) {
+	assert := assert.New(t)
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+
+	dir, err := ioutil.TempDir("", "dexter-ledger")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	path := dir + "/ledger.jsonl"
+
+	inv := engine.Investigation{
+		ID:       "abcd1234",
+		TaskList: map[string][]string{"example": {}},
+		Issuer:   engine.Signature{Name: "alice"},
+	}
+	ledger, err := engine.OpenLedger(path, identity)
+	assert.Nil(err)
+	first, err := ledger.Claim(inv)
+	assert.Nil(err)
+	assert.True(first.SignatureValid())
+	assert.True(first.Covers(inv))
+	_, err = ledger.Claim(inv)
+	assert.NotNil(err)
+
+	other := inv
+	other.ID = "dcba4321"
+	assert.False(first.Covers(other))
+	second, err := ledger.Claim(other)
+	assert.Nil(err)
+	assert.Equal(1, second.Sequence)
+
+	// the ledger survives a restart
+	ledger, err = engine.OpenLedger(path, identity)
+	assert.Nil(err)
+	_, err = ledger.Claim(inv)
+	assert.NotNil(err)
+
+	// removing an entry is detected
+	data, err := ioutil.ReadFile(path)
+	assert.Nil(err)
+	lines := strings.SplitAfter(string(data), "\n")
+	assert.Nil(ioutil.WriteFile(path, []byte(lines[1]), 0600))
+	_, err = engine.OpenLedger(path, identity)
+	assert.NotNil(err)
+}
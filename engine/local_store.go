@@ -0,0 +1,119 @@
+package engine
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+//
+// A store backed by a directory on the local filesystem, used
+// for demo purposes.
+//
+type LocalStore struct {
+	root string
+}
+
+//
+// Create a new local store rooted at a directory, building the
+// directory structure Dexter expects if it does not yet exist.
+//
+func NewLocalStore(root string) (*LocalStore, error) {
+	for _, dir := range []string{"investigations", "reports", "investigators"} {
+		err := os.MkdirAll(filepath.Join(filepath.FromSlash(root), dir), 0777)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &LocalStore{root: filepath.FromSlash(root)}, nil
+}
+
+func (store *LocalStore) filename(key string) string {
+	return filepath.Join(store.root, filepath.FromSlash(key))
+}
+
+//
+// Read a file from the local store.
+//
+func (store *LocalStore) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(store.filename(key))
+}
+
+//
+// Open a file in the local store for streaming.
+//
+func (store *LocalStore) Open(key string) (io.ReadCloser, error) {
+	return os.Open(store.filename(key))
+}
+
+//
+// Iterate over the keys in the local store that start with a prefix,
+// in lexical order.
+//
+func (store *LocalStore) Iterate(prefix string) KeyIterator {
+	keys := []string{}
+	dir := store.filename(path.Dir(prefix + "_"))
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return &sliceIterator{keys: keys}
+	}
+	err := filepath.Walk(dir, func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(store.root, name)
+		if err != nil {
+			return err
+		}
+		key := filepath.ToSlash(rel)
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return &sliceIterator{keys: keys, err: err}
+}
+
+//
+// Write data to a file in the local store.
+//
+func (store *LocalStore) Put(key string, data io.ReadSeeker) error {
+	err := os.MkdirAll(filepath.Dir(store.filename(key)), 0777)
+	if err != nil {
+		return err
+	}
+	file, err := os.OpenFile(store.filename(key), os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+//
+// Delete a file from the local store.
+//
+func (store *LocalStore) Delete(key string) error {
+	return os.Remove(store.filename(key))
+}
+
+//
+// Move a file in the local store.
+//
+func (store *LocalStore) Move(oldKey, newKey string) error {
+	err := os.MkdirAll(filepath.Dir(store.filename(newKey)), 0777)
+	if err != nil {
+		return err
+	}
+	return os.Rename(store.filename(oldKey), store.filename(newKey))
+}
@@ -0,0 +1,15 @@
+package engine_test
+
+import (
+	"os"
+	"testing"
+)
+
+//
+// Most tests trust investigators written straight to the store, rather
+// than setting up a signed registry.
+//
+func TestMain(m *testing.M) {
+	os.Setenv("DEXTER_ALLOW_UNSIGNED_INVESTIGATORS", "true")
+	os.Exit(m.Run())
+}
@@ -0,0 +1,242 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coinbase/dexter/tasks"
+)
+
+//
+// Every report holds a manifest, written by the daemon and signed with
+// its host identity key, recording for chain of custody what each task
+// was asked to do, when it ran, and the size and SHA-256 of each file it
+// collected.  Investigators check the extracted report against it.
+//
+const ManifestName = "manifest.json"
+
+//
+// Returned when a report has no manifest, as reports produced by older
+// daemons do not.
+//
+var ErrNoManifest = errors.New("report has no manifest")
+
+//
+// The version of the daemon, set when building releases with
+//   -ldflags "-X github.com/coinbase/dexter/engine.Version=<version>"
+//
+var Version = "dev"
+
+//
+// A report manifest describes the report a host produced for an
+// investigation.  Scope holds the facts the host was found to match.
+//
+type ReportManifest struct {
+	InvestigationID string
+	Hostname        string
+	Platform        string
+	Scope           map[string][]string `json:",omitempty"`
+	DaemonVersion   string
+	CreatedAt       time.Time
+	Tasks           []tasks.TaskManifest
+	HostPublicKey   []byte
+
+	// The manifest as it was signed, and its signature
+	signed    json.RawMessage
+	signature []byte
+}
+
+//
+// The manifest file holds the manifest exactly as it was signed alongside
+// the signature, so it can be verified by a reader that does not know
+// every field a newer daemon records.
+//
+type signedManifest struct {
+	Manifest  json.RawMessage
+	Signature []byte
+}
+
+//
+// Hash the manifest as it was written.  Only whitespace is ignored, so the
+// file can be indented for reading.
+//
+func manifestDigest(signed []byte) ([]byte, error) {
+	var compact bytes.Buffer
+	err := json.Compact(&compact, signed)
+	if err != nil {
+		return nil, err
+	}
+	blob := []byte("dexter manifest")
+	blob = append(blob, 0x00)
+	blob = append(blob, compact.Bytes()...)
+	sum := sha256.Sum256(blob)
+	return sum[:], nil
+}
+
+//
+// Create the manifest for a run of an investigation on this host, signed
+// with its identity key.
+//
+func NewReportManifest(identity *HostIdentity, investigation *Investigation, runs []tasks.TaskManifest) (ReportManifest, error) {
+	sort.Slice(runs, func(i, j int) bool {
+		return runs[i].Task < runs[j].Task
+	})
+	manifest := ReportManifest{
+		InvestigationID: investigation.ID,
+		Hostname:        identity.Name,
+		Platform:        runtime.GOOS + "/" + runtime.GOARCH,
+		Scope:           investigation.Scope,
+		DaemonVersion:   Version,
+		CreatedAt:       time.Now().UTC(),
+		Tasks:           runs,
+		HostPublicKey:   identity.PublicKey(),
+	}
+	signed, err := json.Marshal(manifest)
+	if err != nil {
+		return ReportManifest{}, err
+	}
+	digest, err := manifestDigest(signed)
+	if err != nil {
+		return ReportManifest{}, err
+	}
+	manifest.signed = signed
+	manifest.signature = identity.Sign(digest)
+	return manifest, nil
+}
+
+//
+// Encode a signed manifest as it is written to a report.
+//
+func (manifest ReportManifest) Marshal() ([]byte, error) {
+	if manifest.signed == nil {
+		return nil, errors.New("manifest is not signed")
+	}
+	return json.MarshalIndent(signedManifest{
+		Manifest:  manifest.signed,
+		Signature: manifest.signature,
+	}, "", "  ")
+}
+
+//
+// Check the manifest was signed by the host holding a public key, for the
+// investigation and host named.
+//
+func (manifest ReportManifest) Verify(hostPublicKey []byte, investigationID, hostname string) error {
+	if manifest.InvestigationID != investigationID || manifest.Hostname != hostname {
+		return errors.New("manifest is for investigation " + manifest.InvestigationID + " on host " + manifest.Hostname)
+	}
+	digest, err := manifestDigest(manifest.signed)
+	if err != nil {
+		return err
+	}
+	if !VerifyHostSignature(hostPublicKey, digest, manifest.signature) {
+		return errors.New("manifest signature is not valid for host " + hostname)
+	}
+	return nil
+}
+
+//
+// Read the manifest of a report extracted to a directory without verifying
+// it.
+//
+func ReadReportManifest(dir string) (ReportManifest, error) {
+	var manifest ReportManifest
+	data, err := ioutil.ReadFile(filepath.Join(filepath.FromSlash(dir), ManifestName))
+	if os.IsNotExist(err) {
+		return manifest, ErrNoManifest
+	} else if err != nil {
+		return manifest, errors.New("unable to read report manifest: " + err.Error())
+	}
+	var file signedManifest
+	err = json.Unmarshal(data, &file)
+	if err == nil {
+		err = json.Unmarshal(file.Manifest, &manifest)
+	}
+	if err != nil {
+		return manifest, errors.New("unable to parse report manifest: " + err.Error())
+	}
+	manifest.signed = file.Manifest
+	manifest.signature = file.Signature
+	return manifest, nil
+}
+
+//
+// Read and verify the manifest of a report extracted to a directory,
+// returning it along with each way the files in the directory differ from
+// it.  Files named in ignore, such as ones saved alongside the report by
+// the command line, are not expected to be in the manifest.
+//
+func VerifyReportManifest(dir string, hostPublicKey []byte, investigationID, hostname string, ignore ...string) (ReportManifest, []string, error) {
+	manifest, err := ReadReportManifest(dir)
+	if err != nil {
+		return manifest, nil, err
+	}
+	err = manifest.Verify(hostPublicKey, investigationID, hostname)
+	if err != nil {
+		return manifest, nil, err
+	}
+
+	problems := []string{}
+	expected := map[string]bool{ManifestName: true}
+	for _, name := range ignore {
+		expected[name] = true
+	}
+	for _, run := range manifest.Tasks {
+		for _, artifact := range run.Artifacts {
+			expected[artifact.Path] = true
+			problem := checkArtifact(dir, artifact)
+			if problem != "" {
+				problems = append(problems, problem)
+			}
+		}
+	}
+	err = filepath.Walk(filepath.FromSlash(dir), func(file string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relative, err := filepath.Rel(filepath.FromSlash(dir), file)
+		if err != nil {
+			return err
+		}
+		if name := filepath.ToSlash(relative); !expected[name] {
+			problems = append(problems, name+" is not in the manifest")
+		}
+		return nil
+	})
+	if err != nil {
+		return manifest, problems, err
+	}
+	return manifest, problems, nil
+}
+
+func checkArtifact(dir string, artifact tasks.Artifact) string {
+	if path.IsAbs(artifact.Path) || artifact.Path == ".." || strings.HasPrefix(artifact.Path, "../") {
+		return artifact.Path + " is outside of the report"
+	}
+	file, err := os.Open(filepath.Join(filepath.FromSlash(dir), filepath.FromSlash(artifact.Path)))
+	if err != nil {
+		return artifact.Path + " is missing"
+	}
+	defer file.Close()
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return artifact.Path + " could not be read: " + err.Error()
+	}
+	if size != artifact.Size || hex.EncodeToString(hash.Sum(nil)) != artifact.SHA256 {
+		return artifact.Path + " does not match its hash in the manifest"
+	}
+	return ""
+}
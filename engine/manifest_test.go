@@ -0,0 +1,107 @@
+package engine_test
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/coinbase/dexter/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportManifestCoversEveryArtifact(t *testing.T) {
+	assert := assert.New(t)
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "dexter-manifest")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	source := filepath.Join(dir, "evidence.log")
+	assert.Nil(ioutil.WriteFile(source, []byte("evidence"), 0644))
+	report := filepath.Join(dir, "report") + "/"
+
+	task := tasks.Tasks["get-file"]
+	run := task.Run(context.Background(), report, []string{source, filepath.Join(dir, "missing.log")})
+	assert.Equal("get-file", run.Task)
+	assert.Len(run.Errors, 1)
+	assert.Len(run.Artifacts, 2, "the file collected and the errors file")
+	assert.Equal(source, run.Artifacts[0].Source)
+	assert.Equal(int64(len("evidence")), run.Artifacts[0].Size)
+
+	investigation := engine.Investigation{ID: "abcd1234"}
+	manifest, err := engine.NewReportManifest(identity, &investigation, []tasks.TaskManifest{run})
+	assert.Nil(err)
+	data, err := manifest.Marshal()
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(report+engine.ManifestName, data, 0644))
+
+	_, problems, err := engine.VerifyReportManifest(report, identity.PublicKey(), "abcd1234", identity.Name)
+	assert.Nil(err)
+	assert.Empty(problems)
+	read, err := engine.ReadReportManifest(report)
+	assert.Nil(err)
+	assert.Equal(identity.PublicKey(), read.HostPublicKey)
+	_, _, err = engine.VerifyReportManifest(report, identity.PublicKey(), "abcd1234", "otherhost")
+	assert.NotNil(err)
+
+	// changed, missing, and unexpected files are all reported
+	collected := filepath.Join(report, filepath.FromSlash(run.Artifacts[0].Path))
+	assert.Nil(ioutil.WriteFile(collected, []byte("tampered"), 0644))
+	assert.Nil(os.Remove(filepath.Join(report, "get-file", "errors.txt")))
+	assert.Nil(ioutil.WriteFile(filepath.Join(report, "planted.txt"), []byte("planted"), 0644))
+	_, problems, err = engine.VerifyReportManifest(report, identity.PublicKey(), "abcd1234", identity.Name)
+	assert.Nil(err)
+	assert.Len(problems, 3)
+
+	// the manifest itself cannot be changed
+	tampered := bytes.Replace(data, []byte(source), []byte("/etc/shadow"), -1)
+	assert.NotEqual(data, tampered)
+	assert.Nil(ioutil.WriteFile(report+engine.ManifestName, tampered, 0644))
+	_, _, err = engine.VerifyReportManifest(report, identity.PublicKey(), "abcd1234", identity.Name)
+	assert.NotNil(err)
+
+	assert.Nil(os.Remove(report + engine.ManifestName))
+	_, _, err = engine.VerifyReportManifest(report, identity.PublicKey(), "abcd1234", identity.Name)
+	assert.Equal(engine.ErrNoManifest, err)
+}
+
+func TestReportManifestWithUnknownFieldsVerifies(t *testing.T) {
+	assert := assert.New(t)
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "dexter-manifest")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	// a newer daemon records a field this version does not know about
+	manifest := map[string]interface{}{
+		"InvestigationID": "abcd1234",
+		"Hostname":        identity.Name,
+		"HostPublicKey":   identity.PublicKey(),
+		"Tasks":           []interface{}{},
+		"Attestation":     map[string]string{"quote": "0123"},
+	}
+	signed, err := json.MarshalIndent(manifest, "", "    ")
+	assert.Nil(err)
+	var compact bytes.Buffer
+	assert.Nil(json.Compact(&compact, signed))
+	blob := append([]byte("dexter manifest\x00"), compact.Bytes()...)
+	sum := sha256.Sum256(blob)
+	data, err := json.Marshal(map[string]interface{}{
+		"Manifest":  json.RawMessage(signed),
+		"Signature": identity.Sign(sum[:]),
+	})
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(filepath.Join(dir, engine.ManifestName), data, 0644))
+
+	read, problems, err := engine.VerifyReportManifest(dir, identity.PublicKey(), "abcd1234", identity.Name)
+	assert.Nil(err)
+	assert.Empty(problems)
+	assert.Equal(identity.Name, read.Hostname)
+}
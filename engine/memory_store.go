@@ -0,0 +1,120 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+)
+
+//
+// A store that keeps all files in memory, useful for testing.
+//
+type MemoryStore struct {
+	lock  sync.Mutex
+	files map[string][]byte
+}
+
+//
+// Create a new, empty memory store.
+//
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		files: make(map[string][]byte),
+	}
+}
+
+//
+// Return the data stored at a key.
+//
+func (store *MemoryStore) Get(key string) ([]byte, error) {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	data, ok := store.files[key]
+	if !ok {
+		return []byte{}, keyNotFoundError{key: key}
+	}
+	return append([]byte{}, data...), nil
+}
+
+//
+// Returned when a key is not in a memory store.
+//
+type keyNotFoundError struct {
+	key string
+}
+
+func (err keyNotFoundError) Error() string {
+	return "key not found: " + err.key
+}
+
+//
+// Open the data stored at a key for streaming.
+//
+func (store *MemoryStore) Open(key string) (io.ReadCloser, error) {
+	data, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), nil
+}
+
+//
+// Iterate over the keys that start with a prefix, in lexical order.
+//
+func (store *MemoryStore) Iterate(prefix string) KeyIterator {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	keys := []string{}
+	for key := range store.files {
+		if strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	return &sliceIterator{keys: keys}
+}
+
+//
+// Store data at a key, replacing anything already there.
+//
+func (store *MemoryStore) Put(key string, data io.ReadSeeker) error {
+	bytes, err := ioutil.ReadAll(data)
+	if err != nil {
+		return err
+	}
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	store.files[key] = bytes
+	return nil
+}
+
+//
+// Delete the data stored at a key.
+//
+func (store *MemoryStore) Delete(key string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	if _, ok := store.files[key]; !ok {
+		return keyNotFoundError{key: key}
+	}
+	delete(store.files, key)
+	return nil
+}
+
+//
+// Move the data stored at one key to another.
+//
+func (store *MemoryStore) Move(oldKey, newKey string) error {
+	store.lock.Lock()
+	defer store.lock.Unlock()
+	data, ok := store.files[oldKey]
+	if !ok {
+		return keyNotFoundError{key: oldKey}
+	}
+	store.files[newKey] = data
+	delete(store.files, oldKey)
+	return nil
+}
@@ -0,0 +1,255 @@
+//go:build cgo
+// +build cgo
+
+package engine
+
+import (
+	"crypto/rsa"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/miekg/pkcs11"
+)
+
+//
+// Investigator keys held in a PKCS#11 token are 3072 bit RSA keys that
+// cannot be extracted from the token.  Each key's PKCS#11 ID is its dexter
+// key ID.
+//
+const pkcs11KeyBits = 3072
+
+//
+// A PKCS11Token is a key source backed by a hardware token, or any other
+// device reachable through a PKCS#11 module.
+//
+type PKCS11Token struct {
+	ctx          *pkcs11.Ctx
+	session      pkcs11.SessionHandle
+	currentKeyID string
+	mutex        sync.Mutex
+}
+
+//
+// Open the token with a label using a PKCS#11 module, logging in with a PIN
+// collected from the user.  An empty label selects the first token found.
+// The current key ID names the key used to sign investigations.
+//
+func OpenPKCS11Token(module, label string, pinFunc func() string, currentKeyID string) (*PKCS11Token, error) {
+	ctx := pkcs11.New(module)
+	if ctx == nil {
+		return nil, errors.New("unable to load PKCS#11 module " + module)
+	}
+	err := ctx.Initialize()
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_CRYPTOKI_ALREADY_INITIALIZED) {
+		ctx.Destroy()
+		return nil, err
+	}
+	token := &PKCS11Token{ctx: ctx, currentKeyID: currentKeyID}
+	slot, err := token.findSlot(label)
+	if err != nil {
+		token.Close()
+		return nil, err
+	}
+	token.session, err = ctx.OpenSession(slot, pkcs11.CKF_SERIAL_SESSION|pkcs11.CKF_RW_SESSION)
+	if err != nil {
+		token.Close()
+		return nil, err
+	}
+	err = ctx.Login(token.session, pkcs11.CKU_USER, pinFunc())
+	if err != nil && err != pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+		token.Close()
+		return nil, errors.New("unable to log in to PKCS#11 token: " + err.Error())
+	}
+	return token, nil
+}
+
+func (token *PKCS11Token) findSlot(label string) (uint, error) {
+	slots, err := token.ctx.GetSlotList(true)
+	if err != nil {
+		return 0, err
+	}
+	for _, slot := range slots {
+		info, err := token.ctx.GetTokenInfo(slot)
+		if err != nil {
+			continue
+		}
+		if label == "" || strings.TrimSpace(info.Label) == label {
+			return slot, nil
+		}
+	}
+	if label == "" {
+		return 0, errors.New("no PKCS#11 token found")
+	}
+	return 0, errors.New("no PKCS#11 token labeled \"" + label + "\" found")
+}
+
+//
+// Log out of the token and unload the PKCS#11 module.
+//
+func (token *PKCS11Token) Close() {
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+	if token.session != 0 {
+		token.ctx.Logout(token.session)
+		token.ctx.CloseSession(token.session)
+	}
+	token.ctx.Finalize()
+	token.ctx.Destroy()
+}
+
+//
+// Generate a new key in the token, returning its public half to be added
+// to the local investigator.  The new key becomes the token's current key.
+//
+func (token *PKCS11Token) GenerateKey() (InvestigatorKey, error) {
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+	public, private, err := token.ctx.GenerateKeyPair(token.session,
+		[]*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_KEY_PAIR_GEN, nil)},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PUBLIC_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_VERIFY, true),
+			pkcs11.NewAttribute(pkcs11.CKA_ENCRYPT, true),
+			pkcs11.NewAttribute(pkcs11.CKA_MODULUS_BITS, pkcs11KeyBits),
+			pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, []byte{1, 0, 1}),
+		},
+		[]*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+			pkcs11.NewAttribute(pkcs11.CKA_KEY_TYPE, pkcs11.CKK_RSA),
+			pkcs11.NewAttribute(pkcs11.CKA_TOKEN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_PRIVATE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_SENSITIVE, true),
+			pkcs11.NewAttribute(pkcs11.CKA_EXTRACTABLE, false),
+			pkcs11.NewAttribute(pkcs11.CKA_SIGN, true),
+			pkcs11.NewAttribute(pkcs11.CKA_DECRYPT, true),
+		},
+	)
+	if err != nil {
+		return InvestigatorKey{}, errors.New("unable to generate key in PKCS#11 token: " + err.Error())
+	}
+	attributes, err := token.ctx.GetAttributeValue(token.session, public, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_MODULUS, nil),
+		pkcs11.NewAttribute(pkcs11.CKA_PUBLIC_EXPONENT, nil),
+	})
+	if err != nil {
+		return InvestigatorKey{}, err
+	}
+	publicKey := &rsa.PublicKey{
+		N: new(big.Int).SetBytes(attributes[0].Value),
+		E: int(new(big.Int).SetBytes(attributes[1].Value).Int64()),
+	}
+	key, err := rsaInvestigatorKey(publicKey)
+	if err != nil {
+		return InvestigatorKey{}, err
+	}
+	id, _ := hex.DecodeString(key.ID)
+	for _, object := range []pkcs11.ObjectHandle{public, private} {
+		err = token.ctx.SetAttributeValue(token.session, object, []*pkcs11.Attribute{
+			pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+			pkcs11.NewAttribute(pkcs11.CKA_LABEL, "dexter "+key.ID),
+		})
+		if err != nil {
+			return InvestigatorKey{}, errors.New("unable to label key in PKCS#11 token: " + err.Error())
+		}
+	}
+	token.currentKeyID = key.ID
+	return key, nil
+}
+
+//
+// Find the private key with a dexter key ID in the token.
+//
+func (token *PKCS11Token) findKey(keyID string) (pkcs11.ObjectHandle, error) {
+	id, err := hex.DecodeString(keyID)
+	if err != nil || keyID == "" {
+		return 0, errors.New("no key with ID \"" + keyID + "\" in PKCS#11 token")
+	}
+	err = token.ctx.FindObjectsInit(token.session, []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+		pkcs11.NewAttribute(pkcs11.CKA_ID, id),
+	})
+	if err != nil {
+		return 0, err
+	}
+	objects, _, err := token.ctx.FindObjects(token.session, 1)
+	token.ctx.FindObjectsFinal(token.session)
+	if err != nil {
+		return 0, err
+	}
+	if len(objects) == 0 {
+		return 0, errors.New("no key with ID \"" + keyID + "\" in PKCS#11 token")
+	}
+	return objects[0], nil
+}
+
+//
+// Return the token's current key.
+//
+func (token *PKCS11Token) Signer() (Signer, error) {
+	key, err := token.key(token.currentKeyID)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+//
+// Return a key in the token, if it is there.
+//
+func (token *PKCS11Token) Decrypter(keyID string) (Decrypter, error) {
+	key, err := token.key(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (token *PKCS11Token) key(keyID string) (*pkcs11Key, error) {
+	token.mutex.Lock()
+	defer token.mutex.Unlock()
+	handle, err := token.findKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	return &pkcs11Key{token: token, id: keyID, handle: handle}, nil
+}
+
+type pkcs11Key struct {
+	token  *PKCS11Token
+	id     string
+	handle pkcs11.ObjectHandle
+}
+
+func (key *pkcs11Key) Sign(name string, digest []byte) (Signature, error) {
+	key.token.mutex.Lock()
+	defer key.token.mutex.Unlock()
+	params := pkcs11.NewPSSParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, uint(len(digest)))
+	err := key.token.ctx.SignInit(key.token.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params)}, key.handle)
+	if err != nil {
+		return Signature{}, err
+	}
+	data, err := key.token.ctx.Sign(key.token.session, digest)
+	if err != nil {
+		return Signature{}, err
+	}
+	return Signature{Name: name, KeyID: key.id, Data: data}, nil
+}
+
+func (key *pkcs11Key) UnwrapKey(payload DecryptionPayload) ([]byte, error) {
+	if payload.KeyID != key.id {
+		return nil, errors.New("report key was not wrapped for key " + key.id)
+	}
+	key.token.mutex.Lock()
+	defer key.token.mutex.Unlock()
+	params := pkcs11.NewOAEPParams(pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256, pkcs11.CKZ_DATA_SPECIFIED, nil)
+	err := key.token.ctx.DecryptInit(key.token.session, []*pkcs11.Mechanism{pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)}, key.handle)
+	if err != nil {
+		return nil, err
+	}
+	return key.token.ctx.Decrypt(key.token.session, payload.EncryptedDataEncryptionKey)
+}
@@ -0,0 +1,34 @@
+//go:build !cgo
+// +build !cgo
+
+package engine
+
+import (
+	"errors"
+)
+
+//
+// PKCS#11 modules are shared libraries, which can only be loaded by builds
+// of dexter with cgo enabled.
+//
+var errPKCS11Unsupported = errors.New("this build of dexter does not support PKCS#11, rebuild it with cgo enabled")
+
+type PKCS11Token struct{}
+
+func OpenPKCS11Token(module, label string, pinFunc func() string, currentKeyID string) (*PKCS11Token, error) {
+	return nil, errPKCS11Unsupported
+}
+
+func (token *PKCS11Token) Close() {}
+
+func (token *PKCS11Token) GenerateKey() (InvestigatorKey, error) {
+	return InvestigatorKey{}, errPKCS11Unsupported
+}
+
+func (token *PKCS11Token) Signer() (Signer, error) {
+	return nil, errPKCS11Unsupported
+}
+
+func (token *PKCS11Token) Decrypter(keyID string) (Decrypter, error) {
+	return nil, errPKCS11Unsupported
+}
@@ -0,0 +1,73 @@
+//go:build cgo
+// +build cgo
+
+package engine_test
+
+import (
+	"crypto/sha256"
+	"os"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+//
+// These tests need a PKCS#11 token, such as one created with SoftHSM, and
+// are skipped unless DEXTER_TEST_PKCS11_MODULE is set.
+//
+func openTestToken(t *testing.T) *engine.PKCS11Token {
+	module := os.Getenv("DEXTER_TEST_PKCS11_MODULE")
+	if module == "" {
+		t.Skip("DEXTER_TEST_PKCS11_MODULE is not set")
+	}
+	pin := func() string { return os.Getenv("DEXTER_TEST_PKCS11_PIN") }
+	token, err := engine.OpenPKCS11Token(module, os.Getenv("DEXTER_TEST_PKCS11_TOKEN"), pin, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestPKCS11TokenSignsAndUnwraps(t *testing.T) {
+	assert := assert.New(t)
+	token := openTestToken(t)
+	defer token.Close()
+
+	key, err := token.GenerateKey()
+	assert.Nil(err)
+	assert.Equal(engine.KeyTypeRSA, key.Type)
+	investigator := engine.Investigator{Name: "alice"}
+	investigator.AddKey(key)
+
+	signer, err := token.Signer()
+	assert.Nil(err)
+	digest := sha256.Sum256([]byte("investigation"))
+	sig, err := signer.Sign("alice", digest[:])
+	assert.Nil(err)
+	assert.Equal(key.ID, sig.KeyID)
+	assert.True(investigator.VerifySignature(digest[:], sig))
+
+	payload, err := investigator.WrapKey([]byte("0123456789abcdef"))
+	assert.Nil(err)
+	assert.Equal(key.ID, payload.KeyID)
+	decrypter, err := token.Decrypter(payload.KeyID)
+	assert.Nil(err)
+	dataKey, err := decrypter.UnwrapKey(payload)
+	assert.Nil(err)
+	assert.Equal([]byte("0123456789abcdef"), dataKey)
+
+	// rotating leaves the old key in the token for old reports
+	newKey, err := token.GenerateKey()
+	assert.Nil(err)
+	investigator.AddKey(newKey)
+	assert.False(investigator.VerifySignature(digest[:], sig))
+	decrypter, err = token.Decrypter(key.ID)
+	assert.Nil(err)
+	dataKey, err = decrypter.UnwrapKey(payload)
+	assert.Nil(err)
+	assert.Equal([]byte("0123456789abcdef"), dataKey)
+
+	_, err = token.Decrypter("0000000000000000")
+	assert.NotNil(err)
+}
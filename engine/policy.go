@@ -0,0 +1,246 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/coinbase/dexter/engine/helpers"
+	"github.com/coinbase/dexter/facts"
+	"github.com/coinbase/dexter/tasks"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// The approval policy adds to the consensus each task requires.  Its rules
+// name a task, or "*" for every task, and optionally the facts of the hosts
+// the rule covers.  A rule can require more approvals overall, and a number
+// of approvals from investigators holding a role.  An approver counts
+// towards every role they hold.
+//
+// The policy is read from a file installed on every daemon and
+// investigator machine.  Daemons apply a rule with a scope only on hosts
+// matching it, while the command line, which cannot know which hosts an
+// investigation will reach, applies every rule for the investigation's
+// tasks.
+//
+const AnyTask = "*"
+
+//
+// A number of approvals required from investigators holding a role.
+//
+type RoleRequirement struct {
+	Role  string
+	Count int
+}
+
+//
+// A policy rule adds approvals required to run a task on some hosts.
+//
+type PolicyRule struct {
+	Task      string
+	Scope     map[string][]string `json:",omitempty"`
+	Approvals int                 `json:",omitempty"`
+	Roles     []RoleRequirement   `json:",omitempty"`
+}
+
+//
+// A policy is the list of rules every investigation must satisfy.
+//
+type Policy struct {
+	Rules []PolicyRule
+}
+
+//
+// Parse and check a policy file.
+//
+func ParsePolicy(data []byte) (*Policy, error) {
+	var policy Policy
+	err := json.Unmarshal(data, &policy)
+	if err != nil {
+		return nil, err
+	}
+	for i, rule := range policy.Rules {
+		name := "policy rule " + strconv.Itoa(i+1)
+		if _, ok := tasks.Tasks[rule.Task]; !ok && rule.Task != AnyTask {
+			return nil, errors.New(name + " names unknown task \"" + rule.Task + "\"")
+		}
+		for fact := range rule.Scope {
+			checker, ok := facts.Get(fact)
+			if !ok {
+				return nil, errors.New(name + " is scoped by unknown fact " + fact)
+			}
+			if checker.Private {
+				return nil, errors.New(name + " cannot be scoped by private fact " + fact)
+			}
+		}
+		if rule.Approvals < 0 {
+			return nil, errors.New(name + " requires a negative number of approvals")
+		}
+		for _, role := range rule.Roles {
+			if role.Role == "" || role.Count < 1 {
+				return nil, errors.New(name + " requires roles without a name or count")
+			}
+		}
+	}
+	return &policy, nil
+}
+
+//
+// Return true if any rule requires approvals from a role.
+//
+func (policy *Policy) UsesRoles() bool {
+	for _, rule := range policy.Rules {
+		if len(rule.Roles) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+//
+// Load the approval policy configured on this machine.  If none is
+// configured, nil is returned and only the consensus each task requires
+// applies.  Roles are only trusted from a registry signed by the registry
+// roots, so a policy requiring them is refused when roots is nil.
+//
+func LoadPolicy(roots *RegistryRoots) (*Policy, error) {
+	path := helpers.GetDexterPolicyFile()
+	data, err := ioutil.ReadFile(filepath.FromSlash(path))
+	if os.IsNotExist(err) && os.Getenv("DEXTER_POLICY_FILE") == "" {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.New("unable to read approval policy: " + err.Error())
+	}
+	policy, err := ParsePolicy(data)
+	if err != nil {
+		return nil, errors.New("invalid approval policy " + path + ": " + err.Error())
+	}
+	if roots == nil && policy.UsesRoles() {
+		return nil, errors.New("approval policy " + path + " requires roles, which need registry roots to be trusted")
+	}
+	return policy, nil
+}
+
+//
+// Return true if the rule covers a task.
+//
+func (rule PolicyRule) coversTask(task string) bool {
+	return rule.Task == AnyTask || rule.Task == task
+}
+
+//
+// Return true if this host matches the rule's scope.
+//
+func (rule PolicyRule) coversThisHost() bool {
+	for fact, args := range rule.Scope {
+		checker, ok := facts.Get(fact)
+		if !ok || !checker.Assert(args) {
+			return false
+		}
+	}
+	return true
+}
+
+//
+// Describe what the rule covers, for explaining requirements.
+//
+func (rule PolicyRule) description(task string) string {
+	description := task
+	if len(rule.Scope) == 0 {
+		return description
+	}
+	conditions := []string{}
+	for fact, args := range rule.Scope {
+		conditions = append(conditions, fact+" "+strings.Join(args, " "))
+	}
+	sort.Strings(conditions)
+	return description + " on hosts where " + strings.Join(conditions, " and ")
+}
+
+//
+// An approval requirement is a number of approvals, from investigators
+// holding a role or from anyone when the role is empty, and what requires
+// them.
+//
+type ApprovalRequirement struct {
+	Role   string
+	Count  int
+	Reason string
+}
+
+//
+// Return the approvals an investigation requires under a policy.  When
+// onHost is true, rules scoped to other hosts are left out.
+//
+func (investigation *Investigation) ApprovalRequirements(policy *Policy, onHost bool) []ApprovalRequirement {
+	requirements := []ApprovalRequirement{{Count: 1, Reason: "every investigation"}}
+	names := make([]string, 0, len(investigation.TaskList))
+	for name := range investigation.TaskList {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		task, ok := tasks.Tasks[name]
+		if !ok {
+			log.WithFields(log.Fields{
+				"at":        "engine.ApprovalRequirements",
+				"task_name": name,
+			}).Error("named task not found")
+			continue
+		}
+		if task.ConsensusRequirement > 1 {
+			requirements = append(requirements, ApprovalRequirement{Count: task.ConsensusRequirement, Reason: name})
+		}
+		if policy == nil {
+			continue
+		}
+		for _, rule := range policy.Rules {
+			if !rule.coversTask(name) || (onHost && !rule.coversThisHost()) {
+				continue
+			}
+			if rule.Approvals > 0 {
+				requirements = append(requirements, ApprovalRequirement{Count: rule.Approvals, Reason: rule.description(name)})
+			}
+			for _, role := range rule.Roles {
+				requirements = append(requirements, ApprovalRequirement{Role: role.Role, Count: role.Count, Reason: rule.description(name)})
+			}
+		}
+	}
+	return requirements
+}
+
+//
+// Explain the approvals an investigation is still missing under a policy,
+// returning nothing once it has every approval it needs.
+//
+func (investigation *Investigation) MissingApprovals(trusted *TrustedInvestigators, policy *Policy, onHost bool) []string {
+	approvers := investigation.validApprovers(trusted)
+	missing := []string{}
+	for _, requirement := range investigation.ApprovalRequirements(policy, onHost) {
+		have := 0
+		for _, approver := range approvers {
+			if requirement.Role == "" || approver.HasRole(requirement.Role) {
+				have++
+			}
+		}
+		if have >= requirement.Count {
+			continue
+		}
+		needed := strconv.Itoa(requirement.Count-have) + " more approval"
+		if requirement.Count-have > 1 {
+			needed += "s"
+		}
+		if requirement.Role != "" {
+			needed += " from " + requirement.Role
+		}
+		missing = append(missing, needed+" for "+requirement.Reason)
+	}
+	return missing
+}
@@ -0,0 +1,108 @@
+package engine_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+//
+// Signs as a named investigator whatever name it is asked to sign as.
+//
+type namedSigner struct {
+	engine.Signer
+	name string
+}
+
+func (signer namedSigner) Sign(name string, digest []byte) (engine.Signature, error) {
+	return signer.Signer.Sign(signer.name, digest)
+}
+
+func approve(inv *engine.Investigation, keyring *engine.Keyring, name string) {
+	// signing a copy as its issuer yields a signature over the same data
+	// an approval covers
+	approval := *inv
+	approval.Sign(namedSigner{keyring.Current(), name})
+	inv.Approvers = append(inv.Approvers, approval.Issuer)
+}
+
+func TestParsePolicyRejectsUnusableRules(t *testing.T) {
+	assert := assert.New(t)
+	_, err := engine.ParsePolicy([]byte(`{"Rules": [{"Task": "get-file", "Roles": [{"Role": "security-leads", "Count": 1}]}]}`))
+	assert.Nil(err)
+	for _, policy := range []string{
+		`{"Rules": [{"Task": "no-such-task", "Approvals": 2}]}`,
+		`{"Rules": [{"Task": "*", "Scope": {"no-such-fact": []}}]}`,
+		`{"Rules": [{"Task": "*", "Scope": {"user-exists": ["root"]}}]}`,
+		`{"Rules": [{"Task": "*", "Roles": [{"Role": "security-leads"}]}]}`,
+	} {
+		_, err := engine.ParsePolicy([]byte(policy))
+		assert.NotNil(err, policy)
+	}
+}
+
+func TestPolicyRequiresApprovalsFromRoles(t *testing.T) {
+	assert := assert.New(t)
+	defer engine.ForgetRevocations()
+	store := engine.NewMemoryStore()
+	_, aliceKeys := putTestInvestigator(t, store, "alice")
+	_, carolKeys := putTestInvestigator(t, store, "carol")
+	bob, bobKeys := putTestInvestigator(t, store, "bob")
+	bob.Roles = []string{"security-leads"}
+	data, err := bob.String()
+	assert.Nil(err)
+	assert.Nil(store.Put("investigators/bob.json", bytes.NewReader(data)))
+
+	otherPlatform := "windows"
+	if runtime.GOOS == otherPlatform {
+		otherPlatform = "linux"
+	}
+	policyFile, err := ioutil.TempFile("", "dexter-policy")
+	assert.Nil(err)
+	defer os.Remove(policyFile.Name())
+	_, err = policyFile.WriteString(`{"Rules": [
+		{"Task": "get-file", "Roles": [{"Role": "security-leads", "Count": 1}]},
+		{"Task": "*", "Scope": {"platform-is": ["` + otherPlatform + `"]}, "Approvals": 3}
+	]}`)
+	assert.Nil(err)
+	policyFile.Close()
+	os.Setenv("DEXTER_POLICY_FILE", policyFile.Name())
+	defer os.Unsetenv("DEXTER_POLICY_FILE")
+	_, err = engine.LoadPolicy(nil)
+	assert.NotNil(err, "roles are not trusted without registry roots")
+	// the roles in the store stand in for a registry's below
+	policy, err := engine.LoadPolicy(&engine.RegistryRoots{})
+	assert.Nil(err)
+
+	issued := time.Now().UTC().Truncate(time.Second)
+	inv := engine.Investigation{
+		ID:        "abcd1234",
+		TaskList:  map[string][]string{"get-file": {"/etc/hosts"}},
+		Issuer:    engine.Signature{Name: "alice"},
+		IssuedAt:  issued,
+		NotBefore: issued,
+		ExpiresAt: issued.Add(time.Hour),
+	}
+	inv.Sign(aliceKeys.Current())
+	assert.Equal([]string{"1 more approval for every investigation"}, inv.MissingApprovals(engine.NewTrustedInvestigators(store), nil, true))
+
+	approve(&inv, carolKeys, "carol")
+	assert.Empty(inv.MissingApprovals(engine.NewTrustedInvestigators(store), nil, true), "without a policy any approval is enough")
+	assert.Equal([]string{"1 more approval from security-leads for get-file"}, inv.MissingApprovals(engine.NewTrustedInvestigators(store), policy, true))
+
+	approve(&inv, bobKeys, "bob")
+	assert.Empty(inv.MissingApprovals(engine.NewTrustedInvestigators(store), policy, true))
+	assert.Equal([]string{"1 more approval for get-file on hosts where platform-is " + otherPlatform},
+		inv.MissingApprovals(engine.NewTrustedInvestigators(store), policy, false), "rules scoped to other hosts still apply to the investigation")
+	assert.Equal(3, inv.MinimumConsensus(policy))
+
+	// approvals from revoked investigators do not count towards roles
+	revoke(t, store, "bob", "carol", carolKeys)
+	assert.Equal([]string{"1 more approval from security-leads for get-file"}, inv.MissingApprovals(engine.NewTrustedInvestigators(store), policy, true))
+}
@@ -0,0 +1,423 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coinbase/dexter/engine/helpers"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// The investigator registry is the signed list of investigators trusted to
+// issue and approve investigations and to receive reports.  Each version of
+// the registry must be signed by a threshold of the registry admins listed
+// in the roots file configured on every daemon and investigator machine, so
+// write access to the store alone cannot change who is an investigator.
+//
+// Changes are made by uploading a proposal, the next version of the
+// registry, which admins sign in turn.  Once a proposal has enough
+// signatures it replaces the registry.
+//
+const (
+	RegistryPath         = "registry/registry.json"
+	RegistryProposalPath = "registry/proposal.json"
+)
+
+//
+// A registry admin is named along with the key they sign the registry with.
+//
+type RegistryAdmin struct {
+	Name string
+	Key  InvestigatorKey
+}
+
+//
+// The registry roots are the admins trusted to sign the registry, and the
+// number of them that must sign each version.
+//
+type RegistryRoots struct {
+	Threshold int
+	Admins    []RegistryAdmin
+}
+
+//
+// Parse and check a registry roots file.
+//
+func ParseRegistryRoots(data []byte) (*RegistryRoots, error) {
+	var roots RegistryRoots
+	err := json.Unmarshal(data, &roots)
+	if err != nil {
+		return nil, err
+	}
+	if roots.Threshold < 1 || roots.Threshold > len(roots.Admins) {
+		return nil, errors.New("registry threshold must be between 1 and the number of admins")
+	}
+	names := make(map[string]bool)
+	for _, admin := range roots.Admins {
+		if admin.Name == "" || admin.Key.ID == "" {
+			return nil, errors.New("registry admins must have a name and a versioned key")
+		}
+		if names[admin.Name] {
+			return nil, errors.New("registry admin " + admin.Name + " is listed more than once")
+		}
+		names[admin.Name] = true
+	}
+	return &roots, nil
+}
+
+//
+// Load the registry roots configured on this machine.  If none are
+// configured, it is an error unless unsigned investigators are allowed, in
+// which case nil is returned and investigators are read from the
+// investigators directory of the store as they were before the registry
+// existed.
+//
+func LoadRegistryRoots() (*RegistryRoots, error) {
+	path := helpers.GetDexterRegistryRootsFile()
+	data, err := ioutil.ReadFile(filepath.FromSlash(path))
+	if os.IsNotExist(err) && os.Getenv("DEXTER_REGISTRY_ROOTS") == "" {
+		if helpers.AllowUnsignedInvestigators() {
+			return nil, nil
+		}
+		return nil, errors.New("no registry roots are configured in " + path +
+			", set DEXTER_ALLOW_UNSIGNED_INVESTIGATORS=true to trust the investigators directory of the store instead")
+	} else if err != nil {
+		return nil, errors.New("unable to read registry roots: " + err.Error())
+	}
+	roots, err := ParseRegistryRoots(data)
+	if err != nil {
+		return nil, errors.New("invalid registry roots " + path + ": " + err.Error())
+	}
+	return roots, nil
+}
+
+//
+// Return the admin a signature claims to be from, if they are one.
+//
+func (roots *RegistryRoots) admin(sig Signature) (RegistryAdmin, bool) {
+	for _, admin := range roots.Admins {
+		if admin.Name == sig.Name && admin.Key.ID == sig.KeyID {
+			return admin, true
+		}
+	}
+	return RegistryAdmin{}, false
+}
+
+//
+// Return true if an investigator's current key is a registry admin key.
+//
+func (roots *RegistryRoots) IsAdmin(investigator Investigator) bool {
+	_, ok := roots.admin(Signature{Name: investigator.Name, KeyID: investigator.CurrentKeyID()})
+	return ok
+}
+
+//
+// A version of the investigator registry and the admin signatures on it.
+//
+type Registry struct {
+	Version       int
+	IssuedAt      time.Time
+	Investigators []Investigator
+	Signatures    []Signature
+}
+
+//
+// Return the data admins sign, which covers everything but the signatures.
+//
+func (registry Registry) digest() ([]byte, error) {
+	members, err := json.Marshal(registry.Investigators)
+	if err != nil {
+		return nil, err
+	}
+	blob := []byte("dexter registry")
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(strconv.Itoa(registry.Version))...)
+	blob = append(blob, timestampData("IssuedAt", registry.IssuedAt)...)
+	blob = append(blob, members...)
+	sum := sha256.Sum256(blob)
+	return sum[:], nil
+}
+
+//
+// Return the next version of the registry, with the same investigators and
+// no signatures.
+//
+func (registry Registry) Next() Registry {
+	return registry.changed(registry.Version + 1)
+}
+
+//
+// Return a copy of the registry with an investigator added or replaced.
+// Any signatures are dropped, since they no longer cover its contents.
+//
+func (registry Registry) WithInvestigator(investigator Investigator) Registry {
+	changed := registry.changed(registry.Version)
+	for i, member := range changed.Investigators {
+		if member.Name == investigator.Name {
+			changed.Investigators[i] = investigator
+			return changed
+		}
+	}
+	changed.Investigators = append(changed.Investigators, investigator)
+	sort.Slice(changed.Investigators, func(i, j int) bool {
+		return changed.Investigators[i].Name < changed.Investigators[j].Name
+	})
+	return changed
+}
+
+//
+// Return a copy of the registry without an investigator.  Any signatures
+// are dropped, since they no longer cover its contents.
+//
+func (registry Registry) WithoutInvestigator(name string) Registry {
+	changed := registry.changed(registry.Version)
+	changed.Investigators = []Investigator{}
+	for _, member := range registry.Investigators {
+		if member.Name != name {
+			changed.Investigators = append(changed.Investigators, member)
+		}
+	}
+	return changed
+}
+
+func (registry Registry) changed(version int) Registry {
+	return Registry{
+		Version:       version,
+		IssuedAt:      time.Now().UTC().Truncate(time.Second),
+		Investigators: append([]Investigator{}, registry.Investigators...),
+		Signatures:    []Signature{},
+	}
+}
+
+//
+// Return an investigator in the registry by name.
+//
+func (registry Registry) Investigator(name string) (Investigator, bool) {
+	for _, member := range registry.Investigators {
+		if member.Name == name {
+			return member, true
+		}
+	}
+	return Investigator{}, false
+}
+
+//
+// Sign the registry as a registry admin, replacing any earlier signature
+// by the same admin.
+//
+func (registry *Registry) Sign(signer Signer, name string) error {
+	digest, err := registry.digest()
+	if err != nil {
+		return err
+	}
+	sig, err := signer.Sign(name, digest)
+	if err != nil {
+		return err
+	}
+	signatures := []Signature{sig}
+	for _, existing := range registry.Signatures {
+		if existing.Name != name {
+			signatures = append(signatures, existing)
+		}
+	}
+	registry.Signatures = signatures
+	return nil
+}
+
+//
+// Return the names of the distinct admins with valid signatures on the
+// registry.
+//
+func (registry Registry) ValidSigners(roots *RegistryRoots) []string {
+	signers := []string{}
+	digest, err := registry.digest()
+	if err != nil {
+		return signers
+	}
+	seen := make(map[string]bool)
+	for _, sig := range registry.Signatures {
+		admin, ok := roots.admin(sig)
+		if !ok || seen[admin.Name] || !admin.Key.Verify(digest, sig) {
+			continue
+		}
+		seen[admin.Name] = true
+		signers = append(signers, admin.Name)
+	}
+	sort.Strings(signers)
+	return signers
+}
+
+//
+// Check the registry is signed by enough registry admins and lists each
+// investigator once.
+//
+func (registry Registry) Verify(roots *RegistryRoots) error {
+	if registry.Version < 1 {
+		return errors.New("registry has no version")
+	}
+	names := make(map[string]bool)
+	for _, member := range registry.Investigators {
+		if member.Name == "" || names[member.Name] {
+			return errors.New("registry lists investigator \"" + member.Name + "\" more than once")
+		}
+		names[member.Name] = true
+	}
+	signers := registry.ValidSigners(roots)
+	if len(signers) < roots.Threshold {
+		return errors.New("registry version " + strconv.Itoa(registry.Version) + " has " +
+			strconv.Itoa(len(signers)) + " of the " + strconv.Itoa(roots.Threshold) + " admin signatures it needs")
+	}
+	return nil
+}
+
+//
+// Upload the registry to a path in the store.
+//
+func (registry Registry) Upload(store Store, path string) error {
+	data, err := json.MarshalIndent(registry, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put(path, bytes.NewReader(data))
+}
+
+//
+// The highest registry version this process has accepted.  Older versions
+// are refused afterwards, so a registry cannot be rolled back to restore a
+// revoked investigator.
+//
+var registryFloor struct {
+	sync.Mutex
+	version int
+	file    string
+}
+
+//
+// Refuse registry versions older than one already seen, such as one saved
+// in the daemon state by an earlier run.
+//
+func RaiseRegistryFloor(version int) {
+	registryFloor.Lock()
+	defer registryFloor.Unlock()
+	if version > registryFloor.version {
+		registryFloor.version = version
+	}
+}
+
+//
+// Keep the registry floor in a file, for processes such as the command
+// line that have no daemon state to keep it in.  The floor saved in the
+// file is raised to, and each newer version accepted is saved to it.
+//
+func UseRegistryFloorFile(path string) error {
+	data, err := ioutil.ReadFile(filepath.FromSlash(path))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.New("unable to read registry floor: " + err.Error())
+	}
+	version := 0
+	if err == nil {
+		version, err = strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return errors.New("invalid registry floor " + path + ": " + err.Error())
+		}
+	}
+	RaiseRegistryFloor(version)
+	registryFloor.Lock()
+	defer registryFloor.Unlock()
+	registryFloor.file = path
+	return nil
+}
+
+//
+// Return the highest registry version this process has accepted.
+//
+func RegistryFloor() int {
+	registryFloor.Lock()
+	defer registryFloor.Unlock()
+	return registryFloor.version
+}
+
+func readRegistry(store Store, path string) (Registry, error) {
+	data, err := store.Get(path)
+	if err != nil {
+		return Registry{}, err
+	}
+	var registry Registry
+	err = json.Unmarshal(data, &registry)
+	return registry, err
+}
+
+//
+// Load the current registry from the store and verify it against the
+// registry roots.
+//
+func LoadRegistry(store Store, roots *RegistryRoots) (Registry, error) {
+	registry, err := readRegistry(store, RegistryPath)
+	if err != nil {
+		return Registry{}, errors.New("unable to load investigator registry: " + err.Error())
+	}
+	err = registry.Verify(roots)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":      "engine.LoadRegistry",
+			"error":   err.Error(),
+			"version": registry.Version,
+		}).Error("investigator registry is not trusted")
+		return Registry{}, err
+	}
+	registryFloor.Lock()
+	defer registryFloor.Unlock()
+	if registry.Version < registryFloor.version {
+		log.WithFields(log.Fields{
+			"at":      "engine.LoadRegistry",
+			"version": registry.Version,
+			"floor":   registryFloor.version,
+		}).Error("investigator registry was rolled back")
+		return Registry{}, errors.New("investigator registry version " + strconv.Itoa(registry.Version) +
+			" is older than version " + strconv.Itoa(registryFloor.version) + " seen before")
+	}
+	if registry.Version > registryFloor.version && registryFloor.file != "" {
+		err = saveRegistryFloor(registryFloor.file, registry.Version)
+		if err != nil {
+			return Registry{}, err
+		}
+	}
+	registryFloor.version = registry.Version
+	return registry, nil
+}
+
+func saveRegistryFloor(path string, version int) error {
+	err := os.MkdirAll(filepath.Dir(filepath.FromSlash(path)), 0700)
+	if err != nil {
+		return errors.New("unable to save registry floor: " + err.Error())
+	}
+	tmp := filepath.FromSlash(path) + ".tmp"
+	err = ioutil.WriteFile(tmp, []byte(strconv.Itoa(version)+"\n"), 0600)
+	if err == nil {
+		err = os.Rename(tmp, filepath.FromSlash(path))
+	}
+	if err != nil {
+		return errors.New("unable to save registry floor: " + err.Error())
+	}
+	return nil
+}
+
+//
+// Load the proposed next version of the registry from the store, without
+// verifying its signatures.
+//
+func LoadRegistryProposal(store Store) (Registry, error) {
+	return readRegistry(store, RegistryProposalPath)
+}
@@ -0,0 +1,193 @@
+package engine_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestAdmin(t *testing.T, name string) (engine.Investigator, engine.Signer) {
+	investigator, keyPEM, err := engine.NewInvestigator(name, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := engine.ParseKeyring(keyPEM, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return investigator, keyring.Current()
+}
+
+func newTestRoots(threshold int, admins ...engine.Investigator) *engine.RegistryRoots {
+	roots := &engine.RegistryRoots{Threshold: threshold}
+	for _, admin := range admins {
+		roots.Admins = append(roots.Admins, engine.RegistryAdmin{Name: admin.Name, Key: admin.Keys[0]})
+	}
+	return roots
+}
+
+func TestRegistryNeedsThresholdOfAdminSignatures(t *testing.T) {
+	assert := assert.New(t)
+
+	alice, aliceSigner := newTestAdmin(t, "alice")
+	bob, bobSigner := newTestAdmin(t, "bob")
+	carol, _ := newTestAdmin(t, "carol")
+	mallory, mallorySigner := newTestAdmin(t, "mallory")
+	roots := newTestRoots(2, alice, bob, carol)
+
+	registry := engine.Registry{}.Next().WithInvestigator(alice).WithInvestigator(mallory)
+	assert.Equal(1, registry.Version)
+	assert.Nil(registry.Sign(aliceSigner, "alice"))
+	assert.NotNil(registry.Verify(roots))
+
+	// signing twice, or signing without being an admin, does not count
+	assert.Nil(registry.Sign(aliceSigner, "alice"))
+	assert.Nil(registry.Sign(mallorySigner, "mallory"))
+	assert.Len(registry.Signatures, 2)
+	assert.Equal([]string{"alice"}, registry.ValidSigners(roots))
+	assert.NotNil(registry.Verify(roots))
+
+	// nor does a signature under an admin's name from another key
+	assert.Nil(registry.Sign(mallorySigner, "bob"))
+	assert.NotNil(registry.Verify(roots))
+
+	assert.Nil(registry.Sign(bobSigner, "bob"))
+	assert.Nil(registry.Verify(roots))
+	assert.Equal([]string{"alice", "bob"}, registry.ValidSigners(roots))
+
+	// changing the members invalidates the signatures
+	tampered := registry
+	tampered.Investigators = append([]engine.Investigator{}, registry.Investigators...)
+	tampered.Investigators[1].Keys = bob.Keys
+	assert.NotNil(tampered.Verify(roots))
+	assert.Len(registry.WithoutInvestigator("mallory").Signatures, 0)
+}
+
+func TestInvestigatorsComeFromSignedRegistry(t *testing.T) {
+	assert := assert.New(t)
+
+	alice, aliceSigner := newTestAdmin(t, "alice")
+	bob, bobSigner := newTestAdmin(t, "bob")
+	mallory, _ := newTestAdmin(t, "mallory")
+	roots := newTestRoots(2, alice, bob)
+
+	dir, err := ioutil.TempDir("", "dexter-registry")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	data, err := json.Marshal(roots)
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(dir+"/registry-roots.json", data, 0644))
+	os.Setenv("DEXTER_REGISTRY_ROOTS", dir+"/registry-roots.json")
+	defer os.Unsetenv("DEXTER_REGISTRY_ROOTS")
+
+	store := engine.NewMemoryStore()
+	publish := func(registry engine.Registry) {
+		assert.Nil(registry.Sign(aliceSigner, "alice"))
+		assert.Nil(registry.Sign(bobSigner, "bob"))
+		assert.Nil(registry.Upload(store, engine.RegistryPath))
+	}
+
+	// without a registry, nobody is trusted
+	_, err = engine.InvestigatorByName(store, "alice")
+	assert.NotNil(err)
+
+	first := engine.Registry{}.Next().WithInvestigator(alice).WithInvestigator(bob).WithInvestigator(mallory)
+	publish(first)
+	found, err := engine.InvestigatorByName(store, "mallory")
+	assert.Nil(err)
+	assert.Equal(mallory.CurrentKeyID(), found.CurrentKeyID())
+
+	// investigator files written straight to the store are ignored
+	intruder, _ := newTestAdmin(t, "eve")
+	data, err = intruder.String()
+	assert.Nil(err)
+	assert.Nil(store.Put("investigators/eve.json", bytes.NewReader(data)))
+	_, err = engine.InvestigatorByName(store, "eve")
+	assert.NotNil(err)
+
+	// a registry without enough signatures is not trusted
+	unsigned := first.Next().WithInvestigator(intruder)
+	assert.Nil(unsigned.Sign(aliceSigner, "alice"))
+	assert.Nil(unsigned.Upload(store, engine.RegistryPath))
+	_, err = engine.InvestigatorByName(store, "alice")
+	assert.NotNil(err)
+
+	second := first.Next().WithoutInvestigator("mallory")
+	publish(second)
+	_, err = engine.InvestigatorByName(store, "mallory")
+	assert.NotNil(err)
+	assert.Equal(2, engine.RegistryFloor())
+
+	// an older registry cannot be restored once a newer one was seen
+	publish(first)
+	_, err = engine.InvestigatorByName(store, "mallory")
+	assert.NotNil(err)
+}
+
+func TestRegistryRootsAreRequired(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("DEXTER_ALLOW_UNSIGNED_INVESTIGATORS", "false")
+	defer os.Setenv("DEXTER_ALLOW_UNSIGNED_INVESTIGATORS", "true")
+	dir, err := ioutil.TempDir("", "dexter-registry")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	os.Setenv("DEXTER_REGISTRY_ROOTS", dir+"/registry-roots.json")
+	defer os.Unsetenv("DEXTER_REGISTRY_ROOTS")
+
+	store := engine.NewMemoryStore()
+	putTestInvestigator(t, store, "alice")
+	_, err = engine.LoadRegistryRoots()
+	assert.NotNil(err)
+	_, err = engine.InvestigatorByName(store, "alice")
+	assert.NotNil(err, "investigators in the store are not trusted without roots")
+	_, err = engine.LoadInvestigators(store)
+	assert.NotNil(err)
+}
+
+func TestRegistryFloorIsKeptInAFile(t *testing.T) {
+	assert := assert.New(t)
+	engine.ForgetRegistryFloor()
+	defer engine.ForgetRegistryFloor()
+
+	alice, aliceSigner := newTestAdmin(t, "alice")
+	mallory, _ := newTestAdmin(t, "mallory")
+	dir, err := ioutil.TempDir("", "dexter-registry")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	data, err := json.Marshal(newTestRoots(1, alice))
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(dir+"/registry-roots.json", data, 0644))
+	os.Setenv("DEXTER_REGISTRY_ROOTS", dir+"/registry-roots.json")
+	defer os.Unsetenv("DEXTER_REGISTRY_ROOTS")
+	floor := dir + "/registry-floor"
+	assert.Nil(engine.UseRegistryFloorFile(floor))
+
+	store := engine.NewMemoryStore()
+	publish := func(registry engine.Registry) {
+		assert.Nil(registry.Sign(aliceSigner, "alice"))
+		assert.Nil(registry.Upload(store, engine.RegistryPath))
+	}
+	first := engine.Registry{}.Next().WithInvestigator(alice).WithInvestigator(mallory)
+	publish(first)
+	_, err = engine.InvestigatorByName(store, "mallory")
+	assert.Nil(err)
+	publish(first.Next().WithoutInvestigator("mallory"))
+	_, err = engine.InvestigatorByName(store, "mallory")
+	assert.NotNil(err)
+	saved, err := ioutil.ReadFile(floor)
+	assert.Nil(err)
+	assert.Equal("2\n", string(saved))
+
+	// a later run refuses the older registry too
+	engine.ForgetRegistryFloor()
+	assert.Nil(engine.UseRegistryFloorFile(floor))
+	assert.Equal(2, engine.RegistryFloor())
+	publish(first)
+	_, err = engine.InvestigatorByName(store, "mallory")
+	assert.NotNil(err)
+}
@@ -0,0 +1,137 @@
+package engine
+
+import (
+	"regexp"
+	"strings"
+)
+
+//
+// Each host uploads its report for an investigation to its own directory:
+//
+//   reports/<ID>/<host>/report.zip.enc        the report, encrypted once
+//   reports/<ID>/<host>/<investigator>.decrypt the report key, wrapped for
+//                                              one recipient
+//   reports/<ID>/<host>/execution.json        the host's ledger entry
+//
+// Daemons before this layout encrypted the whole report separately for each
+// recipient, to reports/<ID>-<host>.<investigator>.zip.enc and .decrypt.
+// Archived reports of either layout are prefixed with an underscore.
+//
+const (
+	reportsPrefix           = "reports/"
+	encryptedReportName     = "report.zip.enc"
+	executionRecordName     = "execution.json"
+	decryptionPayloadSuffix = ".decrypt"
+)
+
+var legacyReportPattern = regexp.MustCompile(`^(.+?)-(.+)\.(.+)\.zip\.enc$`)
+
+//
+// A ReportFile describes the copy of a host's report readable by one
+// recipient, and where each part of it is kept in the store.
+//
+type ReportFile struct {
+	ID        string
+	Hostname  string
+	Recipient string
+	Archived  bool
+	Legacy    bool
+}
+
+func (file ReportFile) prefix() string {
+	if file.Archived {
+		return reportsPrefix + "_"
+	}
+	return reportsPrefix
+}
+
+//
+// Return the path of the encrypted report.
+//
+func (file ReportFile) EncryptedReportPath() string {
+	if file.Legacy {
+		return file.prefix() + file.ID + "-" + file.Hostname + "." + file.Recipient + ".zip.enc"
+	}
+	return file.prefix() + file.ID + "/" + file.Hostname + "/" + encryptedReportName
+}
+
+//
+// Return the path of the recipient's wrapped report key.
+//
+func (file ReportFile) DecryptionPayloadPath() string {
+	if file.Legacy {
+		return file.prefix() + file.ID + "-" + file.Hostname + "." + file.Recipient + decryptionPayloadSuffix
+	}
+	return file.prefix() + file.ID + "/" + file.Hostname + "/" + file.Recipient + decryptionPayloadSuffix
+}
+
+//
+// Return the path of the ledger entry the host uploaded with the report.
+//
+func (file ReportFile) ExecutionRecordPath() string {
+	if file.Legacy {
+		return file.prefix() + file.ID + "-" + file.Hostname + ".execution.json"
+	}
+	return file.prefix() + file.ID + "/" + file.Hostname + "/" + executionRecordName
+}
+
+//
+// Parse a key in the reports directory, returning the report file it
+// belongs to a recipient of.  Only wrapped keys in the current layout, and
+// encrypted reports in the legacy layout, name a recipient.
+//
+func ParseReportKey(key string) (ReportFile, bool) {
+	if !strings.HasPrefix(key, reportsPrefix) {
+		return ReportFile{}, false
+	}
+	name := strings.TrimPrefix(key, reportsPrefix)
+	archived := strings.HasPrefix(name, "_")
+	name = strings.TrimPrefix(name, "_")
+
+	parts := strings.Split(name, "/")
+	if len(parts) == 3 {
+		recipient := strings.TrimSuffix(parts[2], decryptionPayloadSuffix)
+		if parts[0] == "" || parts[1] == "" || recipient == "" || recipient == parts[2] {
+			return ReportFile{}, false
+		}
+		return ReportFile{
+			ID:        parts[0],
+			Hostname:  parts[1],
+			Recipient: recipient,
+			Archived:  archived,
+		}, true
+	}
+	if len(parts) != 1 {
+		return ReportFile{}, false
+	}
+	matches := legacyReportPattern.FindStringSubmatch(name)
+	if len(matches) < 4 {
+		return ReportFile{}, false
+	}
+	return ReportFile{
+		ID:        matches[1],
+		Hostname:  matches[2],
+		Recipient: matches[3],
+		Archived:  archived,
+		Legacy:    true,
+	}, true
+}
+
+//
+// List every report file in the store, in either layout.  Accepts a boolean
+// to determine if archived reports should be returned as well.
+//
+func ReportFiles(store Store, archived bool) ([]ReportFile, error) {
+	files := make([]ReportFile, 0)
+	seen := make(map[ReportFile]bool)
+	iterator := store.Iterate(reportsPrefix)
+	for iterator.Next() {
+		file, ok := ParseReportKey(iterator.Key())
+		if !ok || seen[file] || (file.Archived && !archived) {
+			continue
+		}
+		seen[file] = true
+		files = append(files, file)
+	}
+	return files, iterator.Err()
+}
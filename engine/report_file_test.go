@@ -0,0 +1,67 @@
+package engine_test
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReportFilesReadsBothLayouts(t *testing.T) {
+	assert := assert.New(t)
+
+	store := engine.NewMemoryStore()
+	for _, key := range []string{
+		"reports/abcd1234/web-1/report.zip.enc",
+		"reports/abcd1234/web-1/alice.decrypt",
+		"reports/abcd1234/web-1/bob.decrypt",
+		"reports/abcd1234/web-1/execution.json",
+		"reports/_ffff0000/db-1/report.zip.enc",
+		"reports/_ffff0000/db-1/alice.decrypt",
+		"reports/bcde2345-old-host.alice.zip.enc",
+		"reports/bcde2345-old-host.alice.decrypt",
+		"reports/bcde2345-old-host.execution.json",
+	} {
+		assert.Nil(store.Put(key, bytes.NewReader([]byte{})))
+	}
+
+	files, err := engine.ReportFiles(store, false)
+	assert.Nil(err)
+	assert.Equal([]engine.ReportFile{
+		{ID: "abcd1234", Hostname: "web-1", Recipient: "alice"},
+		{ID: "abcd1234", Hostname: "web-1", Recipient: "bob"},
+		{ID: "bcde2345", Hostname: "old-host", Recipient: "alice", Legacy: true},
+	}, files)
+
+	// every recipient of a host's report shares one encrypted report
+	assert.Equal("reports/abcd1234/web-1/report.zip.enc", files[0].EncryptedReportPath())
+	assert.Equal(files[0].EncryptedReportPath(), files[1].EncryptedReportPath())
+	assert.Equal("reports/abcd1234/web-1/bob.decrypt", files[1].DecryptionPayloadPath())
+	assert.Equal("reports/abcd1234/web-1/execution.json", files[1].ExecutionRecordPath())
+
+	assert.Equal("reports/bcde2345-old-host.alice.zip.enc", files[2].EncryptedReportPath())
+	assert.Equal("reports/bcde2345-old-host.alice.decrypt", files[2].DecryptionPayloadPath())
+	assert.Equal("reports/bcde2345-old-host.execution.json", files[2].ExecutionRecordPath())
+
+	files, err = engine.ReportFiles(store, true)
+	assert.Nil(err)
+	assert.Len(files, 4)
+	archived := engine.ReportFile{ID: "ffff0000", Hostname: "db-1", Recipient: "alice", Archived: true}
+	assert.Contains(files, archived)
+	assert.Equal("reports/_ffff0000/db-1/report.zip.enc", archived.EncryptedReportPath())
+}
+
+func TestParseReportKeyIgnoresOtherFiles(t *testing.T) {
+	for _, key := range []string{
+		"reports/abcd1234/web-1/report.zip.enc",
+		"reports/abcd1234/web-1/execution.json",
+		"reports/abcd1234/web-1/.decrypt",
+		"reports/abcd1234-host.alice.decrypt",
+		"reports/abcd1234/web-1/extra/alice.decrypt",
+		"investigations/abcd1234.alice",
+	} {
+		_, ok := engine.ParseReportKey(key)
+		assert.False(t, ok, key)
+	}
+}
@@ -0,0 +1,67 @@
+package engine
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/coinbase/dexter/util"
+
+	"github.com/fatih/color"
+)
+
+//
+// Given a prefix for an ID in Dexter, return the full ID if there is enough
+// specificity.  If there is too much ambiguity in the ID, and there are
+// multiple possible matches, return an error.  This function works for both
+// investigation and report IDs.
+//
+func ResolveUUID(store Store, partial string) (string, error) {
+	allUUIDs := investigationUUIDs(store)
+	allUUIDs = util.AppendUnique(allUUIDs, reportUUIDs(store))
+
+	possibleMatches := make([]string, 0)
+	for _, uuid := range allUUIDs {
+		if strings.HasPrefix(uuid, partial) {
+			possibleMatches = append(possibleMatches, uuid)
+		}
+	}
+
+	if len(possibleMatches) == 1 {
+		return possibleMatches[0], nil
+	} else if len(possibleMatches) > 1 {
+		return "", errors.New("too many possible UUID matches")
+	}
+	return "", errors.New("no possible UUID matches")
+}
+
+func investigationUUIDs(store Store) []string {
+	return uuidsUnderPrefix(store, "investigations/")
+}
+
+func reportUUIDs(store Store) []string {
+	return uuidsUnderPrefix(store, "reports/")
+}
+
+//
+// Collect the unique IDs that begin each key under a prefix.
+//
+func uuidsUnderPrefix(store Store, prefix string) []string {
+	seenUUIDs := make(map[string]bool)
+	allUUIDs := make([]string, 0)
+	iterator := store.Iterate(prefix)
+	for iterator.Next() {
+		filename := iterator.Key()
+		if len(filename) < len(prefix)+8 {
+			continue
+		}
+		uuid := filename[len(prefix) : len(prefix)+8]
+		if _, present := seenUUIDs[uuid]; !present {
+			seenUUIDs[uuid] = true
+			allUUIDs = append(allUUIDs, uuid)
+		}
+	}
+	if iterator.Err() != nil {
+		color.HiRed(iterator.Err().Error())
+	}
+	return allUUIDs
+}
@@ -0,0 +1,393 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// A revocation record withdraws an investigator's trust without deleting
+// anything else.  While a valid record exists, no new reports are wrapped
+// for the investigator and their signatures on investigations no longer
+// count.  Reports already sent to them are kept as evidence, and can be
+// archived or re-keyed for another investigator separately.
+//
+// When registry roots are configured, a revocation must be signed by one
+// registry admin, so a single admin can act quickly on a compromised key.
+// Otherwise it must be signed by a known investigator.  Verified
+// revocations are remembered, and kept in the daemon state, so a record
+// deleted from the store stays in force.
+//
+const revocationsPrefix = "revocations/"
+
+//
+// A revocation names the investigator revoked, why, and when, signed by
+// whoever revoked them.
+//
+type Revocation struct {
+	Name      string
+	Reason    string
+	RevokedAt time.Time
+	Revoker   Signature
+}
+
+//
+// Return the path of an investigator's revocation record in the store.
+//
+func RevocationPath(name string) string {
+	return revocationsPrefix + name + ".json"
+}
+
+//
+// Create an unsigned revocation record for an investigator.
+//
+func NewRevocation(name, reason string) Revocation {
+	return Revocation{
+		Name:      name,
+		Reason:    reason,
+		RevokedAt: time.Now().UTC().Truncate(time.Second),
+	}
+}
+
+func (revocation Revocation) digest() []byte {
+	blob := []byte("dexter revocation")
+	blob = append(blob, 0x00)
+	for _, field := range []string{revocation.Name, revocation.Reason} {
+		blob = append(blob, []byte(field)...)
+		blob = append(blob, 0x00)
+	}
+	blob = append(blob, timestampData("RevokedAt", revocation.RevokedAt)...)
+	sum := sha256.Sum256(blob)
+	return sum[:]
+}
+
+//
+// Sign the revocation as the investigator or registry admin revoking.
+//
+func (revocation *Revocation) Sign(signer Signer, revoker string) error {
+	sig, err := signer.Sign(revoker, revocation.digest())
+	if err != nil {
+		return err
+	}
+	revocation.Revoker = sig
+	return nil
+}
+
+//
+// Check the revocation was signed by someone allowed to revoke
+// investigators.
+//
+func (revocation Revocation) Verify(store Store) error {
+	return revocation.verify(NewTrustedInvestigators(store))
+}
+
+func (revocation Revocation) verify(trusted *TrustedInvestigators) error {
+	if trusted.rootsErr != nil {
+		return trusted.rootsErr
+	}
+	if trusted.roots != nil {
+		admin, ok := trusted.roots.admin(revocation.Revoker)
+		if !ok {
+			return errors.New(revocation.Revoker.Name + " is not a registry admin")
+		}
+		if !admin.Key.Verify(revocation.digest(), revocation.Revoker) {
+			return errors.New("revocation signature by " + revocation.Revoker.Name + " is not valid")
+		}
+		return nil
+	}
+	revoker, err := trusted.lookup(revocation.Revoker.Name)
+	if err != nil {
+		return err
+	}
+	if !revoker.VerifySignatureWithin(revocation.digest(), revocation.Revoker, revocation.RevokedAt, revocation.RevokedAt) {
+		return errors.New("revocation signature by " + revocation.Revoker.Name + " is not valid")
+	}
+	return nil
+}
+
+//
+// Upload the revocation record to the store.
+//
+func (revocation Revocation) Upload(store Store) error {
+	data, err := json.MarshalIndent(revocation, "", "  ")
+	if err != nil {
+		return err
+	}
+	return store.Put(RevocationPath(revocation.Name), bytes.NewReader(data))
+}
+
+//
+// The revocations this process has verified, along with any saved in the
+// daemon state by an earlier run.  A revocation is never forgotten once
+// verified, so deleting its record from the store does not restore an
+// investigator's trust.
+//
+var verifiedRevocations struct {
+	sync.Mutex
+	byName map[string]Revocation
+}
+
+//
+// Treat revocations verified before, such as ones saved in the daemon
+// state by an earlier run, as still in force.
+//
+func RememberRevocations(revocations []Revocation) {
+	verifiedRevocations.Lock()
+	defer verifiedRevocations.Unlock()
+	if verifiedRevocations.byName == nil {
+		verifiedRevocations.byName = make(map[string]Revocation)
+	}
+	for _, revocation := range revocations {
+		verifiedRevocations.byName[revocation.Name] = revocation
+	}
+}
+
+//
+// Return every revocation this process has verified or remembered.
+//
+func RememberedRevocations() []Revocation {
+	verifiedRevocations.Lock()
+	defer verifiedRevocations.Unlock()
+	list := []Revocation{}
+	for _, revocation := range verifiedRevocations.byName {
+		list = append(list, revocation)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+func rememberedRevocation(name string) (Revocation, bool) {
+	verifiedRevocations.Lock()
+	defer verifiedRevocations.Unlock()
+	revocation, ok := verifiedRevocations.byName[name]
+	return revocation, ok
+}
+
+//
+// Return the valid revocation record for an investigator, if there is one.
+// Records that fail to verify are logged and ignored.  An error is
+// returned if the store could not be read, since the investigator may have
+// been revoked.
+//
+func LoadRevocation(store Store, name string) (Revocation, bool, error) {
+	return NewTrustedInvestigators(store).revocation(name)
+}
+
+func loadRevocation(trusted *TrustedInvestigators, name string) (Revocation, bool, error) {
+	remembered, wasRevoked := rememberedRevocation(name)
+	data, err := trusted.store.Get(RevocationPath(name))
+	if wasRevoked && err != nil {
+		return remembered, true, nil
+	} else if isNotFound(err) {
+		return Revocation{}, false, nil
+	} else if err != nil {
+		return Revocation{}, false, errors.New("unable to check whether " + name + " was revoked: " + err.Error())
+	}
+	var revocation Revocation
+	err = json.Unmarshal(data, &revocation)
+	if err == nil && revocation.Name != name {
+		err = errors.New("revocation record for " + name + " names " + revocation.Name)
+	}
+	if err == nil {
+		err = revocation.verify(trusted)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":           "engine.LoadRevocation",
+			"investigator": name,
+			"error":        err.Error(),
+		}).Error("ignoring invalid revocation record")
+		return remembered, wasRevoked, nil
+	}
+	RememberRevocations([]Revocation{revocation})
+	return revocation, true, nil
+}
+
+//
+// Return the valid revocation records in the store, and any remembered
+// from earlier.
+//
+func LoadRevocations(store Store) []Revocation {
+	return loadRevocations(NewTrustedInvestigators(store))
+}
+
+func loadRevocations(trusted *TrustedInvestigators) (list []Revocation) {
+	names := make(map[string]bool)
+	iterator := trusted.store.Iterate(revocationsPrefix)
+	for iterator.Next() {
+		names[strings.TrimSuffix(strings.TrimPrefix(iterator.Key(), revocationsPrefix), ".json")] = true
+	}
+	if iterator.Err() != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.LoadRevocations",
+			"error": iterator.Err().Error(),
+		}).Error("unable to list revocations")
+	}
+	for _, revocation := range RememberedRevocations() {
+		names[revocation.Name] = true
+	}
+	sorted := []string{}
+	for name := range names {
+		sorted = append(sorted, name)
+	}
+	sort.Strings(sorted)
+	for _, name := range sorted {
+		revocation, ok, err := trusted.revocation(name)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":           "engine.LoadRevocations",
+				"investigator": name,
+				"error":        err.Error(),
+			}).Error("unable to load revocation")
+		} else if ok {
+			list = append(list, revocation)
+		}
+	}
+	return
+}
+
+//
+// Archive the reports sent to an investigator, matching their name
+// exactly.  Their wrapped keys are moved to the archive, and so is each
+// encrypted report and execution record once no other recipient can still
+// read it.  The archived reports are returned.
+//
+func ArchiveReportsFor(store Store, name string) ([]ReportFile, error) {
+	files, err := ReportFiles(store, false)
+	if err != nil {
+		return nil, err
+	}
+	moved := make(map[string]bool)
+	shared := make(map[string]bool)
+	for _, file := range files {
+		if file.Recipient != name {
+			shared[file.EncryptedReportPath()] = true
+			shared[file.ExecutionRecordPath()] = true
+		}
+	}
+	archived := []ReportFile{}
+	for _, file := range files {
+		if file.Recipient != name {
+			continue
+		}
+		archive := file
+		archive.Archived = true
+		moves := [][2]string{{file.DecryptionPayloadPath(), archive.DecryptionPayloadPath()}}
+		for _, path := range [][2]string{
+			{file.EncryptedReportPath(), archive.EncryptedReportPath()},
+			{file.ExecutionRecordPath(), archive.ExecutionRecordPath()},
+		} {
+			if !shared[path[0]] {
+				moves = append(moves, path)
+			}
+		}
+		for _, move := range moves {
+			if moved[move[0]] {
+				continue
+			}
+			exists, err := keyExists(store, move[0])
+			if err != nil {
+				return archived, err
+			}
+			if !exists {
+				continue
+			}
+			err = store.Move(move[0], move[1])
+			if err != nil {
+				return archived, err
+			}
+			moved[move[0]] = true
+		}
+		archived = append(archived, archive)
+	}
+	return archived, nil
+}
+
+//
+// Pass the keys of the reports sent to an investigator, matching their
+// name exactly, on to another investigator.  Only reports the signer also
+// received can be passed on, and reports in the legacy layout, which were
+// encrypted separately for each recipient, cannot be.  The reports passed
+// on and the reports skipped are returned.
+//
+func RekeyReportsFor(store Store, name string, target Investigator, keys KeySource, signer Signer, signerName string) (rekeyed []ReportFile, skipped []ReportFile, err error) {
+	files, err := ReportFiles(store, false)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, file := range files {
+		if file.Recipient != name {
+			continue
+		}
+		err := rekeyReport(store, file, target, keys, signer, signerName)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":       "engine.RekeyReportsFor",
+				"id":       file.ID,
+				"hostname": file.Hostname,
+				"error":    err.Error(),
+			}).Warn("unable to pass on report key")
+			skipped = append(skipped, file)
+			continue
+		}
+		rekeyed = append(rekeyed, file)
+	}
+	return rekeyed, skipped, nil
+}
+
+func rekeyReport(store Store, file ReportFile, target Investigator, keys KeySource, signer Signer, signerName string) error {
+	if file.Legacy {
+		return errors.New("reports in the legacy layout cannot be passed on")
+	}
+	destination := file
+	destination.Recipient = target.Name
+	if _, err := store.Get(destination.DecryptionPayloadPath()); err == nil {
+		return nil
+	}
+	source := file
+	source.Recipient = signerName
+	data, err := store.Get(source.DecryptionPayloadPath())
+	if err != nil {
+		return errors.New("report was not sent to " + signerName)
+	}
+	var payload DecryptionPayload
+	err = json.Unmarshal(data, &payload)
+	if err != nil {
+		return err
+	}
+	hostKey, err := HostPublicKey(store, file.Hostname)
+	if err != nil {
+		return err
+	}
+	err = payload.VerifyReport(store, hostKey, file.ID, file.Hostname, signerName, payload.ReportHash)
+	if err != nil {
+		return err
+	}
+	decrypter, err := keys.Decrypter(payload.KeyID)
+	if err != nil {
+		return err
+	}
+	dataKey, err := decrypter.UnwrapKey(payload)
+	if err != nil {
+		return err
+	}
+	rewrapped, err := RewrapPayload(payload, signerName, dataKey, file.ID, file.Hostname, target, signer, signerName)
+	if err != nil {
+		return err
+	}
+	data, err = json.Marshal(rewrapped)
+	if err != nil {
+		return err
+	}
+	return store.Put(destination.DecryptionPayloadPath(), bytes.NewReader(data))
+}
@@ -0,0 +1,260 @@
+package engine_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func putTestInvestigator(t *testing.T, store engine.Store, name string) (engine.Investigator, *engine.Keyring) {
+	investigator, keyPEM, err := engine.NewInvestigator(name, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyring, err := engine.ParseKeyring(keyPEM, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := investigator.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("investigators/"+name+".json", bytes.NewReader(data)); err != nil {
+		t.Fatal(err)
+	}
+	return investigator, keyring
+}
+
+//
+// Revoke an investigator.  Tests that revoke must forget the revocations
+// this process remembers when they finish.
+//
+func revoke(t *testing.T, store engine.Store, name string, revoker string, keyring *engine.Keyring) {
+	revocation := engine.NewRevocation(name, "key lost")
+	assert.Nil(t, revocation.Sign(keyring.Current(), revoker))
+	assert.Nil(t, revocation.Upload(store))
+}
+
+func TestRevokedInvestigatorsAreNotTrusted(t *testing.T) {
+	assert := assert.New(t)
+	defer engine.ForgetRevocations()
+	store := engine.NewMemoryStore()
+	putTestInvestigator(t, store, "al")
+	putTestInvestigator(t, store, "alice")
+	_, bobKeys := putTestInvestigator(t, store, "bob")
+	_, malloryKeys, err := engine.NewInvestigator("mallory", "password")
+	assert.Nil(err)
+	mallory, err := engine.ParseKeyring(malloryKeys, "password")
+	assert.Nil(err)
+
+	// revocations by unknown investigators, or altered afterwards, are ignored
+	revoke(t, store, "al", "mallory", mallory)
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.Nil(err)
+	revocation := engine.NewRevocation("al", "key lost")
+	assert.Nil(revocation.Sign(bobKeys.Current(), "bob"))
+	revocation.Reason = "no reason"
+	assert.Nil(revocation.Upload(store))
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.Nil(err)
+
+	revoke(t, store, "al", "bob", bobKeys)
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.NotNil(err)
+	_, err = engine.InvestigatorByName(store, "alice")
+	assert.Nil(err, "revocations match names exactly")
+	names, err := engine.LoadInvestigatorNames(store)
+	assert.Nil(err)
+	assert.Equal([]string{"alice", "bob"}, names)
+	assert.Len(engine.LoadRevocations(store), 1)
+}
+
+//
+// A store that counts how often each file is read.
+//
+type countingStore struct {
+	engine.Store
+	gets map[string]int
+}
+
+func (store *countingStore) Get(key string) ([]byte, error) {
+	store.gets[key]++
+	return store.Store.Get(key)
+}
+
+func TestInvestigatorsAreLoadedOncePerListing(t *testing.T) {
+	assert := assert.New(t)
+	store := &countingStore{Store: engine.NewMemoryStore(), gets: make(map[string]int)}
+	_, aliceKeys := putTestInvestigator(t, store, "alice")
+	_, bobKeys := putTestInvestigator(t, store, "bob")
+	for _, id := range []string{"aaaaaaaa", "bbbbbbbb", "cccccccc"} {
+		inv := engine.Investigation{
+			ID:       id,
+			TaskList: map[string][]string{"example": {}},
+			Issuer:   engine.Signature{Name: "alice"},
+		}
+		inv.Sign(namedSigner{aliceKeys.Current(), "alice"})
+		approve(&inv, bobKeys, "bob")
+		putInvestigationCopy(t, store, inv, "alice")
+	}
+
+	assert.Len(engine.CurrentInvestigations(store), 3)
+	for _, name := range []string{"alice", "bob"} {
+		assert.Equal(1, store.gets["investigators/"+name+".json"])
+		assert.Equal(1, store.gets[engine.RevocationPath(name)])
+	}
+}
+
+//
+// A store that cannot read one file.
+//
+type unreadableStore struct {
+	engine.Store
+	unreadable string
+}
+
+func (store *unreadableStore) Get(key string) ([]byte, error) {
+	if key == store.unreadable {
+		return nil, errors.New("connection reset by peer")
+	}
+	return store.Store.Get(key)
+}
+
+func TestRevocationsAreNeverForgotten(t *testing.T) {
+	assert := assert.New(t)
+	defer engine.ForgetRevocations()
+	os.Setenv("DEXTER_POLL_INTERVAL_SECONDS", "1")
+	defer os.Unsetenv("DEXTER_POLL_INTERVAL_SECONDS")
+	dir, err := ioutil.TempDir("", "dexter-state")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	statePath := dir + "/daemon-state.json"
+
+	store := &unreadableStore{Store: engine.NewMemoryStore()}
+	putTestInvestigator(t, store, "al")
+	_, bobKeys := putTestInvestigator(t, store, "bob")
+
+	// a revocation that cannot be read is not taken as no revocation
+	store.unreadable = engine.RevocationPath("al")
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.NotNil(err)
+	store.unreadable = ""
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.Nil(err)
+
+	revoke(t, store, "al", "bob", bobKeys)
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.NotNil(err)
+	poller, err := engine.NewStorePoller(store, nil, statePath)
+	assert.Nil(err)
+	poller.Poll()
+	for i := 0; i < 30; i++ {
+		if _, err := os.Stat(statePath); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+
+	// deleting the record, or making it unreadable, does not restore
+	// trust, even after a restart
+	assert.Nil(store.Delete(engine.RevocationPath("al")))
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.NotNil(err)
+	engine.ForgetRevocations()
+	_, err = engine.NewStorePoller(store, nil, statePath)
+	assert.Nil(err)
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.NotNil(err)
+	store.unreadable = engine.RevocationPath("al")
+	_, err = engine.InvestigatorByName(store, "al")
+	assert.NotNil(err)
+	assert.Len(engine.LoadRevocations(store), 1)
+	_, err = engine.InvestigatorByName(store, "bob")
+	assert.Nil(err)
+}
+
+func TestRevokedInvestigatorsReportsAreRekeyedAndArchived(t *testing.T) {
+	assert := assert.New(t)
+	defer engine.ForgetRevocations()
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+	store := engine.NewMemoryStore()
+	data, err := json.Marshal(identity.Host())
+	assert.Nil(err)
+	assert.Nil(store.Put("hosts/"+identity.Name+".json", bytes.NewReader(data)))
+	hostKey, err := engine.HostPublicKey(store, identity.Name)
+	assert.Nil(err)
+
+	investigators := make(map[string]engine.Investigator)
+	keyrings := make(map[string]*engine.Keyring)
+	for _, name := range []string{"al", "alice", "bob", "carol"} {
+		investigators[name], keyrings[name] = putTestInvestigator(t, store, name)
+	}
+	dataKey := []byte("0123456789abcdef0123456789abcdef")
+	ciphertext := []byte("encrypted report")
+	reportHash := sha256.Sum256(ciphertext)
+	sendReport := func(id string, recipients ...string) {
+		file := engine.ReportFile{ID: id, Hostname: identity.Name}
+		assert.Nil(store.Put(file.EncryptedReportPath(), bytes.NewReader(ciphertext)))
+		assert.Nil(store.Put(file.ExecutionRecordPath(), bytes.NewReader([]byte("{}"))))
+		for _, recipient := range recipients {
+			payload, err := investigators[recipient].WrapKey(dataKey)
+			assert.Nil(err)
+			payload.Format = engine.StreamFormat
+			payload.SignReport(identity, id, recipient, reportHash[:])
+			data, err := json.Marshal(payload)
+			assert.Nil(err)
+			file.Recipient = recipient
+			assert.Nil(store.Put(file.DecryptionPayloadPath(), bytes.NewReader(data)))
+		}
+	}
+	sendReport("aaaaaaaa", "al", "alice", "bob")
+	sendReport("bbbbbbbb", "al")
+	sendReport("cccccccc", "alice")
+
+	revoke(t, store, "al", "bob", keyrings["bob"])
+	rekeyed, skipped, err := engine.RekeyReportsFor(store, "al", investigators["carol"], keyrings["bob"], keyrings["bob"].Current(), "bob")
+	assert.Nil(err)
+	assert.Len(rekeyed, 1, "only reports bob received can be passed on")
+	assert.Len(skipped, 1)
+
+	file := engine.ReportFile{ID: "aaaaaaaa", Hostname: identity.Name, Recipient: "carol"}
+	data, err = store.Get(file.DecryptionPayloadPath())
+	assert.Nil(err)
+	var payload engine.DecryptionPayload
+	assert.Nil(json.Unmarshal(data, &payload))
+	assert.Nil(payload.VerifyReport(store, hostKey, file.ID, file.Hostname, "carol", reportHash[:]))
+	assert.NotNil(payload.VerifyReport(store, hostKey, file.ID, file.Hostname, "alice", reportHash[:]))
+	unwrapped := payload.GetEncryptionKey(keyrings["carol"])
+	assert.Equal(dataKey, unwrapped)
+
+	archived, err := engine.ArchiveReportsFor(store, "al")
+	assert.Nil(err)
+	assert.Len(archived, 2)
+	files, err := engine.ReportFiles(store, false)
+	assert.Nil(err)
+	recipients := []string{}
+	for _, file := range files {
+		recipients = append(recipients, file.ID+" "+file.Recipient)
+	}
+	assert.ElementsMatch([]string{"aaaaaaaa alice", "aaaaaaaa bob", "aaaaaaaa carol", "cccccccc alice"}, recipients)
+	_, err = store.Get("reports/aaaaaaaa/" + identity.Name + "/report.zip.enc")
+	assert.Nil(err, "reports other recipients can read stay in place")
+	_, err = store.Get("reports/_bbbbbbbb/" + identity.Name + "/report.zip.enc")
+	assert.Nil(err, "reports only the revoked investigator could read are archived")
+	_, err = store.Get("reports/_bbbbbbbb/" + identity.Name + "/execution.json")
+	assert.Nil(err)
+
+	// keys passed on stop being trusted once the investigator who passed
+	// them on is revoked
+	revoke(t, store, "bob", "alice", keyrings["alice"])
+	assert.NotNil(payload.VerifyReport(store, hostKey, file.ID, file.Hostname, "carol", reportHash[:]))
+}
@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"io"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// S3 accepts at most this many parts in a multipart upload.
+//
+const s3MaxParts = 10000
+
+//
+// Return the size of each part used to transfer large files.
+//
+func (store *S3Store) PartSize() int64 {
+	return store.partSize
+}
+
+//
+// Return the size of a file in the bucket.
+//
+func (store *S3Store) Size(key string) (int64, error) {
+	var size int64
+	err := withRetries("head "+key, func() error {
+		result, err := store.svc.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(store.bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			return err
+		}
+		size = aws.Int64Value(result.ContentLength)
+		return nil
+	})
+	return size, err
+}
+
+//
+// Open part of a file in the bucket for streaming.  The caller must close it.
+//
+func (store *S3Store) OpenRange(key string, offset, length int64) (io.ReadCloser, error) {
+	result, err := store.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+		Range:  aws.String("bytes=" + strconv.FormatInt(offset, 10) + "-" + strconv.FormatInt(offset+length-1, 10)),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+//
+// Upload a large file in parts.  If an earlier upload of the same key was
+// interrupted, any parts it finished that match this file are kept rather
+// than sent again.  Each part is retried on its own, and an upload that
+// still fails is aborted so its parts are not kept in the bucket.
+//
+func (store *S3Store) putMultipart(key string, data io.ReaderAt, size int64) error {
+	partSize := store.partSize
+	if size > partSize*s3MaxParts {
+		partSize = (size + s3MaxParts - 1) / s3MaxParts
+	}
+	uploadID, uploaded, err := store.resumableUpload(key)
+	if err != nil {
+		return err
+	}
+	if uploadID == "" {
+		result, err := store.svc.CreateMultipartUpload(&s3.CreateMultipartUploadInput{
+			ACL:                  aws.String(s3.ObjectCannedACLBucketOwnerFullControl),
+			Bucket:               aws.String(store.bucket),
+			Key:                  aws.String(key),
+			ServerSideEncryption: aws.String("AES256"),
+		})
+		if err != nil {
+			return err
+		}
+		uploadID = aws.StringValue(result.UploadId)
+	} else {
+		log.WithFields(log.Fields{
+			"at":     "engine.putMultipart",
+			"key":    key,
+			"parts":  len(uploaded),
+			"upload": uploadID,
+		}).Info("resuming interrupted upload")
+	}
+
+	partCount := (size + partSize - 1) / partSize
+	parts := make(chan int64)
+	completed := make([]*s3.CompletedPart, partCount)
+	errs := make(chan error, transferConcurrency)
+	var wait sync.WaitGroup
+	for i := 0; i < transferConcurrency; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for number := range parts {
+				offset := (number - 1) * partSize
+				length := partSize
+				if offset+length > size {
+					length = size - offset
+				}
+				part, err := store.uploadPart(key, uploadID, number, io.NewSectionReader(data, offset, length), uploaded[number])
+				if err != nil {
+					errs <- err
+					return
+				}
+				completed[number-1] = part
+			}
+		}()
+	}
+	var failure error
+	for number := int64(1); number <= partCount && failure == nil; number++ {
+		select {
+		case parts <- number:
+		case failure = <-errs:
+		}
+	}
+	close(parts)
+	wait.Wait()
+	close(errs)
+	if failure == nil {
+		failure = <-errs
+	}
+	if failure == nil {
+		failure = withRetries("complete "+key, func() error {
+			_, err := store.svc.CompleteMultipartUpload(&s3.CompleteMultipartUploadInput{
+				Bucket:          aws.String(store.bucket),
+				Key:             aws.String(key),
+				UploadId:        aws.String(uploadID),
+				MultipartUpload: &s3.CompletedMultipartUpload{Parts: completed},
+			})
+			return err
+		})
+	}
+	if failure != nil {
+		store.abortMultipart(key, uploadID)
+	}
+	return failure
+}
+
+//
+// Abort an incomplete upload, discarding the parts it holds.  A failure is
+// logged, and leaves the upload for the bucket's lifecycle rules.
+//
+func (store *S3Store) abortMultipart(key, uploadID string) {
+	err := withRetries("abort "+key, func() error {
+		_, err := store.svc.AbortMultipartUpload(&s3.AbortMultipartUploadInput{
+			Bucket:   aws.String(store.bucket),
+			Key:      aws.String(key),
+			UploadId: aws.String(uploadID),
+		})
+		return err
+	})
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":     "engine.putMultipart",
+			"error":  err.Error(),
+			"key":    key,
+			"upload": uploadID,
+		}).Error("unable to abort failed upload")
+	}
+}
+
+//
+// Upload one part, unless an identical part was already uploaded.
+//
+func (store *S3Store) uploadPart(key, uploadID string, number int64, part *io.SectionReader, existing *s3.Part) (*s3.CompletedPart, error) {
+	hash := md5.New()
+	if _, err := io.Copy(hash, part); err != nil {
+		return nil, err
+	}
+	sum := hash.Sum(nil)
+	etag := "\"" + hex.EncodeToString(sum) + "\""
+	if existing != nil && aws.StringValue(existing.ETag) == etag && aws.Int64Value(existing.Size) == part.Size() {
+		return &s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(number)}, nil
+	}
+	err := withRetries("upload part "+strconv.FormatInt(number, 10)+" of "+key, func() error {
+		if _, err := part.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := store.svc.UploadPart(&s3.UploadPartInput{
+			Body:          part,
+			Bucket:        aws.String(store.bucket),
+			ContentLength: aws.Int64(part.Size()),
+			ContentMD5:    aws.String(base64.StdEncoding.EncodeToString(sum)),
+			Key:           aws.String(key),
+			PartNumber:    aws.Int64(number),
+			UploadId:      aws.String(uploadID),
+		})
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &s3.CompletedPart{ETag: aws.String(etag), PartNumber: aws.Int64(number)}, nil
+}
+
+//
+// Find the most recent incomplete upload of a key, returning its ID and the
+// parts it already holds, or an empty ID if there is none.
+//
+func (store *S3Store) resumableUpload(key string) (string, map[int64]*s3.Part, error) {
+	candidates := make([]*s3.MultipartUpload, 0)
+	listInput := &s3.ListMultipartUploadsInput{
+		Bucket: aws.String(store.bucket),
+		Prefix: aws.String(key),
+	}
+	for {
+		uploads, err := store.svc.ListMultipartUploads(listInput)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, upload := range uploads.Uploads {
+			if aws.StringValue(upload.Key) == key {
+				candidates = append(candidates, upload)
+			}
+		}
+		if !aws.BoolValue(uploads.IsTruncated) {
+			break
+		}
+		listInput.KeyMarker = uploads.NextKeyMarker
+		listInput.UploadIdMarker = uploads.NextUploadIdMarker
+	}
+	if len(candidates) == 0 {
+		return "", nil, nil
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		return aws.TimeValue(candidates[i].Initiated).After(aws.TimeValue(candidates[j].Initiated))
+	})
+	uploadID := aws.StringValue(candidates[0].UploadId)
+
+	uploaded := make(map[int64]*s3.Part)
+	input := &s3.ListPartsInput{
+		Bucket:   aws.String(store.bucket),
+		Key:      aws.String(key),
+		UploadId: aws.String(uploadID),
+	}
+	for {
+		result, err := store.svc.ListParts(input)
+		if err != nil {
+			return "", nil, err
+		}
+		for _, part := range result.Parts {
+			uploaded[aws.Int64Value(part.PartNumber)] = part
+		}
+		if !aws.BoolValue(result.IsTruncated) {
+			break
+		}
+		input.PartNumberMarker = result.NextPartNumberMarker
+	}
+	return uploadID, uploaded, nil
+}
@@ -0,0 +1,349 @@
+package engine_test
+
+import (
+	"bytes"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+const partSize = 5 * 1024 * 1024
+
+//
+// An in-memory S3 client that supports multipart uploads and ranged reads,
+// fails the first attempt at selected requests, and fails others for good.
+// Incomplete uploads are listed one per page.
+//
+type multipartS3Client struct {
+	s3iface.S3API
+	lock      sync.Mutex
+	objects   map[string][]byte
+	uploads   map[string]map[int64][]byte
+	uploadKey map[string]string
+	failOnce  map[string]bool
+	failAll   map[string]bool
+	requests  []string
+}
+
+func newMultipartS3Client() *multipartS3Client {
+	return &multipartS3Client{
+		objects:   make(map[string][]byte),
+		uploads:   make(map[string]map[int64][]byte),
+		uploadKey: make(map[string]string),
+		failOnce:  make(map[string]bool),
+		failAll:   make(map[string]bool),
+	}
+}
+
+func (client *multipartS3Client) request(name string) error {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.requests = append(client.requests, name)
+	if client.failOnce[name] {
+		delete(client.failOnce, name)
+		return awserr.New("RequestError", "send request failed", errors.New("connection reset by peer"))
+	}
+	if client.failAll[name] {
+		return awserr.NewRequestFailure(awserr.New("AccessDenied", "access denied", nil), 403, "request")
+	}
+	return nil
+}
+
+func (client *multipartS3Client) count(prefix string) int {
+	count := 0
+	for _, name := range client.requests {
+		if strings.HasPrefix(name, prefix) {
+			count += 1
+		}
+	}
+	return count
+}
+
+func etag(data []byte) *string {
+	sum := md5.Sum(data)
+	return aws.String("\"" + hex.EncodeToString(sum[:]) + "\"")
+}
+
+func (client *multipartS3Client) PutObject(input *s3.PutObjectInput) (*s3.PutObjectOutput, error) {
+	if err := client.request("put"); err != nil {
+		return nil, err
+	}
+	data, _ := ioutil.ReadAll(input.Body)
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.objects[*input.Key] = data
+	return &s3.PutObjectOutput{}, nil
+}
+
+func (client *multipartS3Client) CreateMultipartUpload(input *s3.CreateMultipartUploadInput) (*s3.CreateMultipartUploadOutput, error) {
+	client.request("create")
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	id := "upload-" + strconv.Itoa(len(client.uploads))
+	client.uploads[id] = make(map[int64][]byte)
+	client.uploadKey[id] = *input.Key
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (client *multipartS3Client) UploadPart(input *s3.UploadPartInput) (*s3.UploadPartOutput, error) {
+	if err := client.request("part-" + strconv.FormatInt(*input.PartNumber, 10)); err != nil {
+		return nil, err
+	}
+	data, _ := ioutil.ReadAll(input.Body)
+	sum := md5.Sum(data)
+	if *input.ContentMD5 != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errors.New("bad digest")
+	}
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	client.uploads[*input.UploadId][*input.PartNumber] = data
+	return &s3.UploadPartOutput{ETag: etag(data)}, nil
+}
+
+func (client *multipartS3Client) ListMultipartUploads(input *s3.ListMultipartUploadsInput) (*s3.ListMultipartUploadsOutput, error) {
+	client.request("list")
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	ids := []string{}
+	for id, key := range client.uploadKey {
+		if strings.HasPrefix(key, *input.Prefix) && id > aws.StringValue(input.UploadIdMarker) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Strings(ids)
+	output := &s3.ListMultipartUploadsOutput{IsTruncated: aws.Bool(len(ids) > 1)}
+	if len(ids) > 0 {
+		output.Uploads = []*s3.MultipartUpload{{
+			Key:       aws.String(client.uploadKey[ids[0]]),
+			UploadId:  aws.String(ids[0]),
+			Initiated: aws.Time(time.Now()),
+		}}
+		output.NextKeyMarker = aws.String(client.uploadKey[ids[0]])
+		output.NextUploadIdMarker = aws.String(ids[0])
+	}
+	return output, nil
+}
+
+func (client *multipartS3Client) AbortMultipartUpload(input *s3.AbortMultipartUploadInput) (*s3.AbortMultipartUploadOutput, error) {
+	client.request("abort")
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	delete(client.uploads, *input.UploadId)
+	delete(client.uploadKey, *input.UploadId)
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+func (client *multipartS3Client) ListParts(input *s3.ListPartsInput) (*s3.ListPartsOutput, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	output := &s3.ListPartsOutput{IsTruncated: aws.Bool(false)}
+	for number, data := range client.uploads[*input.UploadId] {
+		output.Parts = append(output.Parts, &s3.Part{
+			ETag:       etag(data),
+			PartNumber: aws.Int64(number),
+			Size:       aws.Int64(int64(len(data))),
+		})
+	}
+	return output, nil
+}
+
+func (client *multipartS3Client) CompleteMultipartUpload(input *s3.CompleteMultipartUploadInput) (*s3.CompleteMultipartUploadOutput, error) {
+	client.request("complete")
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	object := []byte{}
+	for i, part := range input.MultipartUpload.Parts {
+		data := client.uploads[*input.UploadId][*part.PartNumber]
+		if *part.PartNumber != int64(i+1) || *part.ETag != *etag(data) {
+			return nil, errors.New("invalid part")
+		}
+		object = append(object, data...)
+	}
+	client.objects[*input.Key] = object
+	delete(client.uploads, *input.UploadId)
+	delete(client.uploadKey, *input.UploadId)
+	return &s3.CompleteMultipartUploadOutput{}, nil
+}
+
+func (client *multipartS3Client) HeadObject(input *s3.HeadObjectInput) (*s3.HeadObjectOutput, error) {
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	data, ok := client.objects[*input.Key]
+	if !ok {
+		return nil, awserr.NewRequestFailure(awserr.New("NotFound", "not found", nil), 404, "request")
+	}
+	return &s3.HeadObjectOutput{ContentLength: aws.Int64(int64(len(data)))}, nil
+}
+
+func (client *multipartS3Client) GetObject(input *s3.GetObjectInput) (*s3.GetObjectOutput, error) {
+	if err := client.request("get " + aws.StringValue(input.Range)); err != nil {
+		return nil, err
+	}
+	client.lock.Lock()
+	defer client.lock.Unlock()
+	data := client.objects[*input.Key]
+	if input.Range != nil {
+		bounds := strings.Split(strings.TrimPrefix(*input.Range, "bytes="), "-")
+		start, _ := strconv.Atoi(bounds[0])
+		end, _ := strconv.Atoi(bounds[1])
+		data = data[start : end+1]
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func testS3Store(t *testing.T, client *multipartS3Client) *engine.S3Store {
+	os.Setenv("DEXTER_S3_PART_SIZE_MB", "5")
+	defer os.Unsetenv("DEXTER_S3_PART_SIZE_MB")
+	return engine.NewS3StoreWithClient("bucket", client)
+}
+
+func TestS3StoreRetriesFailedParts(t *testing.T) {
+	assert := assert.New(t)
+	client := newMultipartS3Client()
+	client.failOnce["part-2"] = true
+	store := testS3Store(t, client)
+
+	data := randomBytes(t, 2*partSize+1234)
+	assert.Nil(store.Put("reports/abcd1234/host/report.zip.enc", bytes.NewReader(data)))
+	assert.True(bytes.Equal(data, client.objects["reports/abcd1234/host/report.zip.enc"]))
+	assert.Equal(1, client.count("part-1"))
+	assert.Equal(2, client.count("part-2"))
+	assert.Equal(1, client.count("part-3"))
+	assert.Equal(0, client.count("put"))
+
+	// small files are still uploaded in one request, with retries
+	client.failOnce["put"] = true
+	assert.Nil(store.Put("status/abcd1234/host.json", bytes.NewReader([]byte("small"))))
+	assert.Equal([]byte("small"), client.objects["status/abcd1234/host.json"])
+	assert.Equal(2, client.count("put"))
+}
+
+func TestS3StoreResumesInterruptedUploads(t *testing.T) {
+	assert := assert.New(t)
+	client := newMultipartS3Client()
+	store := testS3Store(t, client)
+	data := randomBytes(t, 2*partSize+1234)
+
+	// an earlier upload finished the first part, and was interrupted
+	// partway through the second
+	client.uploads["upload-old"] = map[int64][]byte{
+		1: data[:partSize],
+		2: data[partSize : partSize+100],
+	}
+	client.uploadKey["upload-old"] = "reports/abcd1234/host/report.zip.enc"
+	client.uploadKey["upload-a"] = "reports/abcd1234/host/report.zip.enc.partial"
+	client.uploads["upload-a"] = map[int64][]byte{}
+
+	assert.Nil(store.Put("reports/abcd1234/host/report.zip.enc", bytes.NewReader(data)))
+	assert.True(bytes.Equal(data, client.objects["reports/abcd1234/host/report.zip.enc"]))
+	assert.Equal(0, client.count("create"))
+	assert.Equal(0, client.count("part-1"))
+	assert.Equal(1, client.count("part-2"))
+	assert.Equal(1, client.count("part-3"))
+	assert.Equal(2, client.count("list"))
+}
+
+func TestS3StoreAbortsFailedUploads(t *testing.T) {
+	assert := assert.New(t)
+	client := newMultipartS3Client()
+	store := testS3Store(t, client)
+	data := randomBytes(t, 2*partSize+1234)
+
+	// a part that can never be uploaded fails the whole upload, and its
+	// parts are discarded rather than left in the bucket
+	client.failAll["part-3"] = true
+	assert.NotNil(store.Put("reports/abcd1234/host/report.zip.enc", bytes.NewReader(data)))
+	assert.Equal(1, client.count("abort"))
+	assert.Empty(client.uploadKey)
+	_, ok := client.objects["reports/abcd1234/host/report.zip.enc"]
+	assert.False(ok)
+}
+
+//
+// A report file that fails to read partway through.
+//
+type unreadableFile struct {
+	*bytes.Reader
+	failAt int64
+}
+
+func (file unreadableFile) ReadAt(p []byte, offset int64) (int, error) {
+	if offset+int64(len(p)) > file.failAt {
+		return 0, errors.New("input/output error")
+	}
+	return file.Reader.ReadAt(p, offset)
+}
+
+func TestS3StoreAbortsUploadsOfUnreadableFiles(t *testing.T) {
+	assert := assert.New(t)
+	client := newMultipartS3Client()
+	store := testS3Store(t, client)
+	data := randomBytes(t, 2*partSize+1234)
+
+	// local errors are not retried, and still discard the upload
+	file := unreadableFile{Reader: bytes.NewReader(data), failAt: 2 * partSize}
+	assert.NotNil(store.Put("reports/abcd1234/host/report.zip.enc", file))
+	assert.Equal(0, client.count("part-3"))
+	assert.Equal(1, client.count("abort"))
+	assert.Empty(client.uploadKey)
+}
+
+func TestDownloadUsesParallelRanges(t *testing.T) {
+	assert := assert.New(t)
+	client := newMultipartS3Client()
+	store := testS3Store(t, client)
+	data := randomBytes(t, 2*partSize+1234)
+	client.objects["reports/abcd1234/host/report.zip.enc"] = data
+	client.failOnce["get bytes="+strconv.Itoa(partSize)+"-"+strconv.Itoa(2*partSize-1)] = true
+
+	out, err := ioutil.TempFile("", "dexter-download")
+	assert.Nil(err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	size, err := engine.Download(store, "reports/abcd1234/host/report.zip.enc", out)
+	assert.Nil(err)
+	assert.Equal(int64(len(data)), size)
+	downloaded, err := ioutil.ReadFile(out.Name())
+	assert.Nil(err)
+	assert.True(bytes.Equal(data, downloaded))
+	assert.Equal(4, client.count("get bytes="))
+
+	_, err = engine.Download(store, "reports/missing", out)
+	assert.NotNil(err)
+}
+
+func TestDownloadFromStoresWithoutRanges(t *testing.T) {
+	assert := assert.New(t)
+	store := engine.NewMemoryStore()
+	data := randomBytes(t, 100000)
+	assert.Nil(store.Put("reports/abcd1234/host/report.zip.enc", bytes.NewReader(data)))
+
+	out, err := ioutil.TempFile("", "dexter-download")
+	assert.Nil(err)
+	defer os.Remove(out.Name())
+	defer out.Close()
+
+	size, err := engine.Download(store, "reports/abcd1234/host/report.zip.enc", out)
+	assert.Nil(err)
+	assert.Equal(int64(len(data)), size)
+	downloaded, err := ioutil.ReadFile(out.Name())
+	assert.Nil(err)
+	assert.True(bytes.Equal(data, downloaded))
+}
@@ -0,0 +1,192 @@
+package engine
+
+import (
+	"bytes"
+	"io"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/coinbase/dexter/engine/helpers"
+)
+
+//
+// A store backed by an S3 bucket.
+//
+type S3Store struct {
+	bucket   string
+	svc      s3iface.S3API
+	partSize int64
+}
+
+//
+// The number of keys requested in each page of a bucket listing.
+//
+const s3ListPageSize = 1000
+
+//
+// Create a new store for the named S3 bucket.
+//
+func NewS3Store(bucket string) *S3Store {
+	return NewS3StoreWithClient(bucket, s3.New(session.New()))
+}
+
+//
+// Create a new store for the named S3 bucket using an existing S3 client.
+//
+func NewS3StoreWithClient(bucket string, svc s3iface.S3API) *S3Store {
+	return &S3Store{
+		bucket:   bucket,
+		svc:      svc,
+		partSize: int64(helpers.S3PartSizeMB()) * 1024 * 1024,
+	}
+}
+
+//
+// Download a file from the bucket.
+//
+func (store *S3Store) Get(key string) ([]byte, error) {
+	result, err := store.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return []byte{}, err
+	}
+	defer result.Body.Close()
+	buf := new(bytes.Buffer)
+	_, err = buf.ReadFrom(result.Body)
+	return buf.Bytes(), err
+}
+
+//
+// Open a file in the bucket for streaming.  The caller must close it.
+//
+func (store *S3Store) Open(key string) (io.ReadCloser, error) {
+	result, err := store.svc.GetObject(&s3.GetObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.Body, nil
+}
+
+//
+// Iterate over the keys in the bucket that start with a prefix.  Pages
+// of keys are requested from S3 as the iterator reaches the end of the
+// previous page.
+//
+func (store *S3Store) Iterate(prefix string) KeyIterator {
+	return &s3KeyIterator{
+		store:  store,
+		prefix: prefix,
+	}
+}
+
+type s3KeyIterator struct {
+	store  *S3Store
+	prefix string
+	token  *string
+	page   []string
+	key    string
+	done   bool
+	err    error
+}
+
+func (iterator *s3KeyIterator) Next() bool {
+	for len(iterator.page) == 0 {
+		if iterator.done || iterator.err != nil {
+			return false
+		}
+		iterator.fetchPage()
+	}
+	iterator.key = iterator.page[0]
+	iterator.page = iterator.page[1:]
+	return true
+}
+
+func (iterator *s3KeyIterator) Key() string {
+	return iterator.key
+}
+
+func (iterator *s3KeyIterator) Err() error {
+	return iterator.err
+}
+
+func (iterator *s3KeyIterator) fetchPage() {
+	result, err := iterator.store.svc.ListObjectsV2(&s3.ListObjectsV2Input{
+		Bucket:            aws.String(iterator.store.bucket),
+		ContinuationToken: iterator.token,
+		MaxKeys:           aws.Int64(s3ListPageSize),
+		Prefix:            aws.String(iterator.prefix),
+	})
+	if err != nil {
+		iterator.err = err
+		return
+	}
+	for _, object := range result.Contents {
+		iterator.page = append(iterator.page, *object.Key)
+	}
+	if aws.BoolValue(result.IsTruncated) && result.NextContinuationToken != nil {
+		iterator.token = result.NextContinuationToken
+	} else {
+		iterator.done = true
+	}
+}
+
+//
+// Upload data to a file in the bucket.  Files larger than a single part,
+// such as reports read from disk, are uploaded in parts that can be retried
+// and resumed on their own.
+//
+func (store *S3Store) Put(key string, data io.ReadSeeker) error {
+	size, err := data.Seek(0, io.SeekEnd)
+	if err != nil {
+		return err
+	}
+	if readerAt, ok := data.(io.ReaderAt); ok && size > store.partSize {
+		return store.putMultipart(key, readerAt, size)
+	}
+	return withRetries("put "+key, func() error {
+		if _, err := data.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		_, err := store.svc.PutObject(&s3.PutObjectInput{
+			ACL:                  aws.String(s3.ObjectCannedACLBucketOwnerFullControl),
+			Body:                 data,
+			Bucket:               aws.String(store.bucket),
+			Key:                  aws.String(key),
+			ServerSideEncryption: aws.String("AES256"),
+		})
+		return err
+	})
+}
+
+//
+// Delete a file from the bucket.
+//
+func (store *S3Store) Delete(key string) error {
+	_, err := store.svc.DeleteObject(&s3.DeleteObjectInput{
+		Bucket: aws.String(store.bucket),
+		Key:    aws.String(key),
+	})
+	return err
+}
+
+//
+// Move a file in the bucket by copying it and deleting the original.
+//
+func (store *S3Store) Move(oldKey, newKey string) error {
+	_, err := store.svc.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(store.bucket),
+		CopySource: aws.String(store.bucket + "/" + oldKey),
+		Key:        aws.String(newKey),
+	})
+	if err != nil {
+		return err
+	}
+	return store.Delete(oldKey)
+}
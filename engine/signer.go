@@ -0,0 +1,90 @@
+package engine
+
+import (
+	"errors"
+
+	"github.com/coinbase/dexter/engine/helpers"
+)
+
+//
+// A Signer signs investigations with the local investigator's current key.
+//
+type Signer interface {
+	Sign(name string, digest []byte) (Signature, error)
+}
+
+//
+// A Decrypter recovers the data encryption key of a report from a
+// decryption payload wrapped for one of the local investigator's keys.
+//
+type Decrypter interface {
+	UnwrapKey(payload DecryptionPayload) ([]byte, error)
+}
+
+//
+// A KeySource holds the local investigator's private keys, which may live
+// in the local key file, in a running dexter agent, or in a PKCS#11 token.
+// Keys never have to leave the source to be used.
+//
+type KeySource interface {
+	Signer() (Signer, error)
+	Decrypter(keyID string) (Decrypter, error)
+}
+
+//
+// Return the local investigator's signing key from the key file.
+//
+func (keyring *Keyring) Signer() (Signer, error) {
+	if keyring.signingKeyID == nil {
+		return keyring.Current(), nil
+	}
+	key, ok := keyring.Key(*keyring.signingKeyID)
+	if !ok {
+		return nil, errors.New("no local key with ID \"" + *keyring.signingKeyID + "\"")
+	}
+	return key, nil
+}
+
+//
+// Return a key from the key file, if it is there.
+//
+func (keyring *Keyring) Decrypter(keyID string) (Decrypter, error) {
+	key, ok := keyring.Key(keyID)
+	if !ok {
+		return nil, errors.New("no local key with ID \"" + keyID + "\"")
+	}
+	return key, nil
+}
+
+//
+// Return the source of the local investigator's keys.  A dexter agent is
+// used if DEXTER_AGENT_SOCK is set, otherwise the keys are opened directly.
+//
+func LocalKeySource(passwordFunc, pinFunc func() string) (KeySource, error) {
+	if socket := helpers.AgentSocket(); socket != "" {
+		return NewAgentClient(socket), nil
+	}
+	return DirectKeySource(passwordFunc, pinFunc)
+}
+
+//
+// Open the local investigator's keys without going through an agent.  Keys
+// are held in the PKCS#11 token configured with DEXTER_PKCS11_MODULE, or in
+// the local key file if no token is configured.  The password function is
+// used to unlock the key file, and the PIN function to log in to a token.
+// Either way, investigations are signed with the current key of the local
+// investigator file, which only changes once a new key is published.
+//
+func DirectKeySource(passwordFunc, pinFunc func() string) (KeySource, error) {
+	currentKeyID := LoadLocalInvestigator().CurrentKeyID()
+	if module := helpers.PKCS11Module(); module != "" {
+		token, err := OpenPKCS11Token(module, helpers.PKCS11Token(), pinFunc, currentKeyID)
+		if err != nil {
+			return nil, err
+		}
+		return token, nil
+	}
+	keyring := LoadLocalKeyring(passwordFunc)
+	keyring.UseSigningKey(currentKeyID)
+	return keyring, nil
+}
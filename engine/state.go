@@ -0,0 +1,82 @@
+package engine
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+//
+// The daemon state records which investigations this host has finished
+// with and which are still waiting for approvals, so a restarted daemon
+// neither replays old investigations nor misses ones published while it
+// was down.
+//
+type daemonState struct {
+	Completed []string
+	Pending   map[string]time.Time
+	LastPoll  time.Time
+
+	// The newest investigator registry version accepted, so an older
+	// registry is never trusted again after a restart.
+	RegistryVersion int `json:",omitempty"`
+
+	// Every revocation verified, so an investigator stays revoked even if
+	// their revocation record is later deleted from the store.
+	Revocations []Revocation `json:",omitempty"`
+}
+
+//
+// Read the daemon state from a file.  The boolean result is false if the
+// file does not exist yet.
+//
+func loadDaemonState(path string) (daemonState, bool, error) {
+	state := daemonState{Pending: make(map[string]time.Time)}
+	data, err := ioutil.ReadFile(filepath.FromSlash(path))
+	if os.IsNotExist(err) {
+		return state, false, nil
+	} else if err != nil {
+		return state, false, err
+	}
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return state, false, err
+	}
+	if state.Pending == nil {
+		state.Pending = make(map[string]time.Time)
+	}
+	return state, true, nil
+}
+
+//
+// Write the daemon state to a file, replacing it atomically so a crash
+// never leaves a partially written state behind.
+//
+func (state daemonState) save(path string) error {
+	sort.Strings(state.Completed)
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	path = filepath.FromSlash(path)
+	err = os.MkdirAll(filepath.Dir(path), 0700)
+	if err != nil {
+		return err
+	}
+	temp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".")
+	if err != nil {
+		return err
+	}
+	_, err = temp.Write(data)
+	if closeErr := temp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
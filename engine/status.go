@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// The states a host can report for an investigation.
+//
+const (
+	StatusReceived          = "received"
+	StatusOutOfScope        = "out-of-scope"
+	StatusAwaitingConsensus = "awaiting-consensus"
+	StatusRejected          = "rejected"
+	StatusRunning           = "running"
+	StatusUploaded          = "uploaded"
+	StatusFailed            = "failed"
+)
+
+//
+// A status record is uploaded by a daemon each time its progress on an
+// investigation changes, so investigators can see which hosts received,
+// skipped, or failed an investigation.  Records are signed with the
+// host's identity key.
+//
+type StatusRecord struct {
+	InvestigationID string
+	Hostname        string
+	State           string
+	Detail          string
+	Time            time.Time
+	HostPublicKey   []byte
+	Signature       []byte
+	key             string
+}
+
+//
+// Return the path in the store holding a host's latest status for an investigation.
+//
+func statusPath(investigationID, hostname string) string {
+	return "status/" + investigationID + "/" + hostname + ".json"
+}
+
+func (record *StatusRecord) digest() []byte {
+	blob := make([]byte, 0)
+	blob = append(blob, []byte(record.InvestigationID)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(record.Hostname)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(record.State)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(record.Detail)...)
+	blob = append(blob, 0x00)
+	blob = append(blob, []byte(record.Time.UTC().Format(time.RFC3339Nano))...)
+	blob = append(blob, 0x00)
+	blob = append(blob, record.HostPublicKey...)
+	sum := sha256.Sum256(blob)
+	return sum[:]
+}
+
+//
+// Return true if the record was signed by the key it carries.  This only
+// proves the record has not been altered since a host signed it.
+//
+func (record *StatusRecord) SignatureValid() bool {
+	return VerifyHostSignature(record.HostPublicKey, record.digest(), record.Signature)
+}
+
+//
+// Check the record was signed by the enrolled key of the host it names,
+// and, if it was downloaded, that it was found where that host's status
+// for the investigation is kept.
+//
+func (record *StatusRecord) Verify(store Store) error {
+	if record.key != "" && record.key != statusPath(record.InvestigationID, record.Hostname) {
+		return errors.New("record for host " + record.Hostname + " was found at " + record.key)
+	}
+	hostKey, err := HostPublicKey(store, record.Hostname)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(record.HostPublicKey, hostKey) || !record.SignatureValid() {
+		return errors.New("record is not signed by the enrolled key of host " + record.Hostname)
+	}
+	return nil
+}
+
+//
+// Create a status record for an investigation, signed by this host.
+//
+func NewStatusRecord(identity *HostIdentity, investigationID, state, detail string) StatusRecord {
+	record := StatusRecord{
+		InvestigationID: investigationID,
+		Hostname:        identity.Name,
+		State:           state,
+		Detail:          detail,
+		Time:            time.Now().UTC(),
+		HostPublicKey:   identity.PublicKey(),
+	}
+	record.Signature = identity.Sign(record.digest())
+	return record
+}
+
+//
+// Sign and upload a status record for this investigation, replacing the
+// host's previous status.  Failures are logged, as status reporting should
+// never stop an investigation.
+//
+func (investigation *Investigation) postStatus(store Store, identity *HostIdentity, state, detail string) {
+	record := NewStatusRecord(identity, investigation.ID, state, detail)
+	data, err := json.MarshalIndent(record, "", "  ")
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":            "engine.postStatus",
+			"error":         err.Error(),
+			"investigation": investigation.ID,
+		}).Error("unable to marshal status record")
+		return
+	}
+	err = store.Put(statusPath(investigation.ID, identity.Name), bytes.NewReader(data))
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":            "engine.postStatus",
+			"error":         err.Error(),
+			"investigation": investigation.ID,
+			"state":         state,
+		}).Error("unable to upload status record")
+	}
+}
+
+//
+// Download the latest status record from every host that has reported
+// on an investigation, sorted by hostname.
+//
+func StatusRecords(store Store, investigationID string) ([]StatusRecord, error) {
+	records := []StatusRecord{}
+	iterator := store.Iterate("status/" + investigationID + "/")
+	for iterator.Next() {
+		key := iterator.Key()
+		if !strings.HasSuffix(key, ".json") {
+			continue
+		}
+		data, err := store.Get(key)
+		if err != nil {
+			return records, err
+		}
+		var record StatusRecord
+		err = json.Unmarshal(data, &record)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":    "engine.StatusRecords",
+				"error": err.Error(),
+				"key":   key,
+			}).Error("unable to parse status record")
+			continue
+		}
+		record.key = key
+		records = append(records, record)
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].Hostname < records[j].Hostname
+	})
+	return records, iterator.Err()
+}
@@ -0,0 +1,166 @@
+package engine_test
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func testHostIdentity(t *testing.T) (*engine.HostIdentity, func()) {
+	dir, err := ioutil.TempDir("", "dexter-host")
+	assert.Nil(t, err)
+	os.Setenv("DEXTER_HOST_KEY_FILE", dir+"/host.key")
+	identity, err := engine.LoadHostIdentity()
+	assert.Nil(t, err)
+	return identity, func() {
+		os.Unsetenv("DEXTER_HOST_KEY_FILE")
+		os.RemoveAll(dir)
+	}
+}
+
+func TestHostIdentityIsPersisted(t *testing.T) {
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+
+	reloaded, err := engine.LoadHostIdentity()
+	assert.Nil(t, err)
+	assert.Equal(t, identity.PublicKey(), reloaded.PublicKey())
+}
+
+func TestHostEnrollmentsAreSignedByAdmins(t *testing.T) {
+	assert := assert.New(t)
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+
+	alice, aliceSigner := newTestAdmin(t, "alice")
+	_, mallorySigner := newTestAdmin(t, "mallory")
+	dir, err := ioutil.TempDir("", "dexter-registry")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	data, err := json.Marshal(newTestRoots(1, alice))
+	assert.Nil(err)
+	assert.Nil(ioutil.WriteFile(dir+"/registry-roots.json", data, 0644))
+	os.Setenv("DEXTER_REGISTRY_ROOTS", dir+"/registry-roots.json")
+	defer os.Unsetenv("DEXTER_REGISTRY_ROOTS")
+
+	// anyone who can write to the store can upload an enrollment, but only
+	// one signed by a registry admin is trusted
+	store := engine.NewMemoryStore()
+	host := identity.Host()
+	assert.Nil(host.Upload(store))
+	_, err = engine.HostPublicKey(store, identity.Name)
+	assert.NotNil(err)
+
+	assert.Nil(host.Sign(mallorySigner, "mallory"))
+	assert.Nil(host.Upload(store))
+	_, err = engine.HostPublicKey(store, identity.Name)
+	assert.NotNil(err)
+	assert.Nil(host.Sign(mallorySigner, "alice"))
+	assert.Nil(host.Upload(store))
+	_, err = engine.HostPublicKey(store, identity.Name)
+	assert.NotNil(err)
+
+	assert.Nil(host.Sign(aliceSigner, "alice"))
+	assert.Nil(host.Upload(store))
+	hostKey, err := engine.HostPublicKey(store, identity.Name)
+	assert.Nil(err)
+	assert.Equal(identity.PublicKey(), hostKey)
+
+	// the signature covers the key, so it cannot be swapped for another
+	forger, cleanupForger := testHostIdentity(t)
+	defer cleanupForger()
+	swapped := host
+	swapped.PublicKey = forger.PublicKey()
+	assert.Nil(swapped.Upload(store))
+	_, err = engine.HostPublicKey(store, identity.Name)
+	assert.NotNil(err)
+}
+
+func TestStatusRecordSignatures(t *testing.T) {
+	assert := assert.New(t)
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+
+	record := engine.NewStatusRecord(identity, "abcd1234", engine.StatusOutOfScope, "platform")
+	assert.True(record.SignatureValid())
+
+	tampered := record
+	tampered.State = engine.StatusUploaded
+	assert.False(tampered.SignatureValid())
+
+	store := engine.NewMemoryStore()
+	data, err := json.Marshal(record)
+	assert.Nil(err)
+	assert.Nil(store.Put("status/abcd1234/"+identity.Name+".json", bytes.NewReader(data)))
+	data, err = json.Marshal(tampered)
+	assert.Nil(err)
+	assert.Nil(store.Put("status/abcd1234/zzzz.json", bytes.NewReader(data)))
+
+	data, err = json.Marshal(record)
+	assert.Nil(err)
+	assert.Nil(store.Put("status/abcd1234/other-host.json", bytes.NewReader(data)))
+
+	records, err := engine.StatusRecords(store, "abcd1234")
+	assert.Nil(err)
+	assert.Len(records, 3)
+	assert.NotNil(records[0].Verify(store), "hosts must be enrolled")
+	data, err = json.Marshal(identity.Host())
+	assert.Nil(err)
+	assert.Nil(store.Put("hosts/"+identity.Name+".json", bytes.NewReader(data)))
+	valid := 0
+	for _, record := range records {
+		if record.Verify(store) == nil {
+			valid++
+			assert.Equal("platform", record.Detail)
+		}
+	}
+	assert.Equal(1, valid, "tampered and misplaced records are not valid")
+
+	// a record signed with any other key is not valid for the host
+	forger, cleanupForger := testHostIdentity(t)
+	defer cleanupForger()
+	forged := engine.NewStatusRecord(forger, "abcd5678", engine.StatusUploaded, "")
+	assert.Equal(identity.Name, forged.Hostname)
+	assert.True(forged.SignatureValid())
+	assert.NotNil(forged.Verify(store))
+}
+
+func TestReportSignatures(t *testing.T) {
+	assert := assert.New(t)
+	identity, cleanup := testHostIdentity(t)
+	defer cleanup()
+
+	store := engine.NewMemoryStore()
+	_, err := engine.HostPublicKey(store, identity.Name)
+	assert.NotNil(err, "hosts must be enrolled before they are trusted")
+	data, err := json.Marshal(identity.Host())
+	assert.Nil(err)
+	assert.Nil(store.Put("hosts/"+identity.Name+".json", bytes.NewReader(data)))
+	hostKey, err := engine.HostPublicKey(store, identity.Name)
+	assert.Nil(err)
+
+	reportHash := sha256.Sum256([]byte("encrypted report"))
+	forgedHash := sha256.Sum256([]byte("forged report"))
+	payload := engine.DecryptionPayload{
+		Format:                     engine.StreamFormat,
+		EncryptedDataEncryptionKey: []byte("key"),
+	}
+	payload.SignReport(identity, "abcd1234", "alice", reportHash[:])
+	assert.Nil(payload.VerifyReport(store, hostKey, "abcd1234", identity.Name, "alice", reportHash[:]))
+
+	assert.NotNil(payload.VerifyReport(store, hostKey, "abcd1234", identity.Name, "alice", forgedHash[:]))
+	assert.NotNil(payload.VerifyReport(store, hostKey, "abcd1234", identity.Name, "bob", reportHash[:]))
+	assert.NotNil(payload.VerifyReport(store, hostKey, "abcd1234", "otherhost", "alice", reportHash[:]))
+	forged := payload
+	forged.EncryptedDataEncryptionKey = []byte("attacker key")
+	assert.NotNil(forged.VerifyReport(store, hostKey, "abcd1234", identity.Name, "alice", reportHash[:]))
+	forged = payload
+	forged.Format = ""
+	assert.NotNil(forged.VerifyReport(store, hostKey, "abcd1234", identity.Name, "alice", reportHash[:]))
+}
@@ -0,0 +1,131 @@
+package engine
+
+import (
+	"errors"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/coinbase/dexter/engine/helpers"
+)
+
+//
+// A Store is any backend that can hold the investigations, investigators,
+// and reports Dexter uses to communicate.  Keys are slash separated paths
+// such as "investigations/1a2b3c4d.alice".
+//
+type Store interface {
+	Get(key string) ([]byte, error)
+	Open(key string) (io.ReadCloser, error)
+	Iterate(prefix string) KeyIterator
+	Put(key string, data io.ReadSeeker) error
+	Delete(key string) error
+	Move(oldKey, newKey string) error
+}
+
+//
+// A KeyIterator steps through the keys in a store that start with a
+// prefix, one at a time, so large listings never need to be held in
+// memory.  Next must be called before the first key is read, and Err
+// should be checked once Next returns false.
+//
+type KeyIterator interface {
+	Next() bool
+	Key() string
+	Err() error
+}
+
+//
+// Collect every key in a store that starts with a prefix.  This should
+// only be used when the full listing is needed at once, otherwise use
+// the store's iterator directly.
+//
+func ListKeys(store Store, prefix string) ([]string, error) {
+	keys := []string{}
+	iterator := store.Iterate(prefix)
+	for iterator.Next() {
+		keys = append(keys, iterator.Key())
+	}
+	return keys, iterator.Err()
+}
+
+//
+// Return true if an error from reading a store means the key does not
+// exist, rather than that the store could not be read.
+//
+func isNotFound(err error) bool {
+	if _, ok := err.(keyNotFoundError); ok || os.IsNotExist(err) {
+		return true
+	}
+	if failure, ok := err.(awserr.Error); ok {
+		return failure.Code() == s3.ErrCodeNoSuchKey
+	}
+	return false
+}
+
+//
+// Return true if a key exists in a store, listing only the keys that start
+// with it.
+//
+func keyExists(store Store, key string) (bool, error) {
+	iterator := store.Iterate(key)
+	for iterator.Next() {
+		if iterator.Key() == key {
+			return true, nil
+		}
+	}
+	return false, iterator.Err()
+}
+
+//
+// An iterator over keys that have already been collected, used by
+// stores that can cheaply list everything at once.
+//
+type sliceIterator struct {
+	keys  []string
+	index int
+	err   error
+}
+
+func (iterator *sliceIterator) Next() bool {
+	if iterator.err != nil || iterator.index >= len(iterator.keys) {
+		return false
+	}
+	iterator.index += 1
+	return true
+}
+
+func (iterator *sliceIterator) Key() string {
+	return iterator.keys[iterator.index-1]
+}
+
+func (iterator *sliceIterator) Err() error {
+	return iterator.err
+}
+
+//
+// Create a store from a URL.  URLs starting with "s3://" are backed by
+// the named S3 bucket, and URLs starting with "file://" are backed by
+// a directory on the local filesystem.
+//
+func NewStore(url string) (Store, error) {
+	if strings.HasPrefix(url, "s3://") {
+		bucket := strings.TrimPrefix(url, "s3://")
+		if bucket == "" {
+			return nil, errors.New("no bucket specified in store url")
+		}
+		return NewS3Store(bucket), nil
+	} else if strings.HasPrefix(url, "file://") {
+		return NewLocalStore(strings.TrimPrefix(url, "file://"))
+	}
+	return nil, errors.New("unsupported store url: " + url)
+}
+
+//
+// Return the store defined by Dexter's configuration.
+//
+func ConfiguredStore() (Store, error) {
+	return NewStore(helpers.StoreURL())
+}
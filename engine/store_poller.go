@@ -0,0 +1,356 @@
+package engine
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/coinbase/dexter/engine/helpers"
+)
+
+//
+// A poller that will stream investigations from the investigations
+// directory of a Dexter store.
+//
+// Each approval is uploaded as its own copy of an investigation, so the
+// poller tracks investigations by ID and merges the approvals from every
+// copy it finds.  An investigation is sent again each time new approvals
+// arrive, until it is reported as evaluated or has waited for consensus
+// longer than the configured timeout.
+//
+// Only a copy with a valid issuer signature can start tracking an
+// investigation, so a forged copy cannot take the place of the real one and
+// refuse its approvals.
+//
+// When given a state file, the poller records settled and pending
+// investigations in it so that a restarted daemon resumes where it left
+// off, sending each investigation published while it was down exactly once.
+//
+type StorePoller struct {
+	store     Store
+	roots     *RegistryRoots
+	seenFiles map[string]bool
+	statePath string
+	resumed   bool
+
+	lock     sync.Mutex
+	pending  map[string]*pendingInvestigation
+	settled  map[string]bool
+	restored map[string]time.Time
+	lastPoll time.Time
+}
+
+//
+// An investigation the poller has seen but that has not yet been settled.
+//
+type pendingInvestigation struct {
+	investigation Investigation
+	firstSeen     time.Time
+	changed       bool
+	evaluating    bool
+}
+
+//
+// Create a new poller for a store, trusting the investigators under roots
+// to issue investigations and resuming from the state file at statePath.
+// If statePath is empty the poller keeps no state between runs.
+//
+func NewStorePoller(store Store, roots *RegistryRoots, statePath string) (*StorePoller, error) {
+	poller := &StorePoller{
+		store:     store,
+		roots:     roots,
+		seenFiles: make(map[string]bool),
+		statePath: statePath,
+		pending:   make(map[string]*pendingInvestigation),
+		settled:   make(map[string]bool),
+		restored:  make(map[string]time.Time),
+	}
+	if statePath == "" {
+		return poller, nil
+	}
+	state, exists, err := loadDaemonState(statePath)
+	if err != nil {
+		return nil, err
+	}
+	poller.resumed = exists
+	for _, id := range state.Completed {
+		poller.settled[id] = true
+	}
+	for id, firstSeen := range state.Pending {
+		poller.restored[id] = firstSeen
+	}
+	poller.lastPoll = state.LastPoll
+	RaiseRegistryFloor(state.RegistryVersion)
+	RememberRevocations(state.Revocations)
+	return poller, nil
+}
+
+//
+// Get a chan of investigation structs from the investigations in the store.
+//
+func (poller *StorePoller) Poll() chan Investigation {
+	newInvestigations := make(chan Investigation)
+	go poller.pollInvestigations(newInvestigations)
+	return newInvestigations
+}
+
+//
+// How an investigation sent by the poller was dealt with.
+//
+type Evaluation int
+
+const (
+	// It ran and was reported, or can never run on this host.  It is
+	// never sent again.
+	EvaluationSettled Evaluation = iota
+	// It needs more approvals, and is sent again when they are uploaded.
+	EvaluationAwaitingConsensus
+	// It could not be checked, such as when the registry could not be
+	// read, and is sent again after the next poll.
+	EvaluationRetry
+)
+
+//
+// Report that an investigation sent by the poller has been dealt with.
+// Investigations that run are only settled once they are reported, so one
+// interrupted by a restart is sent again and the execution ledger decides
+// whether it ran.
+//
+func (poller *StorePoller) Evaluated(id string, evaluation Evaluation) {
+	poller.lock.Lock()
+	defer poller.lock.Unlock()
+	pending, ok := poller.pending[id]
+	if !ok {
+		return
+	}
+	switch evaluation {
+	case EvaluationAwaitingConsensus:
+		pending.evaluating = false
+		return
+	case EvaluationRetry:
+		pending.evaluating = false
+		pending.changed = true
+		return
+	}
+	delete(poller.pending, id)
+	poller.settled[id] = true
+	poller.saveState()
+}
+
+func (poller *StorePoller) pollInvestigations(newInvestigations chan Investigation) {
+	if poller.resumed {
+		log.WithFields(log.Fields{
+			"at":        "engine.pollInvestigations",
+			"last_poll": poller.lastPoll,
+		}).Info("resuming from saved daemon state")
+	}
+
+	// With saved state, anything new or still pending is sent once on
+	// startup.  Otherwise investigations already in the store are not sent
+	// until they gain approvals, and are recorded as pending when starting a
+	// new state file.
+	err := poller.scan(poller.resumed)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.pollInvestigations",
+			"error": err.Error(),
+		}).Fatal("error listing investigation objects in store")
+	}
+	poller.polled()
+
+	for {
+		for _, investigation := range poller.ready() {
+			newInvestigations <- investigation
+		}
+		if err != nil {
+			time.Sleep(10 * time.Second)
+		} else {
+			time.Sleep(time.Duration(helpers.PollInterval()) * time.Second)
+		}
+		err = poller.scan(true)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":    "engine.pollInvestigations",
+				"error": err.Error(),
+			}).Error("error listing investigation objects in store")
+		} else {
+			poller.polled()
+		}
+	}
+}
+
+//
+// Download every investigation file the poller has not seen before and merge
+// it into the pending investigation with the same ID.  Files that cannot be
+// downloaded or parsed are tried again on the next scan.  When markChanged is
+// true, investigations that are new or gained approvals will be sent by the
+// next call to ready.
+//
+func (poller *StorePoller) scan(markChanged bool) error {
+	trusted := newTrustedInvestigatorsWithRoots(poller.store, poller.roots)
+	iterator := poller.store.Iterate("investigations/")
+	for iterator.Next() {
+		key := iterator.Key()
+		if poller.seenFiles[key] {
+			continue
+		}
+		if strings.HasPrefix(strings.TrimPrefix(key, "investigations/"), "_") {
+			poller.seenFiles[key] = true
+			continue
+		}
+		data, err := poller.store.Get(key)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":    "engine.pollInvestigations",
+				"error": err.Error(),
+				"key":   key,
+			}).Error("error getting investigation object from store")
+			continue
+		}
+		var inv = Investigation{}
+		err = json.Unmarshal(data, &inv)
+		if err != nil {
+			log.WithFields(log.Fields{
+				"at":    "engine.pollInvestigations",
+				"error": err.Error(),
+				"key":   key,
+			}).Error("downloaded json-invalid investigation")
+			continue
+		}
+		// only a copy signed by its issuer may start tracking an
+		// investigation, and the first valid copy found does
+		if !poller.tracking(inv.ID) {
+			if _, err := trusted.byName(inv.Issuer.Name); isTrustLoadError(err) {
+				log.WithFields(log.Fields{
+					"at":    "engine.pollInvestigations",
+					"error": err.Error(),
+					"key":   key,
+				}).Error("unable to check investigation issuer, will retry")
+				continue
+			}
+			if !inv.validateSignature(trusted, inv.Issuer) {
+				log.WithFields(log.Fields{
+					"at":     "engine.pollInvestigations",
+					"issuer": inv.Issuer.Name,
+					"key":    key,
+				}).Error("ignoring investigation with an invalid issuer signature")
+				poller.seenFiles[key] = true
+				continue
+			}
+		}
+		poller.seenFiles[key] = true
+		poller.track(inv, markChanged)
+	}
+	return iterator.Err()
+}
+
+//
+// Return true if an investigation is already pending or settled.
+//
+func (poller *StorePoller) tracking(id string) bool {
+	poller.lock.Lock()
+	defer poller.lock.Unlock()
+	_, pending := poller.pending[id]
+	return pending || poller.settled[id]
+}
+
+func (poller *StorePoller) track(inv Investigation, markChanged bool) {
+	poller.lock.Lock()
+	defer poller.lock.Unlock()
+	if poller.settled[inv.ID] {
+		return
+	}
+	pending, ok := poller.pending[inv.ID]
+	if !ok {
+		firstSeen, restored := poller.restored[inv.ID]
+		if !restored {
+			firstSeen = time.Now()
+		}
+		poller.pending[inv.ID] = &pendingInvestigation{
+			investigation: inv,
+			firstSeen:     firstSeen,
+			changed:       markChanged,
+		}
+		return
+	}
+	if pending.investigation.MergeApprovals(inv) && markChanged {
+		pending.changed = true
+	}
+}
+
+//
+// Return the investigations that changed since they were last sent and are
+// not currently being evaluated, and give up on any that have expired or
+// waited for consensus too long.  Investigations that are not valid yet are
+// held until their not-before time.
+//
+func (poller *StorePoller) ready() []Investigation {
+	poller.lock.Lock()
+	defer poller.lock.Unlock()
+	set := []Investigation{}
+	for id, pending := range poller.pending {
+		if pending.evaluating {
+			continue
+		}
+		if time.Since(pending.firstSeen) > helpers.ConsensusTimeout() || pending.investigation.Expired() {
+			log.WithFields(log.Fields{
+				"at":            "engine.pollInvestigations",
+				"investigation": id,
+			}).Info("investigation expired before reaching consensus")
+			delete(poller.pending, id)
+			poller.settled[id] = true
+			poller.saveState()
+			continue
+		}
+		if pending.changed && !time.Now().Before(pending.investigation.NotBefore) {
+			pending.changed = false
+			pending.evaluating = true
+			set = append(set, pending.investigation)
+		}
+	}
+	return set
+}
+
+//
+// Record a successful poll of the store.
+//
+func (poller *StorePoller) polled() {
+	poller.lock.Lock()
+	defer poller.lock.Unlock()
+	poller.lastPoll = time.Now().UTC()
+	poller.saveState()
+}
+
+//
+// Write the poller's progress to its state file.  Must be called with the
+// lock held.  A failure is logged rather than stopping the daemon.
+//
+func (poller *StorePoller) saveState() {
+	if poller.statePath == "" {
+		return
+	}
+	state := daemonState{
+		Completed: []string{},
+		Pending:   make(map[string]time.Time),
+		LastPoll:  poller.lastPoll,
+
+		RegistryVersion: RegistryFloor(),
+		Revocations:     RememberedRevocations(),
+	}
+	for id := range poller.settled {
+		state.Completed = append(state.Completed, id)
+	}
+	for id, pending := range poller.pending {
+		state.Pending[id] = pending.firstSeen
+	}
+	err := state.save(poller.statePath)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "engine.saveState",
+			"error": err.Error(),
+			"file":  poller.statePath,
+		}).Error("unable to save daemon state")
+	}
+}
@@ -0,0 +1,260 @@
+package engine_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func putInvestigationCopy(t *testing.T, store engine.Store, inv engine.Investigation, owner string) {
+	data, err := json.Marshal(inv)
+	assert.Nil(t, err)
+	assert.Nil(t, store.Put("investigations/"+inv.ID+"."+owner, bytes.NewReader(data)))
+}
+
+//
+// Return an investigation issued by alice, who must be in the store.
+//
+func issuedInvestigation(id string, keyring *engine.Keyring) engine.Investigation {
+	inv := engine.Investigation{
+		ID:       id,
+		TaskList: map[string][]string{"example": {}},
+		Issuer:   engine.Signature{Name: "alice"},
+	}
+	inv.Sign(keyring.Current())
+	return inv
+}
+
+func receive(investigations chan engine.Investigation) (engine.Investigation, bool) {
+	select {
+	case inv := <-investigations:
+		return inv, true
+	case <-time.After(3 * time.Second):
+		return engine.Investigation{}, false
+	}
+}
+
+func TestStorePollerMergesLateApprovals(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("DEXTER_POLL_INTERVAL_SECONDS", "1")
+	defer os.Unsetenv("DEXTER_POLL_INTERVAL_SECONDS")
+
+	store := engine.NewMemoryStore()
+	_, keyring := putTestInvestigator(t, store, "alice")
+	inv := issuedInvestigation("abcd1234", keyring)
+	putInvestigationCopy(t, store, inv, "alice")
+
+	poller, err := engine.NewStorePoller(store, nil, "")
+	assert.Nil(err)
+	investigations := poller.Poll()
+	_, received := receive(investigations)
+	assert.False(received, "investigations present at startup should not be sent")
+
+	bob := inv
+	bob.Approvers = []engine.Signature{{Name: "bob", Data: []byte("bob")}}
+	putInvestigationCopy(t, store, bob, "bob")
+	merged, received := receive(investigations)
+	assert.True(received)
+	assert.Len(merged.Approvers, 1)
+	poller.Evaluated(inv.ID, engine.EvaluationAwaitingConsensus)
+
+	carol := inv
+	carol.Approvers = []engine.Signature{{Name: "carol", Data: []byte("carol")}}
+	putInvestigationCopy(t, store, carol, "carol")
+	merged, received = receive(investigations)
+	assert.True(received)
+	assert.Len(merged.Approvers, 2)
+	poller.Evaluated(inv.ID, engine.EvaluationSettled)
+
+	dave := inv
+	dave.Approvers = []engine.Signature{{Name: "dave", Data: []byte("dave")}}
+	putInvestigationCopy(t, store, dave, "dave")
+	_, received = receive(investigations)
+	assert.False(received, "settled investigations should not be sent again")
+}
+
+func TestStorePollerResendsInvestigationsToRetry(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("DEXTER_POLL_INTERVAL_SECONDS", "1")
+	defer os.Unsetenv("DEXTER_POLL_INTERVAL_SECONDS")
+
+	store := engine.NewMemoryStore()
+	_, keyring := putTestInvestigator(t, store, "alice")
+	poller, err := engine.NewStorePoller(store, nil, "")
+	assert.Nil(err)
+	investigations := poller.Poll()
+	_, received := receive(investigations)
+	assert.False(received)
+
+	inv := issuedInvestigation("abcd1234", keyring)
+	putInvestigationCopy(t, store, inv, "alice")
+	sent, received := receive(investigations)
+	assert.True(received)
+	poller.Evaluated(sent.ID, engine.EvaluationRetry)
+
+	// sent again without any new approvals
+	sent, received = receive(investigations)
+	assert.True(received)
+	assert.Equal(inv.ID, sent.ID)
+	poller.Evaluated(sent.ID, engine.EvaluationSettled)
+	_, received = receive(investigations)
+	assert.False(received)
+}
+
+func TestStorePollerIgnoresForgedCopies(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("DEXTER_POLL_INTERVAL_SECONDS", "1")
+	defer os.Unsetenv("DEXTER_POLL_INTERVAL_SECONDS")
+
+	store := &unreadableStore{Store: engine.NewMemoryStore()}
+	_, keyring := putTestInvestigator(t, store, "alice")
+	poller, err := engine.NewStorePoller(store, nil, "")
+	assert.Nil(err)
+	investigations := poller.Poll()
+	_, received := receive(investigations)
+	assert.False(received)
+
+	// a forged copy listed before the real one does not take its place
+	inv := issuedInvestigation("abcd1234", keyring)
+	forged := inv
+	forged.TaskList = map[string][]string{"osquery-collect": {"select 1"}}
+	putInvestigationCopy(t, store, forged, "aaa")
+	store.unreadable = "investigators/alice.json"
+	putInvestigationCopy(t, store, inv, "alice")
+	_, received = receive(investigations)
+	assert.False(received, "copies whose issuer cannot be checked should not be sent")
+
+	store.unreadable = ""
+	sent, received := receive(investigations)
+	assert.True(received, "copies whose issuer cannot be checked should be read again")
+	assert.Equal(inv.TaskList, sent.TaskList)
+	poller.Evaluated(sent.ID, engine.EvaluationAwaitingConsensus)
+
+	approved := inv
+	approved.Approvers = []engine.Signature{{Name: "bob", Data: []byte("bob")}}
+	putInvestigationCopy(t, store, approved, "bob")
+	merged, received := receive(investigations)
+	assert.True(received)
+	assert.Len(merged.Approvers, 1)
+}
+
+func TestStorePollerRetriesUnreadableCopies(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("DEXTER_POLL_INTERVAL_SECONDS", "1")
+	defer os.Unsetenv("DEXTER_POLL_INTERVAL_SECONDS")
+
+	store := &unreadableStore{Store: engine.NewMemoryStore()}
+	_, keyring := putTestInvestigator(t, store, "alice")
+	poller, err := engine.NewStorePoller(store, nil, "")
+	assert.Nil(err)
+	investigations := poller.Poll()
+	_, received := receive(investigations)
+	assert.False(received)
+
+	inv := issuedInvestigation("abcd1234", keyring)
+	store.unreadable = "investigations/abcd1234.alice"
+	putInvestigationCopy(t, store, inv, "alice")
+	_, received = receive(investigations)
+	assert.False(received)
+
+	store.unreadable = ""
+	sent, received := receive(investigations)
+	assert.True(received, "copies that could not be downloaded should be downloaded again")
+	assert.Equal(inv.ID, sent.ID)
+}
+
+func TestMergeApprovalsRequiresMatchingContents(t *testing.T) {
+	assert := assert.New(t)
+
+	inv := engine.Investigation{
+		ID:       "abcd1234",
+		TaskList: map[string][]string{"example": {}},
+		Issuer:   engine.Signature{Name: "alice"},
+	}
+	approved := inv
+	approved.Approvers = []engine.Signature{{Name: "bob", Data: []byte("bob")}}
+	assert.True(inv.MergeApprovals(approved))
+	assert.False(inv.MergeApprovals(approved))
+	assert.Len(inv.Approvers, 1)
+
+	altered := approved
+	altered.TaskList = map[string][]string{"osquery-collect": {"select 1"}}
+	altered.Approvers = []engine.Signature{{Name: "carol", Data: []byte("carol")}}
+	assert.False(inv.MergeApprovals(altered))
+	assert.Len(inv.Approvers, 1)
+}
+
+func TestStorePollerResumesFromState(t *testing.T) {
+	assert := assert.New(t)
+	os.Setenv("DEXTER_POLL_INTERVAL_SECONDS", "1")
+	defer os.Unsetenv("DEXTER_POLL_INTERVAL_SECONDS")
+
+	dir, err := ioutil.TempDir("", "dexter-state")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	statePath := dir + "/daemon-state.json"
+
+	store := engine.NewMemoryStore()
+	_, keyring := putTestInvestigator(t, store, "alice")
+	newInvestigation := func(id string) engine.Investigation {
+		inv := issuedInvestigation(id, keyring)
+		putInvestigationCopy(t, store, inv, "alice")
+		return inv
+	}
+	existing := newInvestigation("aaaaaaaa")
+
+	first, err := engine.NewStorePoller(store, nil, statePath)
+	assert.Nil(err)
+	investigations := first.Poll()
+	_, received := receive(investigations)
+	assert.False(received, "investigations present before the first run should not be sent")
+
+	// investigations present before the first run are kept pending, so
+	// one still awaiting consensus runs once it is approved
+	approved := existing
+	approved.Approvers = []engine.Signature{{Name: "bob", Data: []byte("bob")}}
+	putInvestigationCopy(t, store, approved, "bob")
+	inv, received := receive(investigations)
+	assert.True(received)
+	assert.Equal("aaaaaaaa", inv.ID)
+	first.Evaluated(inv.ID, engine.EvaluationAwaitingConsensus)
+
+	newInvestigation("bbbbbbbb")
+	inv, received = receive(investigations)
+	assert.True(received)
+	assert.Equal("bbbbbbbb", inv.ID)
+	first.Evaluated(inv.ID, engine.EvaluationSettled)
+
+	newInvestigation("cccccccc")
+	inv, received = receive(investigations)
+	assert.True(received)
+	assert.Equal("cccccccc", inv.ID)
+	first.Evaluated(inv.ID, engine.EvaluationAwaitingConsensus)
+
+	// the first poller blocks sending this one, as if the daemon stopped
+	// before picking it up
+	newInvestigation("dddddddd")
+	time.Sleep(2500 * time.Millisecond)
+	newInvestigation("eeeeeeee")
+
+	second, err := engine.NewStorePoller(store, nil, statePath)
+	assert.Nil(err)
+	investigations = second.Poll()
+	resumed := map[string]bool{}
+	for {
+		inv, received := receive(investigations)
+		if !received {
+			break
+		}
+		assert.False(resumed[inv.ID], "investigations should be sent once")
+		resumed[inv.ID] = true
+		second.Evaluated(inv.ID, engine.EvaluationSettled)
+	}
+	assert.Equal(map[string]bool{"aaaaaaaa": true, "cccccccc": true, "dddddddd": true, "eeeeeeee": true}, resumed)
+}
@@ -0,0 +1,156 @@
+package engine_test
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, store engine.Store) {
+	assert := assert.New(t)
+
+	assert.Nil(store.Put("investigations/aaaaaaaa.alice", bytes.NewReader([]byte("one"))))
+	assert.Nil(store.Put("investigations/bbbbbbbb.bob", bytes.NewReader([]byte("two"))))
+	assert.Nil(store.Put("reports/aaaaaaaa/host/report.zip.enc", bytes.NewReader([]byte("three"))))
+
+	data, err := store.Get("investigations/aaaaaaaa.alice")
+	assert.Nil(err)
+	assert.Equal([]byte("one"), data)
+
+	reader, err := store.Open("reports/aaaaaaaa/host/report.zip.enc")
+	assert.Nil(err)
+	data, err = ioutil.ReadAll(reader)
+	assert.Nil(err)
+	assert.Nil(reader.Close())
+	assert.Equal([]byte("three"), data)
+	_, err = store.Open("reports/missing")
+	assert.NotNil(err)
+
+	keys, err := engine.ListKeys(store, "investigations/")
+	assert.Nil(err)
+	assert.Equal([]string{"investigations/aaaaaaaa.alice", "investigations/bbbbbbbb.bob"}, keys)
+
+	keys, err = engine.ListKeys(store, "investigations/b")
+	assert.Nil(err)
+	assert.Equal([]string{"investigations/bbbbbbbb.bob"}, keys)
+
+	keys, err = engine.ListKeys(store, "reports/")
+	assert.Nil(err)
+	assert.Equal([]string{"reports/aaaaaaaa/host/report.zip.enc"}, keys)
+
+	assert.Nil(store.Move("investigations/aaaaaaaa.alice", "investigations/_aaaaaaaa.alice"))
+	_, err = store.Get("investigations/aaaaaaaa.alice")
+	assert.NotNil(err)
+	data, err = store.Get("investigations/_aaaaaaaa.alice")
+	assert.Nil(err)
+	assert.Equal([]byte("one"), data)
+
+	assert.Nil(store.Delete("investigations/bbbbbbbb.bob"))
+	keys, err = engine.ListKeys(store, "investigations/")
+	assert.Nil(err)
+	assert.Equal([]string{"investigations/_aaaaaaaa.alice"}, keys)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, engine.NewMemoryStore())
+}
+
+func TestLocalStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dexter-store")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := engine.NewLocalStore(dir)
+	assert.Nil(t, err)
+	testStore(t, store)
+}
+
+func TestNewStoreFromURL(t *testing.T) {
+	assert := assert.New(t)
+
+	dir, err := ioutil.TempDir("", "dexter-store")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+
+	store, err := engine.NewStore("file://" + dir)
+	assert.Nil(err)
+	assert.IsType(&engine.LocalStore{}, store)
+
+	_, err = engine.NewStore("s3://")
+	assert.NotNil(err)
+	_, err = engine.NewStore("ftp://example.com")
+	assert.NotNil(err)
+}
+
+func TestResolveUUID(t *testing.T) {
+	assert := assert.New(t)
+
+	store := engine.NewMemoryStore()
+	store.Put("investigations/abcd1234.alice", bytes.NewReader([]byte{}))
+	store.Put("investigations/abce5678.alice", bytes.NewReader([]byte{}))
+	store.Put("reports/ffff0000-host.alice.zip.enc", bytes.NewReader([]byte{}))
+
+	uuid, err := engine.ResolveUUID(store, "abcd")
+	assert.Nil(err)
+	assert.Equal("abcd1234", uuid)
+
+	uuid, err = engine.ResolveUUID(store, "ff")
+	assert.Nil(err)
+	assert.Equal("ffff0000", uuid)
+
+	_, err = engine.ResolveUUID(store, "abc")
+	assert.NotNil(err)
+	_, err = engine.ResolveUUID(store, "0000")
+	assert.NotNil(err)
+}
+
+type pagedS3Client struct {
+	s3iface.S3API
+	keys     []string
+	pageSize int
+	requests int
+}
+
+func (client *pagedS3Client) ListObjectsV2(input *s3.ListObjectsV2Input) (*s3.ListObjectsV2Output, error) {
+	client.requests += 1
+	start := 0
+	if input.ContinuationToken != nil {
+		start, _ = strconv.Atoi(*input.ContinuationToken)
+	}
+	end := start + client.pageSize
+	if end > len(client.keys) {
+		end = len(client.keys)
+	}
+	output := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(end < len(client.keys))}
+	for _, key := range client.keys[start:end] {
+		output.Contents = append(output.Contents, &s3.Object{Key: aws.String(key)})
+	}
+	if end < len(client.keys) {
+		output.NextContinuationToken = aws.String(strconv.Itoa(end))
+	}
+	return output, nil
+}
+
+func TestS3StoreIteratesAllPages(t *testing.T) {
+	assert := assert.New(t)
+
+	client := &pagedS3Client{pageSize: 1000}
+	for i := 0; i < 2500; i++ {
+		client.keys = append(client.keys, fmt.Sprintf("reports/%08d-host.alice.zip.enc", i))
+	}
+	store := engine.NewS3StoreWithClient("bucket", client)
+
+	keys, err := engine.ListKeys(store, "reports/")
+	assert.Nil(err)
+	assert.Equal(client.keys, keys)
+	assert.Equal(3, client.requests)
+}
@@ -0,0 +1,264 @@
+package engine
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+//
+// Reports are encrypted as a stream of independently authenticated
+// segments, following the STREAM construction, so neither the daemon nor
+// the CLI ever has to hold a whole report in memory.
+//
+// A stream starts with a header of a magic string, the segment size, and
+// a random nonce prefix.  Each segment of plaintext is then sealed with
+// AES-GCM using a nonce made of the prefix, the segment's counter, and a
+// flag marking the final segment.  The header is authenticated with every
+// segment, and the final flag prevents a stream from being truncated at a
+// segment boundary without detection.
+//
+const (
+	StreamFormat = "stream-v1"
+
+	streamMagic         = "DXS1"
+	streamSegmentSize   = 64 * 1024
+	streamPrefixSize    = 7
+	streamHeaderSize    = len(streamMagic) + 4 + streamPrefixSize
+	streamMaxSegmentLen = 16 * 1024 * 1024
+)
+
+func newStreamAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func streamNonce(prefix []byte, counter uint32, final bool) []byte {
+	nonce := make([]byte, 12)
+	copy(nonce, prefix)
+	binary.BigEndian.PutUint32(nonce[streamPrefixSize:], counter)
+	if final {
+		nonce[11] = 0x01
+	}
+	return nonce
+}
+
+//
+// A writer that encrypts everything written to it as a segmented stream.
+// Close must be called to seal the final segment.
+//
+type streamEncrypter struct {
+	out     io.Writer
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	buffer  []byte
+	counter uint32
+	closed  bool
+}
+
+//
+// Create a writer that encrypts a stream with a key, writing the
+// encrypted stream to out.
+//
+func NewStreamEncrypter(out io.Writer, key []byte) (io.WriteCloser, error) {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, streamPrefixSize)
+	if _, err := io.ReadFull(rand.Reader, prefix); err != nil {
+		return nil, err
+	}
+	header := make([]byte, 0, streamHeaderSize)
+	header = append(header, []byte(streamMagic)...)
+	sizeBytes := make([]byte, 4)
+	binary.BigEndian.PutUint32(sizeBytes, streamSegmentSize)
+	header = append(header, sizeBytes...)
+	header = append(header, prefix...)
+	if _, err := out.Write(header); err != nil {
+		return nil, err
+	}
+	return &streamEncrypter{
+		out:    out,
+		aead:   aead,
+		header: header,
+		prefix: prefix,
+		buffer: make([]byte, 0, streamSegmentSize),
+	}, nil
+}
+
+func (stream *streamEncrypter) Write(data []byte) (int, error) {
+	if stream.closed {
+		return 0, errors.New("write to closed stream")
+	}
+	written := 0
+	for len(data) > 0 {
+		// a full buffer is only sealed once more data arrives, so the
+		// final segment is always the one sealed by Close
+		if len(stream.buffer) == streamSegmentSize {
+			if err := stream.seal(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(stream.buffer[len(stream.buffer):streamSegmentSize], data)
+		stream.buffer = stream.buffer[:len(stream.buffer)+n]
+		data = data[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (stream *streamEncrypter) seal(final bool) error {
+	if stream.counter == ^uint32(0) {
+		return errors.New("stream is too long to encrypt")
+	}
+	nonce := streamNonce(stream.prefix, stream.counter, final)
+	segment := stream.aead.Seal(nil, nonce, stream.buffer, stream.header)
+	stream.counter += 1
+	stream.buffer = stream.buffer[:0]
+	_, err := stream.out.Write(segment)
+	return err
+}
+
+//
+// Seal the final segment.  This does not close the underlying writer.
+//
+func (stream *streamEncrypter) Close() error {
+	if stream.closed {
+		return nil
+	}
+	stream.closed = true
+	return stream.seal(true)
+}
+
+//
+// A reader that decrypts and authenticates a segmented stream.
+//
+type streamDecrypter struct {
+	in      *bufio.Reader
+	aead    cipher.AEAD
+	header  []byte
+	prefix  []byte
+	segment int
+	counter uint32
+	plain   *bytes.Reader
+	done    bool
+}
+
+//
+// Create a reader that decrypts a stream produced by NewStreamEncrypter.
+// Reads return an error if any part of the stream has been altered,
+// reordered, or truncated.
+//
+func NewStreamDecrypter(in io.Reader, key []byte) (io.Reader, error) {
+	aead, err := newStreamAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+	header := make([]byte, streamHeaderSize)
+	if _, err := io.ReadFull(in, header); err != nil {
+		return nil, errors.New("unable to read stream header: " + err.Error())
+	}
+	if string(header[:len(streamMagic)]) != streamMagic {
+		return nil, errors.New("not an encrypted dexter stream")
+	}
+	segmentSize := int(binary.BigEndian.Uint32(header[len(streamMagic):]))
+	if segmentSize < 1 || segmentSize > streamMaxSegmentLen {
+		return nil, errors.New("invalid stream segment size")
+	}
+	segment := segmentSize + aead.Overhead()
+	return &streamDecrypter{
+		// one byte more than a segment is buffered, so the reader can
+		// tell whether the segment it is reading is the last one
+		in:      bufio.NewReaderSize(in, segment+1),
+		aead:    aead,
+		header:  header,
+		prefix:  header[len(streamMagic)+4:],
+		segment: segment,
+		plain:   bytes.NewReader(nil),
+	}, nil
+}
+
+func (stream *streamDecrypter) Read(data []byte) (int, error) {
+	for stream.plain.Len() == 0 {
+		if stream.done {
+			return 0, io.EOF
+		}
+		if err := stream.open(); err != nil {
+			return 0, err
+		}
+	}
+	return stream.plain.Read(data)
+}
+
+func (stream *streamDecrypter) open() error {
+	peeked, err := stream.in.Peek(stream.segment + 1)
+	final := false
+	if err == io.EOF {
+		final = true
+	} else if err != nil {
+		return err
+	}
+	length := stream.segment
+	if final {
+		length = len(peeked)
+	}
+	if length < stream.aead.Overhead() {
+		return io.ErrUnexpectedEOF
+	}
+	nonce := streamNonce(stream.prefix, stream.counter, final)
+	plaintext, err := stream.aead.Open(nil, nonce, peeked[:length], stream.header)
+	if err != nil {
+		return errors.New("encrypted stream failed authentication")
+	}
+	stream.in.Discard(length)
+	stream.counter += 1
+	stream.plain = bytes.NewReader(plaintext)
+	stream.done = final
+	return nil
+}
+
+//
+// Encrypt a file to a new file as a stream, returning the SHA-256 hash
+// of the encrypted file.
+//
+func encryptFile(source, destination string, key []byte) ([]byte, error) {
+	in, err := os.Open(filepath.FromSlash(source))
+	if err != nil {
+		return nil, err
+	}
+	defer in.Close()
+	out, err := os.Create(filepath.FromSlash(destination))
+	if err != nil {
+		return nil, err
+	}
+	defer out.Close()
+
+	hash := sha256.New()
+	encrypter, err := NewStreamEncrypter(io.MultiWriter(out, hash), key)
+	if err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(encrypter, in); err != nil {
+		return nil, err
+	}
+	if err = encrypter.Close(); err != nil {
+		return nil, err
+	}
+	if err = out.Sync(); err != nil {
+		return nil, err
+	}
+	return hash.Sum(nil), nil
+}
@@ -0,0 +1,86 @@
+package engine_test
+
+import (
+	"bytes"
+	"crypto/rand"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/coinbase/dexter/engine"
+	"github.com/stretchr/testify/assert"
+)
+
+const segment = 64 * 1024
+
+func encryptStream(t *testing.T, key, plaintext []byte) []byte {
+	out := new(bytes.Buffer)
+	encrypter, err := engine.NewStreamEncrypter(out, key)
+	assert.Nil(t, err)
+	// write in uneven pieces to exercise buffering across segments
+	for len(plaintext) > 0 {
+		n := 1000
+		if n > len(plaintext) {
+			n = len(plaintext)
+		}
+		_, err = encrypter.Write(plaintext[:n])
+		assert.Nil(t, err)
+		plaintext = plaintext[n:]
+	}
+	assert.Nil(t, encrypter.Close())
+	return out.Bytes()
+}
+
+func decryptStream(key, ciphertext []byte) ([]byte, error) {
+	decrypter, err := engine.NewStreamDecrypter(bytes.NewReader(ciphertext), key)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(decrypter)
+}
+
+func randomBytes(t *testing.T, size int) []byte {
+	data := make([]byte, size)
+	_, err := io.ReadFull(rand.Reader, data)
+	assert.Nil(t, err)
+	return data
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	key := randomBytes(t, 16)
+	for _, size := range []int{0, 1, segment - 1, segment, segment + 1, 3*segment + 17} {
+		plaintext := randomBytes(t, size)
+		decrypted, err := decryptStream(key, encryptStream(t, key, plaintext))
+		assert.Nil(t, err, "size %d", size)
+		assert.True(t, bytes.Equal(plaintext, decrypted), "size %d", size)
+	}
+}
+
+func TestStreamRejectsTampering(t *testing.T) {
+	assert := assert.New(t)
+	key := randomBytes(t, 16)
+	ciphertext := encryptStream(t, key, randomBytes(t, 2*segment+100))
+	overhead := (len(ciphertext) - 2*segment - 100 - 15) / 3
+	sealedSegment := segment + overhead
+
+	tampered := append([]byte{}, ciphertext...)
+	tampered[len(tampered)/2] ^= 0x01
+	_, err := decryptStream(key, tampered)
+	assert.NotNil(err)
+
+	// dropping whole segments must be detected, not just partial ones
+	_, err = decryptStream(key, ciphertext[:15+2*sealedSegment])
+	assert.NotNil(err)
+	_, err = decryptStream(key, ciphertext[:15+sealedSegment])
+	assert.NotNil(err)
+	_, err = decryptStream(key, ciphertext[:15])
+	assert.NotNil(err)
+
+	header := append([]byte{}, ciphertext...)
+	header[14] ^= 0x01
+	_, err = decryptStream(key, header)
+	assert.NotNil(err)
+
+	_, err = decryptStream(randomBytes(t, 16), ciphertext)
+	assert.NotNil(err)
+}
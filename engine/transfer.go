@@ -0,0 +1,175 @@
+package engine
+
+import (
+	"io"
+	"math/rand"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// Large files are moved in parts, each of which is retried on its own with
+// an exponential backoff, so a network failure only costs the part that
+// was in flight rather than the whole transfer.
+//
+const (
+	transferAttempts    = 6
+	transferConcurrency = 4
+	transferBaseDelay   = 250 * time.Millisecond
+	transferMaxDelay    = 10 * time.Second
+)
+
+//
+// Run an operation until it succeeds, backing off between attempts, and
+// return the last error if every attempt fails.
+//
+func withRetries(operation string, attempt func() error) error {
+	delay := transferBaseDelay
+	for i := 1; ; i++ {
+		err := attempt()
+		if err == nil || i == transferAttempts || !retryable(err) {
+			return err
+		}
+		log.WithFields(log.Fields{
+			"at":        "engine.withRetries",
+			"attempt":   i,
+			"error":     err.Error(),
+			"operation": operation,
+		}).Warn("transfer failed, retrying")
+		time.Sleep(delay + time.Duration(rand.Int63n(int64(delay/2)+1)))
+		delay *= 2
+		if delay > transferMaxDelay {
+			delay = transferMaxDelay
+		}
+	}
+}
+
+//
+// Return true for network and S3 errors that may go away by trying again.
+// Anything else, such as a request for a file that does not exist or a
+// local file that cannot be read, fails straight away.
+//
+func retryable(err error) bool {
+	if failure, ok := err.(awserr.RequestFailure); ok {
+		status := failure.StatusCode()
+		return status >= 500 || status == 408 || status == 429
+	}
+	if request.IsErrorRetryable(err) || request.IsErrorThrottle(err) {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	// a response body cut short by the connection dropping
+	return err == io.ErrUnexpectedEOF
+}
+
+//
+// A RangeStore is a store that can read part of a file, which allows large
+// files to be downloaded as several ranges in parallel.
+//
+type RangeStore interface {
+	Size(key string) (int64, error)
+	OpenRange(key string, offset, length int64) (io.ReadCloser, error)
+	PartSize() int64
+}
+
+//
+// Download a file from a store to a destination, returning the number of
+// bytes written.  Stores that support ranged reads are downloaded in
+// parallel parts, each retried on its own.
+//
+func Download(store Store, key string, destination io.WriterAt) (int64, error) {
+	ranged, ok := store.(RangeStore)
+	if !ok {
+		return downloadSequential(store, key, destination)
+	}
+	size, err := ranged.Size(key)
+	if err != nil {
+		return 0, err
+	}
+	partSize := ranged.PartSize()
+	parts := make(chan int64)
+	errs := make(chan error, transferConcurrency)
+	var wait sync.WaitGroup
+	for i := 0; i < transferConcurrency; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			for offset := range parts {
+				length := partSize
+				if offset+length > size {
+					length = size - offset
+				}
+				err := withRetries("download "+key, func() error {
+					return downloadRange(ranged, key, offset, length, destination)
+				})
+				if err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+	var failure error
+	for offset := int64(0); offset < size && failure == nil; offset += partSize {
+		select {
+		case parts <- offset:
+		case failure = <-errs:
+		}
+	}
+	close(parts)
+	wait.Wait()
+	close(errs)
+	if failure == nil {
+		failure = <-errs
+	}
+	if failure != nil {
+		return 0, failure
+	}
+	return size, nil
+}
+
+func downloadRange(store RangeStore, key string, offset, length int64, destination io.WriterAt) error {
+	body, err := store.OpenRange(key, offset, length)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	part := make([]byte, length)
+	if _, err = io.ReadFull(body, part); err != nil {
+		return err
+	}
+	_, err = destination.WriteAt(part, offset)
+	return err
+}
+
+func downloadSequential(store Store, key string, destination io.WriterAt) (int64, error) {
+	body, err := store.Open(key)
+	if err != nil {
+		return 0, err
+	}
+	defer body.Close()
+	buffer := make([]byte, 32*1024)
+	offset := int64(0)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			if _, writeErr := destination.WriteAt(buffer[:n], offset); writeErr != nil {
+				return offset, writeErr
+			}
+			offset += int64(n)
+		}
+		if err == io.EOF {
+			return offset, nil
+		} else if err != nil {
+			return offset, err
+		}
+	}
+}
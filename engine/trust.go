@@ -0,0 +1,191 @@
+package engine
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+//
+// The investigators trusted for the length of one operation, such as
+// validating an investigation or listing every investigation in the
+// store.  The registry roots, the registry, each investigator, and each
+// revocation are loaded at most once, so checking many signatures does not
+// read and verify them all again for every one.
+//
+type TrustedInvestigators struct {
+	store Store
+	roots *RegistryRoots
+
+	rootsErr    error
+	registry    *Registry
+	registryErr error
+	found       map[string]Investigator
+	lookupErr   map[string]error
+	revocations map[string]*Revocation
+	revokedErr  map[string]error
+}
+
+//
+// Returned when the roots, the registry, an investigator, or a revocation
+// could not be loaded, so it is not yet known whether an investigator is
+// trusted.  Unlike an invalid signature, trying again later may succeed.
+//
+type trustLoadError struct {
+	err error
+}
+
+func (err trustLoadError) Error() string {
+	return err.err.Error()
+}
+
+//
+// Return true if an error means trust could not be decided, rather than
+// that an investigator is not trusted.
+//
+func isTrustLoadError(err error) bool {
+	_, ok := err.(trustLoadError)
+	return ok
+}
+
+//
+// Start an operation trusting the registry roots configured on this
+// machine.
+//
+func NewTrustedInvestigators(store Store) *TrustedInvestigators {
+	roots, err := LoadRegistryRoots()
+	trusted := newTrustedInvestigatorsWithRoots(store, roots)
+	if err != nil {
+		trusted.rootsErr = trustLoadError{err}
+	}
+	return trusted
+}
+
+//
+// Start an operation trusting registry roots that are already loaded, or
+// the investigators directory of the store if roots is nil.
+//
+func newTrustedInvestigatorsWithRoots(store Store, roots *RegistryRoots) *TrustedInvestigators {
+	return &TrustedInvestigators{
+		store:       store,
+		roots:       roots,
+		found:       make(map[string]Investigator),
+		lookupErr:   make(map[string]error),
+		revocations: make(map[string]*Revocation),
+		revokedErr:  make(map[string]error),
+	}
+}
+
+//
+// Return an investigator by name, unless they have been revoked or their
+// revocation could not be checked.
+//
+func (trusted *TrustedInvestigators) byName(name string) (Investigator, error) {
+	investigator, err := trusted.lookup(name)
+	if err != nil {
+		return Investigator{}, err
+	}
+	revocation, revoked, err := trusted.revocation(name)
+	if err != nil {
+		return Investigator{}, err
+	}
+	if revoked {
+		return Investigator{}, errors.New("investigator " + name + " was revoked by " +
+			revocation.Revoker.Name + " at " + revocation.RevokedAt.Format(time.RFC3339))
+	}
+	return investigator, nil
+}
+
+//
+// Return an investigator by name without checking for revocation.
+//
+func (trusted *TrustedInvestigators) lookup(name string) (Investigator, error) {
+	if investigator, ok := trusted.found[name]; ok {
+		return investigator, nil
+	}
+	if err, ok := trusted.lookupErr[name]; ok {
+		return Investigator{}, err
+	}
+	investigator, err := trusted.load(name)
+	if err != nil {
+		trusted.lookupErr[name] = err
+		return Investigator{}, err
+	}
+	trusted.found[name] = investigator
+	return investigator, nil
+}
+
+func (trusted *TrustedInvestigators) load(name string) (Investigator, error) {
+	if trusted.rootsErr != nil {
+		return Investigator{}, trusted.rootsErr
+	}
+	if trusted.roots != nil {
+		registry, err := trusted.loadRegistry()
+		if err != nil {
+			return Investigator{}, err
+		}
+		investigator, ok := registry.Investigator(name)
+		if !ok {
+			return Investigator{}, errors.New("investigator " + name + " is not in the registry")
+		}
+		return investigator, nil
+	}
+	data, err := trusted.store.Get("investigators/" + name + ".json")
+	if isNotFound(err) {
+		return Investigator{}, errors.New("investigator " + name + " not found")
+	} else if err != nil {
+		return Investigator{}, trustLoadError{errors.New("unable to load investigator " + name + ": " + err.Error())}
+	}
+	var investigator Investigator
+	err = json.Unmarshal(data, &investigator)
+	if err != nil {
+		return Investigator{}, errors.New("unable to parse investigator " + name + ": " + err.Error())
+	}
+	if investigator.Name != name {
+		return Investigator{}, errors.New("investigator file for " + name + " names " + investigator.Name)
+	}
+	return investigator, nil
+}
+
+//
+// Load and verify the registry the first time it is needed.
+//
+func (trusted *TrustedInvestigators) loadRegistry() (*Registry, error) {
+	if trusted.registry == nil && trusted.registryErr == nil {
+		registry, err := LoadRegistry(trusted.store, trusted.roots)
+		if err != nil {
+			trusted.registryErr = trustLoadError{err}
+		} else {
+			trusted.registry = &registry
+		}
+	}
+	return trusted.registry, trusted.registryErr
+}
+
+//
+// Return the valid revocation record for an investigator, if there is one.
+//
+func (trusted *TrustedInvestigators) revocation(name string) (Revocation, bool, error) {
+	if err, ok := trusted.revokedErr[name]; ok {
+		return Revocation{}, false, err
+	}
+	revocation, ok := trusted.revocations[name]
+	if !ok {
+		loaded, revoked, err := loadRevocation(trusted, name)
+		if err != nil {
+			if !isTrustLoadError(err) {
+				err = trustLoadError{err}
+			}
+			trusted.revokedErr[name] = err
+			return Revocation{}, false, err
+		}
+		if revoked {
+			revocation = &loaded
+		}
+		trusted.revocations[name] = revocation
+	}
+	if revocation == nil {
+		return Revocation{}, false, nil
+	}
+	return *revocation, true, nil
+}
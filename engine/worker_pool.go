@@ -0,0 +1,172 @@
+package engine
+
+import (
+	"sync"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//
+// A worker pool validates, runs, reports, and cleans up investigations
+// using a bounded number of goroutines.
+//
+// Destructive steps are serialized against everything else: every
+// investigation holds a read lock on the pool while it is in flight, and
+// killing containers or the host requires the write lock.  This ensures
+// destructive steps only happen after all in-flight work has finished,
+// and that no new work starts until they are done.
+//
+// As an investigation progresses, each worker uploads a status record
+// signed with the host's identity.  The registry roots, approval policy,
+// and host policy are loaded once when the daemon starts.
+//
+type workerPool struct {
+	store       Store
+	identity    *HostIdentity
+	ledger      *Ledger
+	roots       *RegistryRoots
+	policy      *Policy
+	hostPolicy  *HostPolicy
+	evaluated   func(id string, evaluation Evaluation)
+	queue       chan Investigation
+	destructive sync.RWMutex
+	workers     sync.WaitGroup
+}
+
+//
+// Create a worker pool and start its workers.  The evaluated function is
+// called once each investigation is done with, reporting whether it is
+// settled, still waiting for approvals, or could not be checked and should
+// be tried again: as soon as it fails validation, or after an investigation
+// that ran has been reported.
+//
+func newWorkerPool(store Store, identity *HostIdentity, ledger *Ledger, roots *RegistryRoots, policy *Policy, hostPolicy *HostPolicy, size int, evaluated func(string, Evaluation)) *workerPool {
+	pool := &workerPool{
+		store:      store,
+		identity:   identity,
+		ledger:     ledger,
+		roots:      roots,
+		policy:     policy,
+		hostPolicy: hostPolicy,
+		evaluated:  evaluated,
+		queue:      make(chan Investigation),
+	}
+	for i := 0; i < size; i++ {
+		pool.workers.Add(1)
+		go pool.work()
+	}
+	return pool
+}
+
+//
+// Hand an investigation to the next free worker, blocking until one
+// is available.
+//
+func (pool *workerPool) submit(investigation Investigation) {
+	pool.queue <- investigation
+}
+
+//
+// Stop accepting investigations and wait for in-flight work to finish.
+//
+func (pool *workerPool) close() {
+	close(pool.queue)
+	pool.workers.Wait()
+}
+
+func (pool *workerPool) work() {
+	defer pool.workers.Done()
+	for investigation := range pool.queue {
+		pool.process(investigation)
+	}
+}
+
+func (pool *workerPool) process(investigation Investigation) {
+	if !pool.investigate(&investigation) {
+		return
+	}
+	if investigation.destructive() {
+		pool.destructive.Lock()
+		log.WithFields(log.Fields{
+			"at":            "engine.process",
+			"investigation": investigation.ID,
+		}).Info("in-flight investigations finished, running destructive steps")
+		investigation.destroy()
+		pool.destructive.Unlock()
+	}
+}
+
+//
+// Run everything but the destructive steps of an investigation, returning
+// true if the investigation ran on this host.
+//
+func (pool *workerPool) investigate(investigation *Investigation) bool {
+	pool.destructive.RLock()
+	defer pool.destructive.RUnlock()
+
+	investigation.postStatus(pool.store, pool.identity, StatusReceived, "")
+	err := investigation.validate(newTrustedInvestigatorsWithRoots(pool.store, pool.roots), pool.policy, pool.hostPolicy)
+	if isTrustLoadError(err) {
+		// whether to trust it is not known yet, so try again later
+		// rather than refusing it for good
+		pool.evaluated(investigation.ID, EvaluationRetry)
+		log.WithFields(log.Fields{
+			"at":            "engine.investigate",
+			"investigation": investigation.ID,
+		}).Error("unable to check investigation, will retry: " + err.Error())
+		return false
+	}
+	if err != nil {
+		if err == errAwaitingConsensus {
+			pool.evaluated(investigation.ID, EvaluationAwaitingConsensus)
+		} else {
+			pool.evaluated(investigation.ID, EvaluationSettled)
+		}
+		log.WithFields(log.Fields{
+			"at":            "engine.investigate",
+			"investigation": investigation.ID,
+		}).Error(err)
+		switch err := err.(type) {
+		case outOfScopeError:
+			investigation.postStatus(pool.store, pool.identity, StatusOutOfScope, err.fact)
+		case hostPolicyError:
+			investigation.postStatus(pool.store, pool.identity, StatusRejected, err.reason)
+		default:
+			if err == errAwaitingConsensus {
+				investigation.postStatus(pool.store, pool.identity, StatusAwaitingConsensus, "")
+			} else {
+				investigation.postStatus(pool.store, pool.identity, StatusFailed, err.Error())
+			}
+		}
+		return false
+	}
+
+	// settle the investigation only once it has been reported, so a copy
+	// interrupted by a restart is sent again and refused by the ledger
+	defer pool.evaluated(investigation.ID, EvaluationSettled)
+
+	// record the run before starting it, so a copy of this investigation
+	// can never run on this host again
+	execution, err := pool.ledger.Claim(*investigation)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":            "engine.investigate",
+			"investigation": investigation.ID,
+		}).Error(err)
+		investigation.postStatus(pool.store, pool.identity, StatusFailed, err.Error())
+		return false
+	}
+
+	investigation.postStatus(pool.store, pool.identity, StatusRunning, "")
+	runs := investigation.run(pool.hostPolicy)
+	// recipients are looked up again, since one may have been revoked
+	// while the tasks ran
+	err = investigation.report(newTrustedInvestigatorsWithRoots(pool.store, pool.roots), pool.identity, execution, runs)
+	if err != nil {
+		investigation.postStatus(pool.store, pool.identity, StatusFailed, "unable to upload report: "+err.Error())
+	} else {
+		investigation.postStatus(pool.store, pool.identity, StatusUploaded, "")
+	}
+	investigation.cleanup()
+	return true
+}
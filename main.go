@@ -8,6 +8,7 @@ import (
 	"github.com/coinbase/dexter/cli/daemon"
 	"github.com/coinbase/dexter/cli/investigation"
 	"github.com/coinbase/dexter/cli/investigator"
+	"github.com/coinbase/dexter/cli/registry"
 	"github.com/coinbase/dexter/cli/report"
 	"github.com/coinbase/dexter/engine/helpers"
 
@@ -41,9 +42,6 @@ and reporting of forensics tasks on remote hosts.  This
 program contains functionallity to run a Dexter daemon
 as well as interact with Dexter from the command line as an
 investigator.`,
-		PersistentPreRun: func(cmd *cobra.Command, args []string) {
-			helpers.BuildDemoPath()
-		},
 	}
 
 	rootCmd.AddCommand(&cobra.Command{
@@ -66,6 +64,7 @@ investigator.`,
 	rootCmd.AddCommand(investigator.CommandSuite())
 	rootCmd.AddCommand(investigation.CommandSuite())
 	rootCmd.AddCommand(report.CommandSuite())
+	rootCmd.AddCommand(registry.CommandSuite())
 
 	rootCmd.PersistentFlags().StringVar(&helpers.LocalDemoPath, "demo", "", "run fom a local path for demo purposes, not S3")
 
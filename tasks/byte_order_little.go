@@ -0,0 +1,12 @@
+//go:build !ppc64 && !s390x && !mips && !mips64
+// +build !ppc64,!s390x,!mips,!mips64
+
+package tasks
+
+import "encoding/binary"
+
+//
+// The byte order of this host, which the kernel uses for the addresses in
+// its socket tables.
+//
+var hostByteOrder binary.ByteOrder = binary.LittleEndian
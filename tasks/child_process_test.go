@@ -0,0 +1,68 @@
+package tasks_test
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+var childMarker []byte
+
+//
+// Not a real test: run as a child process by the tests of tasks that
+// inspect other processes.  DEXTER_TEST_CHILD chooses what it does before
+// it prints one line and waits to be killed: "memory" holds a marker in
+// memory and prints it, and "listen" listens on a TCP port and prints it.
+//
+func TestHelperProcess(t *testing.T) {
+	switch os.Getenv("DEXTER_TEST_CHILD") {
+	case "":
+		return
+	case "memory":
+		marker := fmt.Sprintf("dexter-marker-%d-%d", os.Getpid(), time.Now().UnixNano())
+		childMarker = []byte(marker)
+		fmt.Println(marker)
+	case "listen":
+		listener, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			os.Exit(1)
+		}
+		fmt.Println(listener.Addr().(*net.TCPAddr).Port)
+	default:
+		os.Exit(1)
+	}
+	time.Sleep(time.Minute)
+	os.Exit(0)
+}
+
+//
+// Start the helper process as a child, returning it with the line it
+// printed and a function that kills it.
+//
+func startChild(t *testing.T, mode string) (*exec.Cmd, string, func()) {
+	child := exec.Command(os.Args[0], "-test.run=^TestHelperProcess$")
+	child.Env = append(os.Environ(), "DEXTER_TEST_CHILD="+mode)
+	stdout, err := child.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = child.Start()
+	if err != nil {
+		t.Fatal(err)
+	}
+	kill := func() {
+		child.Process.Kill()
+		child.Wait()
+	}
+	line, err := bufio.NewReader(stdout).ReadString('\n')
+	if err != nil {
+		kill()
+		t.Fatal("the child process did not start: ", err)
+	}
+	return child, strings.TrimSpace(line), kill
+}
@@ -52,8 +52,8 @@ type containerChangeSet struct {
 	Changes   []containerFilesystemChange
 }
 
-func exportContainerFilesystemDiffReport(_ []string, writer *ArtifactWriter) {
-	allContainers, err := docker.API().ContainerList(context.Background(), types.ContainerListOptions{})
+func exportContainerFilesystemDiffReport(ctx context.Context, _ []string, writer *ArtifactWriter) {
+	allContainers, err := docker.API().ContainerList(ctx, types.ContainerListOptions{})
 	if err != nil {
 		errstr := "unable to list containers for task"
 		log.WithFields(log.Fields{
@@ -64,7 +64,11 @@ func exportContainerFilesystemDiffReport(_ []string, writer *ArtifactWriter) {
 		return
 	}
 	for _, container := range allContainers {
-		report, err := containerDiff(writer, docker.API(), container)
+		if ctx.Err() != nil {
+			writer.Error("stopped collecting container diffs: " + ctx.Err().Error())
+			return
+		}
+		report, err := containerDiff(ctx, writer, docker.API(), container)
 		if err != nil {
 			errstr := "error creating container diff"
 			log.WithFields(log.Fields{
@@ -74,7 +78,7 @@ func exportContainerFilesystemDiffReport(_ []string, writer *ArtifactWriter) {
 			writer.Error(errstr + ": " + err.Error())
 			continue
 		}
-		zipChanges(writer, report)
+		zipChanges(ctx, writer, report)
 	}
 }
 
@@ -90,7 +94,7 @@ func containsRemovedOrAdded(report containerChangeSet) bool {
 	return false
 }
 
-func zipChanges(writer *ArtifactWriter, report containerChangeSet) {
+func zipChanges(ctx context.Context, writer *ArtifactWriter, report containerChangeSet) {
 	// Write a high-level manifest of the changes
 	writeContainerManifest(writer, report)
 
@@ -98,7 +102,7 @@ func zipChanges(writer *ArtifactWriter, report containerChangeSet) {
 	tmpContainer := ""
 	if containsRemovedOrAdded(report) {
 		var err error
-		tmpContainer, err = startOriginalContainer(writer, docker.API(), report.Container.Image)
+		tmpContainer, err = startOriginalContainer(ctx, writer, docker.API(), report.Container.Image)
 		if err != nil {
 			errstr := "error starting original container for image"
 			log.WithFields(log.Fields{
@@ -114,11 +118,11 @@ func zipChanges(writer *ArtifactWriter, report containerChangeSet) {
 	for _, change := range report.Changes {
 		switch change.DiffType {
 		case containerDiffAdded:
-			writeAddedFile(writer, report.Container.ID, change)
+			writeAddedFile(ctx, writer, report.Container.ID, change)
 		case containerDiffRemoved:
-			writeRemovedFile(writer, report.Container.ID, tmpContainer, change)
+			writeRemovedFile(ctx, writer, report.Container.ID, tmpContainer, change)
 		case containerDiffModified:
-			writeModifiedFile(writer, report.Container.ID, tmpContainer, change)
+			writeModifiedFile(ctx, writer, report.Container.ID, tmpContainer, change)
 		}
 	}
 
@@ -128,8 +132,8 @@ func zipChanges(writer *ArtifactWriter, report containerChangeSet) {
 	}
 }
 
-func writeAddedFile(writer *ArtifactWriter, container string, change containerFilesystemChange) {
-	data, err := extractFile(writer, docker.API(), change.Path, container)
+func writeAddedFile(ctx context.Context, writer *ArtifactWriter, container string, change containerFilesystemChange) {
+	data, err := extractFile(ctx, writer, docker.API(), change.Path, container)
 	if err != nil {
 		errstr := "error extracting file from container"
 		log.WithFields(log.Fields{
@@ -141,11 +145,11 @@ func writeAddedFile(writer *ArtifactWriter, container string, change containerFi
 		writer.Error(errstr + " (" + container + " " + change.Path + ") :" + err.Error())
 		return
 	}
-	writer.Write(container+"/added"+change.Path, data)
+	writer.WriteFrom(container+"/added"+change.Path, "container "+container+":"+change.Path, data)
 }
 
-func writeRemovedFile(writer *ArtifactWriter, container, tmpContainer string, change containerFilesystemChange) {
-	data, err := extractFile(writer, docker.API(), change.Path, tmpContainer)
+func writeRemovedFile(ctx context.Context, writer *ArtifactWriter, container, tmpContainer string, change containerFilesystemChange) {
+	data, err := extractFile(ctx, writer, docker.API(), change.Path, tmpContainer)
 	if err != nil {
 		errstr := "error extracting file from container"
 		log.WithFields(log.Fields{
@@ -157,12 +161,12 @@ func writeRemovedFile(writer *ArtifactWriter, container, tmpContainer string, ch
 		writer.Error(errstr + " (" + container + " " + change.Path + ") :" + err.Error())
 		return
 	}
-	writer.Write(container+"/removed"+change.Path, data)
+	writer.WriteFrom(container+"/removed"+change.Path, "image of container "+container+":"+change.Path, data)
 }
 
-func writeModifiedFile(writer *ArtifactWriter, container, tmpContainer string, change containerFilesystemChange) {
+func writeModifiedFile(ctx context.Context, writer *ArtifactWriter, container, tmpContainer string, change containerFilesystemChange) {
 	// Extract modified file
-	mdata, err := extractFile(writer, docker.API(), change.Path, container)
+	mdata, err := extractFile(ctx, writer, docker.API(), change.Path, container)
 	if err != nil {
 		errstr := "error extracting modified file from container"
 		log.WithFields(log.Fields{
@@ -173,10 +177,10 @@ func writeModifiedFile(writer *ArtifactWriter, container, tmpContainer string, c
 		}).Error(errstr)
 		writer.Error(errstr + " (" + container + " " + change.Path + ") :" + err.Error())
 	} else {
-		writer.Write(container+"/modified"+change.Path, mdata)
+		writer.WriteFrom(container+"/modified"+change.Path, "container "+container+":"+change.Path, mdata)
 	}
 	// Extract original file
-	odata, err := extractFile(writer, docker.API(), change.Path, tmpContainer)
+	odata, err := extractFile(ctx, writer, docker.API(), change.Path, tmpContainer)
 	if err != nil {
 		errstr := "error extracting original file from container"
 		log.WithFields(log.Fields{
@@ -187,12 +191,12 @@ func writeModifiedFile(writer *ArtifactWriter, container, tmpContainer string, c
 		}).Error(errstr)
 		writer.Error(errstr + " (" + container + " " + change.Path + ") :" + err.Error())
 	} else {
-		writer.Write(container+"/modified"+change.Path+".original", odata)
+		writer.WriteFrom(container+"/modified"+change.Path+".original", "image of container "+container+":"+change.Path, odata)
 	}
 }
 
-func extractFile(writer *ArtifactWriter, cli *client.Client, path, container string) ([]byte, error) {
-	readCloser, _, err := cli.CopyFromContainer(context.TODO(), container, path)
+func extractFile(ctx context.Context, writer *ArtifactWriter, cli *client.Client, path, container string) ([]byte, error) {
+	readCloser, _, err := cli.CopyFromContainer(ctx, container, path)
 	if err != nil {
 		errstr := "unable to pull file out of container"
 		log.WithFields(log.Fields{
@@ -243,9 +247,9 @@ func extractFile(writer *ArtifactWriter, cli *client.Client, path, container str
 	return buffer.Bytes(), nil
 }
 
-func startOriginalContainer(writer *ArtifactWriter, cli *client.Client, image string) (string, error) {
+func startOriginalContainer(ctx context.Context, writer *ArtifactWriter, cli *client.Client, image string) (string, error) {
 	response, err := cli.ContainerCreate(
-		context.TODO(),
+		ctx,
 		&container.Config{
 			Entrypoint:      strslice.StrSlice{"/bin/sleep", "900"},
 			Healthcheck:     &container.HealthConfig{Test: []string{"NONE"}},
@@ -293,8 +297,8 @@ func writeContainerManifest(writer *ArtifactWriter, report containerChangeSet) {
 	writer.Write(manifestFile, manifestData)
 }
 
-func containerDiff(writer *ArtifactWriter, cli *client.Client, container types.Container) (changeSet containerChangeSet, err error) {
-	responses, err := cli.ContainerDiff(context.Background(), container.ID)
+func containerDiff(ctx context.Context, writer *ArtifactWriter, cli *client.Client, container types.Container) (changeSet containerChangeSet, err error) {
+	responses, err := cli.ContainerDiff(ctx, container.ID)
 	if err != nil {
 		errstr := "error calling cli.containerDiff"
 		log.WithFields(log.Fields{
@@ -309,7 +313,7 @@ func containerDiff(writer *ArtifactWriter, cli *client.Client, container types.C
 	changeSet.Container = container
 
 	for _, response := range responses {
-		pathStat, serr := cli.ContainerStatPath(context.Background(), container.ID, response.Path)
+		pathStat, serr := cli.ContainerStatPath(ctx, container.ID, response.Path)
 
 		// Not found errors are expected on removed files
 		if response.Kind != containerDiffRemoved && serr != nil {
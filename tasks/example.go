@@ -1,6 +1,9 @@
 package tasks
 
 import (
+	"context"
+	"time"
+
 	"github.com/coinbase/dexter/util"
 
 	log "github.com/sirupsen/logrus"
@@ -25,6 +28,10 @@ func init() {
 		// Define how many investigators need to sign an investigation containing this task
 		ConsensusRequirement: 1,
 
+		// Limit how long your task may run before it is abandoned and the timeout is
+		// recorded in the report's errors.  Omit this to use the daemon's default.
+		Timeout: 10 * time.Minute,
+
 		// supportedPlatforms contains valid values for go's runtime.GOOS
 		// If this is omitted, the default value is all platforms.
 		//
@@ -44,6 +51,11 @@ func init() {
 // supported platforms definition, this function will not be
 // called, and an error will be logged.
 //
+// The context is cancelled if your task runs past its deadline.
+// Tasks that may take a long time should check ctx.Err() and
+// stop early, as anything written after the deadline is
+// discarded.
+//
 // The arguments are an arbitrary-length slice of strings
 // entered by the investigator who created the investigation.
 // This lets you scope your task to something more specific,
@@ -54,7 +66,7 @@ func init() {
 // within the report the artifact should be written to, and the
 // second argument is the data.
 //
-func exampleActionFunction(arguments []string, writer *ArtifactWriter) {
+func exampleActionFunction(ctx context.Context, arguments []string, writer *ArtifactWriter) {
 	//
 	// Logging is a good idea, and the logrus package makes
 	// detailed logging easy!
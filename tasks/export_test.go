@@ -0,0 +1,17 @@
+package tasks
+
+import (
+	"context"
+	"time"
+)
+
+//
+// Build a task that runs an action, for testing how tasks are run.
+//
+func NewTestTask(name string, timeout time.Duration, action func(context.Context, []string, *ArtifactWriter)) Task {
+	return Task{
+		Name:           name,
+		Timeout:        timeout,
+		actionFunction: action,
+	}
+}
@@ -0,0 +1,41 @@
+package tasks
+
+import (
+	"os"
+	"os/user"
+	"strconv"
+	"time"
+)
+
+//
+// Return the metadata of a file on the host, as recorded in the manifest
+// when it is collected.
+//
+func fileMetadata(info os.FileInfo) *FileMetadata {
+	metadata := &FileMetadata{
+		Mode:       info.Mode().String(),
+		ModifiedAt: info.ModTime().UTC(),
+	}
+	addPlatformMetadata(info, metadata)
+	return metadata
+}
+
+//
+// Record the owner of a file, looking up the names of its user and group
+// where the host knows them.
+//
+func setOwnership(metadata *FileMetadata, uid, gid int) {
+	metadata.UID = &uid
+	metadata.GID = &gid
+	if owner, err := user.LookupId(strconv.Itoa(uid)); err == nil {
+		metadata.Owner = owner.Username
+	}
+	if group, err := user.LookupGroupId(strconv.Itoa(gid)); err == nil {
+		metadata.Group = group.Name
+	}
+}
+
+func utcTime(sec, nsec int64) *time.Time {
+	t := time.Unix(sec, nsec).UTC()
+	return &t
+}
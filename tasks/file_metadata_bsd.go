@@ -0,0 +1,19 @@
+//go:build darwin || freebsd || netbsd
+// +build darwin freebsd netbsd
+
+package tasks
+
+import (
+	"os"
+	"syscall"
+)
+
+func addPlatformMetadata(info os.FileInfo, metadata *FileMetadata) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	setOwnership(metadata, int(stat.Uid), int(stat.Gid))
+	metadata.AccessedAt = utcTime(int64(stat.Atimespec.Sec), int64(stat.Atimespec.Nsec))
+	metadata.ChangedAt = utcTime(int64(stat.Ctimespec.Sec), int64(stat.Ctimespec.Nsec))
+}
@@ -0,0 +1,16 @@
+package tasks
+
+import (
+	"os"
+	"syscall"
+)
+
+func addPlatformMetadata(info os.FileInfo, metadata *FileMetadata) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return
+	}
+	setOwnership(metadata, int(stat.Uid), int(stat.Gid))
+	metadata.AccessedAt = utcTime(int64(stat.Atim.Sec), int64(stat.Atim.Nsec))
+	metadata.ChangedAt = utcTime(int64(stat.Ctim.Sec), int64(stat.Ctim.Nsec))
+}
@@ -0,0 +1,14 @@
+//go:build !linux && !darwin && !freebsd && !netbsd
+// +build !linux,!darwin,!freebsd,!netbsd
+
+package tasks
+
+import (
+	"os"
+)
+
+//
+// Only the mode and modification time of files are recorded on this
+// platform.
+//
+func addPlatformMetadata(info os.FileInfo, metadata *FileMetadata) {}
@@ -1,8 +1,14 @@
 package tasks
 
 import (
-	"io/ioutil"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
 
+	"github.com/coinbase/dexter/engine/helpers"
 	"github.com/coinbase/dexter/util"
 
 	log "github.com/sirupsen/logrus"
@@ -11,7 +17,7 @@ import (
 func init() {
 	add(Task{
 		Name:                 "get-file",
-		Description:          "retrieve files from host",
+		Description:          "retrieve files, globs of files, or directories from host",
 		MinimumArguments:     1,
 		ConsensusRequirement: 1,
 		supportedPlatforms:   util.AllPlatforms,
@@ -19,7 +25,25 @@ func init() {
 	})
 }
 
-func getFile(arguments []string, writer *ArtifactWriter) {
+//
+// The state of one run of get-file: the files already collected, and how
+// much more may be collected before the total limit is reached.
+//
+type fileCollector struct {
+	ctx       context.Context
+	writer    *ArtifactWriter
+	collected map[string]bool
+	maxFile   int64
+	remaining int64
+}
+
+//
+// Collect each argument into the report.  Arguments may be files, glob
+// patterns, or directories, which are collected recursively.  Files are
+// streamed into the report, and limited in size by the daemon's
+// configuration.
+//
+func getFile(ctx context.Context, arguments []string, writer *ArtifactWriter) {
 
 	log.WithFields(log.Fields{
 		"at":        "tasks.getFile",
@@ -27,17 +51,139 @@ func getFile(arguments []string, writer *ArtifactWriter) {
 		"arguments": arguments,
 	}).Info("retrieving files")
 
+	collector := &fileCollector{
+		ctx:       ctx,
+		writer:    writer,
+		collected: map[string]bool{},
+		maxFile:   int64(helpers.GetFileMaxFileMB()) << 20,
+		remaining: int64(helpers.GetFileMaxTotalMB()) << 20,
+	}
 	for _, arg := range arguments {
-		bytes, err := ioutil.ReadFile(arg)
+		if ctx.Err() != nil {
+			writer.Error("stopped retrieving files: " + ctx.Err().Error())
+			return
+		}
+		matches := []string{arg}
+		if strings.ContainsAny(arg, "*?[") {
+			var err error
+			matches, err = filepath.Glob(arg)
+			if err != nil {
+				writer.Error("invalid pattern " + arg + ": " + err.Error())
+				continue
+			}
+			if len(matches) == 0 {
+				writer.Error("no files match " + arg)
+				continue
+			}
+		}
+		for _, match := range matches {
+			collector.collect(match)
+		}
+	}
+}
+
+//
+// Collect a file, or every file below a directory.  Symlinks found inside
+// directories are recorded but not followed.
+//
+func (collector *fileCollector) collect(name string) {
+	info, err := os.Stat(name)
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "tasks.getFile",
+			"file":  name,
+			"error": err.Error(),
+		}).Error("error reading file")
+		collector.writer.Error("error reading file: " + name)
+		return
+	}
+	if !info.IsDir() {
+		collector.collectFile(name, info)
+		return
+	}
+	err = filepath.Walk(name, func(file string, info os.FileInfo, err error) error {
+		if collector.ctx.Err() != nil {
+			return collector.ctx.Err()
+		}
 		if err != nil {
-			log.WithFields(log.Fields{
-				"at":   "tasks.getFile",
-				"path": writer.path,
-				"file": arg,
-			}).Error("error reading file")
-			writer.Error("error reading file: " + arg)
-		} else {
-			writer.Write(arg, bytes)
+			collector.writer.Error("error reading " + file + ": " + err.Error())
+			return nil
 		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			target, _ := os.Readlink(file)
+			collector.writer.Error("skipped symlink " + file + " to " + target)
+			return nil
+		}
+		if !info.IsDir() {
+			collector.collectFile(file, info)
+		}
+		return nil
+	})
+	if err != nil {
+		collector.writer.Error("stopped retrieving files: " + err.Error())
+	}
+}
+
+//
+// Stream a regular file into the report, under the path it has on the host.
+//
+func (collector *fileCollector) collectFile(name string, info os.FileInfo) {
+	if collector.collected[name] {
+		return
+	}
+	collector.collected[name] = true
+	if !info.Mode().IsRegular() {
+		collector.writer.Error("skipped " + name + ", it is not a regular file")
+		return
+	}
+	if !pathAllowed(collector.ctx, name) {
+		collector.writer.Error("skipped " + name + ", the host policy does not allow collecting it")
+		return
+	}
+	if collector.remaining <= 0 {
+		collector.writer.Error("skipped " + name + ", the total size limit has been reached")
+		return
+	}
+	limit := collector.maxFile
+	if collector.remaining < limit {
+		limit = collector.remaining
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		collector.writer.Error("error reading file: " + name)
+		return
+	}
+	defer file.Close()
+	src := &contextReader{ctx: collector.ctx, reader: io.LimitReader(file, limit)}
+	size, err := collector.writer.WriteStream(filepath.ToSlash(name), name, src, fileMetadata(info))
+	collector.remaining -= size
+	if err != nil {
+		log.WithFields(log.Fields{
+			"at":    "tasks.getFile",
+			"file":  name,
+			"error": err.Error(),
+		}).Error("error collecting file")
+		collector.writer.Error("error collecting file " + name + ": " + err.Error())
+		return
+	}
+	if size == limit && info.Size() > limit {
+		collector.writer.Error(name + " was truncated to " + strconv.FormatInt(limit, 10) + " of its " + strconv.FormatInt(info.Size(), 10) + " bytes by the size limit")
+	}
+}
+
+//
+// A reader that stops once its context is done, so copying a large file
+// does not outlive the task.
+//
+type contextReader struct {
+	ctx    context.Context
+	reader io.Reader
+}
+
+func (reader *contextReader) Read(p []byte) (int, error) {
+	if err := reader.ctx.Err(); err != nil {
+		return 0, err
 	}
+	return reader.reader.Read(p)
 }
@@ -0,0 +1,62 @@
+package tasks_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/dexter/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGetFileCollectsGlobsAndDirectories(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "dexter-get-file")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	dir, err = filepath.EvalSymlinks(dir)
+	assert.Nil(err)
+	evidence := filepath.Join(dir, "evidence")
+	assert.Nil(os.MkdirAll(filepath.Join(evidence, "nested"), 0700))
+	assert.Nil(ioutil.WriteFile(filepath.Join(evidence, "a.log"), []byte("a"), 0600))
+	assert.Nil(ioutil.WriteFile(filepath.Join(evidence, "b.txt"), []byte("b"), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(evidence, "nested", "c.log"), []byte("c"), 0644))
+	assert.Nil(ioutil.WriteFile(filepath.Join(evidence, "nested", "large.log"), make([]byte, 3<<20), 0644))
+	assert.Nil(os.Symlink("/etc/hosts", filepath.Join(evidence, "nested", "hosts.log")))
+	os.Setenv("DEXTER_GET_FILE_MAX_FILE_MB", "2")
+	defer os.Unsetenv("DEXTER_GET_FILE_MAX_FILE_MB")
+
+	report := filepath.Join(dir, "report") + "/"
+	task := tasks.Tasks["get-file"]
+	run := task.Run(context.Background(), report, []string{evidence + "/*.log", evidence + "/nested", evidence + "/*.csv"})
+	collected := map[string]tasks.Artifact{}
+	for _, artifact := range run.Artifacts {
+		collected[artifact.Source] = artifact
+	}
+	assert.Len(collected, 4, "three files and the errors file")
+	assert.Equal(int64(2<<20), collected[filepath.Join(evidence, "nested", "large.log")].Size)
+	assert.Len(run.Errors, 3, "the truncated file, the symlink, and the pattern matching nothing")
+	a := collected[filepath.Join(evidence, "a.log")]
+	assert.NotNil(a.Metadata)
+	assert.Equal("-rw-------", a.Metadata.Mode)
+	if runtime.GOOS == "linux" || runtime.GOOS == "darwin" {
+		assert.Equal(os.Getuid(), *a.Metadata.UID)
+		assert.NotNil(a.Metadata.AccessedAt)
+	}
+
+	// the path filter applies to every file a directory expands to
+	ctx := tasks.WithPathFilter(context.Background(), func(path string) bool {
+		return strings.HasPrefix(path, evidence+"/") && strings.HasSuffix(path, ".log")
+	})
+	run = task.Run(ctx, filepath.Join(dir, "filtered")+"/", []string{evidence})
+	sources := []string{}
+	for _, artifact := range run.Artifacts {
+		sources = append(sources, artifact.Source)
+	}
+	assert.NotContains(sources, filepath.Join(evidence, "b.txt"))
+	assert.Contains(sources, filepath.Join(evidence, "nested", "c.log"))
+}
@@ -0,0 +1,443 @@
+package tasks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	add(Task{
+		Name:                 "live-response",
+		Description:          "snapshot running processes, their open files, and network and unix sockets, without osquery",
+		ConsensusRequirement: 1,
+		supportedPlatforms:   []string{"linux"},
+		actionFunction:       liveResponse,
+	})
+}
+
+//
+// A running process, as found in /proc.
+//
+type liveProcess struct {
+	processInfo
+	PPID             int
+	Children         []int `json:",omitempty"`
+	State            string
+	UID              []int
+	GID              []int
+	StartedAt        *time.Time       `json:",omitempty"`
+	Cwd              string           `json:",omitempty"`
+	ExecutableSHA256 string           `json:",omitempty"`
+	Environment      []string         `json:",omitempty"`
+	FileDescriptors  []fileDescriptor `json:",omitempty"`
+	NetNamespace     string           `json:",omitempty"`
+	Errors           []string         `json:",omitempty"`
+	socketInodes     map[string]bool
+}
+
+//
+// An open file descriptor and what it refers to.
+//
+type fileDescriptor struct {
+	FD     int
+	Target string
+}
+
+//
+// A TCP, UDP or unix socket, and the processes holding it open.
+//
+type liveSocket struct {
+	Protocol      string
+	NetNamespace  string `json:",omitempty"`
+	LocalAddress  string `json:",omitempty"`
+	LocalPort     int    `json:",omitempty"`
+	RemoteAddress string `json:",omitempty"`
+	RemotePort    int    `json:",omitempty"`
+	Type          string `json:",omitempty"`
+	State         string
+	Path          string `json:",omitempty"`
+	UID           *int   `json:",omitempty"`
+	Inode         string
+	PIDs          []int `json:",omitempty"`
+}
+
+var tcpStates = map[string]string{
+	"01": "ESTABLISHED",
+	"02": "SYN_SENT",
+	"03": "SYN_RECV",
+	"04": "FIN_WAIT1",
+	"05": "FIN_WAIT2",
+	"06": "TIME_WAIT",
+	"07": "CLOSE",
+	"08": "CLOSE_WAIT",
+	"09": "LAST_ACK",
+	"0A": "LISTEN",
+	"0B": "CLOSING",
+}
+
+var unixTypes = map[string]string{
+	"0001": "STREAM",
+	"0002": "DGRAM",
+	"0005": "SEQPACKET",
+}
+
+var unixStates = map[string]string{
+	"01": "UNCONNECTED",
+	"02": "CONNECTING",
+	"03": "CONNECTED",
+	"04": "DISCONNECTING",
+}
+
+//
+// Snapshot every process on the host and the sockets they hold, writing
+// them to processes.json and sockets.json.  Sockets are read from each
+// network namespace processes are found in, so sockets inside containers
+// are included.
+//
+func liveResponse(ctx context.Context, arguments []string, writer *ArtifactWriter) {
+	log.WithFields(log.Fields{
+		"at":   "tasks.liveResponse",
+		"path": writer.path,
+	}).Info("taking live response snapshot")
+
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		writer.Error("unable to list processes: " + err.Error())
+		return
+	}
+	bootTime := readBootTime()
+	hashes := map[string]string{}
+	processes := []*liveProcess{}
+	byPID := map[int]*liveProcess{}
+	for _, entry := range entries {
+		if ctx.Err() != nil {
+			writer.Error("stopped taking snapshot: " + ctx.Err().Error())
+			return
+		}
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		process, err := readLiveProcess(pid, bootTime, hashes)
+		if err != nil {
+			// the process exited while the snapshot was being taken
+			continue
+		}
+		processes = append(processes, process)
+		byPID[pid] = process
+	}
+	for _, process := range processes {
+		if parent, ok := byPID[process.PPID]; ok {
+			parent.Children = append(parent.Children, process.PID)
+		}
+	}
+
+	// the namespaces of processes that cannot be inspected are unknown,
+	// and are not read in case they repeat one already read
+	self, _ := os.Readlink("/proc/self/ns/net")
+	sockets, err := readSockets("/proc/self", self)
+	if err != nil {
+		writer.Error("unable to read sockets: " + err.Error())
+	}
+	namespaces := map[string]bool{self: true}
+	for _, process := range processes {
+		if ctx.Err() != nil {
+			writer.Error("stopped taking snapshot: " + ctx.Err().Error())
+			return
+		}
+		if process.NetNamespace == "" || namespaces[process.NetNamespace] {
+			continue
+		}
+		namespaces[process.NetNamespace] = true
+		found, err := readSockets("/proc/"+strconv.Itoa(process.PID), process.NetNamespace)
+		if err != nil {
+			writer.Error("unable to read sockets of network namespace " + process.NetNamespace + ": " + err.Error())
+		}
+		sockets = append(sockets, found...)
+	}
+	for _, socket := range sockets {
+		for _, process := range processes {
+			if process.socketInodes[socket.Inode] && (socket.Protocol == "unix" || process.NetNamespace == socket.NetNamespace) {
+				socket.PIDs = append(socket.PIDs, process.PID)
+			}
+		}
+	}
+
+	writeJSON(writer, "processes.json", processes)
+	writeJSON(writer, "sockets.json", sockets)
+}
+
+func writeJSON(writer *ArtifactWriter, name string, value interface{}) {
+	data, err := json.MarshalIndent(value, "", "  ")
+	if err != nil {
+		writer.Error("unable to encode " + name + ": " + err.Error())
+		return
+	}
+	writer.WriteFrom(name, "/proc", data)
+}
+
+//
+// Read the time the host booted, which process start times are relative to.
+//
+func readBootTime() time.Time {
+	data, err := ioutil.ReadFile("/proc/stat")
+	if err != nil {
+		return time.Time{}
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "btime ") {
+			seconds, err := strconv.ParseInt(strings.TrimSpace(line[len("btime "):]), 10, 64)
+			if err == nil {
+				return time.Unix(seconds, 0).UTC()
+			}
+		}
+	}
+	return time.Time{}
+}
+
+//
+// Read everything recorded about one process.  Only a process that has
+// exited is an error; details that cannot be read are noted in its errors.
+//
+func readLiveProcess(pid int, bootTime time.Time, hashes map[string]string) (*liveProcess, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	info, err := readProcessInfo(pid)
+	if err != nil {
+		return nil, err
+	}
+	stat, err := ioutil.ReadFile(dir + "/stat")
+	if err != nil {
+		return nil, err
+	}
+	process := &liveProcess{processInfo: info, socketInodes: map[string]bool{}}
+	process.CollectedAt = time.Now().UTC()
+
+	// the process name in stat is in parentheses and may contain spaces
+	// or parentheses itself, so fields are counted from the last one
+	if end := bytes.LastIndexByte(stat, ')'); end > 0 {
+		fields := strings.Fields(string(stat[end+1:]))
+		if len(fields) > 19 {
+			process.State = fields[0]
+			process.PPID, _ = strconv.Atoi(fields[1])
+			// start times are in clock ticks, which are 1/100 of a second
+			// on every architecture Linux supports
+			ticks, err := strconv.ParseInt(fields[19], 10, 64)
+			if err == nil && !bootTime.IsZero() {
+				started := bootTime.Add(time.Duration(ticks) * 10 * time.Millisecond)
+				process.StartedAt = &started
+			}
+		}
+	}
+	if status, err := ioutil.ReadFile(dir + "/status"); err == nil {
+		for _, line := range strings.Split(string(status), "\n") {
+			if strings.HasPrefix(line, "Uid:") {
+				process.UID = parseIDs(line[len("Uid:"):])
+			} else if strings.HasPrefix(line, "Gid:") {
+				process.GID = parseIDs(line[len("Gid:"):])
+			}
+		}
+	}
+	process.Cwd, _ = os.Readlink(dir + "/cwd")
+	process.NetNamespace, _ = os.Readlink(dir + "/ns/net")
+	if environ, err := ioutil.ReadFile(dir + "/environ"); err != nil {
+		process.Errors = append(process.Errors, "unable to read environment: "+err.Error())
+	} else if len(environ) > 0 {
+		process.Environment = strings.Split(strings.TrimRight(string(environ), "\x00"), "\x00")
+	}
+	if process.Executable != "" {
+		hash, ok := hashes[process.Executable]
+		if !ok {
+			hash, err = hashFile(dir + "/exe")
+			if err != nil {
+				process.Errors = append(process.Errors, "unable to hash executable: "+err.Error())
+			} else {
+				hashes[process.Executable] = hash
+			}
+		}
+		process.ExecutableSHA256 = hash
+	}
+
+	fds, err := ioutil.ReadDir(dir + "/fd")
+	if err != nil {
+		process.Errors = append(process.Errors, "unable to list file descriptors: "+err.Error())
+	}
+	for _, entry := range fds {
+		fd, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		target, err := os.Readlink(dir + "/fd/" + entry.Name())
+		if err != nil {
+			continue
+		}
+		process.FileDescriptors = append(process.FileDescriptors, fileDescriptor{FD: fd, Target: target})
+		if strings.HasPrefix(target, "socket:[") && strings.HasSuffix(target, "]") {
+			process.socketInodes[target[len("socket:["):len(target)-1]] = true
+		}
+	}
+	sort.Slice(process.FileDescriptors, func(i, j int) bool {
+		return process.FileDescriptors[i].FD < process.FileDescriptors[j].FD
+	})
+	return process, nil
+}
+
+func parseIDs(field string) []int {
+	ids := []int{}
+	for _, value := range strings.Fields(field) {
+		if id, err := strconv.Atoi(value); err == nil {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+//
+// Return the SHA-256 of a file, reading it as a stream.
+//
+func hashFile(name string) (string, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+//
+// Read the socket tables of the network namespace a process, named by its
+// directory in /proc, is in.
+//
+func readSockets(proc, namespace string) ([]*liveSocket, error) {
+	dir := proc + "/net/"
+	sockets := []*liveSocket{}
+	for _, protocol := range []string{"tcp", "tcp6", "udp", "udp6"} {
+		data, err := ioutil.ReadFile(dir + protocol)
+		if os.IsNotExist(err) {
+			// hosts without IPv6 have no tables for it
+			continue
+		} else if err != nil {
+			return sockets, err
+		}
+		sockets = append(sockets, parseInetSockets(protocol, namespace, data)...)
+	}
+	data, err := ioutil.ReadFile(dir + "unix")
+	if err != nil {
+		return sockets, err
+	}
+	return append(sockets, parseUnixSockets(data)...), nil
+}
+
+//
+// Parse a /proc/net/tcp, tcp6, udp or udp6 table.
+//
+func parseInetSockets(protocol, namespace string, data []byte) []*liveSocket {
+	sockets := []*liveSocket{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 {
+			continue
+		}
+		localAddress, localPort, err := parseSocketAddress(fields[1])
+		if err != nil {
+			continue
+		}
+		remoteAddress, remotePort, err := parseSocketAddress(fields[2])
+		if err != nil {
+			continue
+		}
+		state, ok := tcpStates[fields[3]]
+		if !ok {
+			state = fields[3]
+		}
+		socket := &liveSocket{
+			Protocol:      protocol,
+			NetNamespace:  namespace,
+			LocalAddress:  localAddress,
+			LocalPort:     localPort,
+			RemoteAddress: remoteAddress,
+			RemotePort:    remotePort,
+			State:         state,
+			Inode:         fields[9],
+		}
+		if uid, err := strconv.Atoi(fields[7]); err == nil {
+			socket.UID = &uid
+		}
+		sockets = append(sockets, socket)
+	}
+	return sockets
+}
+
+//
+// Parse an address from a socket table, written as the hex of its bytes in
+// 32 bit words of host byte order, a colon, and the hex of the port.
+//
+func parseSocketAddress(field string) (string, int, error) {
+	parts := strings.SplitN(field, ":", 2)
+	if len(parts) != 2 {
+		return "", 0, strconv.ErrSyntax
+	}
+	raw, err := hex.DecodeString(parts[0])
+	if err != nil || (len(raw) != net.IPv4len && len(raw) != net.IPv6len) {
+		return "", 0, strconv.ErrSyntax
+	}
+	ip := make(net.IP, len(raw))
+	for i := 0; i < len(raw); i += 4 {
+		hostByteOrder.PutUint32(ip[i:], binary.BigEndian.Uint32(raw[i:]))
+	}
+	port, err := strconv.ParseUint(parts[1], 16, 16)
+	if err != nil {
+		return "", 0, err
+	}
+	return ip.String(), int(port), nil
+}
+
+//
+// Parse a /proc/net/unix table.
+//
+func parseUnixSockets(data []byte) []*liveSocket {
+	sockets := []*liveSocket{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Scan() // the header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 7 {
+			continue
+		}
+		socket := &liveSocket{
+			Protocol: "unix",
+			Type:     unixTypes[fields[4]],
+			State:    unixStates[fields[5]],
+			Inode:    fields[6],
+		}
+		// listening sockets are flagged as accepting connections
+		if flags, err := strconv.ParseUint(fields[3], 16, 32); err == nil && flags&0x10000 != 0 {
+			socket.State = "LISTEN"
+		}
+		if len(fields) > 7 {
+			socket.Path = strings.Join(fields[7:], " ")
+		}
+		sockets = append(sockets, socket)
+	}
+	return sockets
+}
@@ -0,0 +1,88 @@
+package tasks_test
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/dexter/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLiveResponseSnapshotsProcessesAndSockets(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("live-response only runs on linux")
+	}
+	assert := assert.New(t)
+	child, line, kill := startChild(t, "listen")
+	defer kill()
+	port, err := strconv.Atoi(line)
+	assert.Nil(err)
+
+	dir, err := ioutil.TempDir("", "dexter-live-response")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	task := tasks.Tasks["live-response"]
+	run := task.Run(context.Background(), dir+"/", nil)
+	assert.Len(run.Artifacts, 2, run.Errors)
+
+	var processes []struct {
+		PID              int
+		PPID             int
+		Children         []int
+		Environment      []string
+		ExecutableSHA256 string
+		FileDescriptors  []struct{ Target string }
+	}
+	data, err := ioutil.ReadFile(filepath.Join(dir, "live-response", "processes.json"))
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &processes))
+	found := false
+	for _, process := range processes {
+		if process.PID == os.Getpid() {
+			assert.Contains(process.Children, child.Process.Pid)
+		}
+		if process.PID != child.Process.Pid {
+			continue
+		}
+		found = true
+		assert.Equal(os.Getpid(), process.PPID)
+		assert.Contains(process.Environment, "DEXTER_TEST_CHILD=listen")
+		assert.Len(process.ExecutableSHA256, 64)
+		sockets := 0
+		for _, fd := range process.FileDescriptors {
+			if strings.HasPrefix(fd.Target, "socket:[") {
+				sockets++
+			}
+		}
+		assert.NotZero(sockets)
+	}
+	assert.True(found, "the child process is in the snapshot")
+
+	var sockets []struct {
+		Protocol     string
+		LocalAddress string
+		LocalPort    int
+		State        string
+		PIDs         []int
+	}
+	data, err = ioutil.ReadFile(filepath.Join(dir, "live-response", "sockets.json"))
+	assert.Nil(err)
+	assert.Nil(json.Unmarshal(data, &sockets))
+	found = false
+	for _, socket := range sockets {
+		if socket.Protocol == "tcp" && socket.LocalPort == port {
+			found = true
+			assert.Equal("127.0.0.1", socket.LocalAddress)
+			assert.Equal("LISTEN", socket.State)
+			assert.Equal([]int{child.Process.Pid}, socket.PIDs)
+		}
+	}
+	assert.True(found, "the child's listening socket is in the snapshot")
+}
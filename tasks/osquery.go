@@ -6,6 +6,7 @@ import (
 	log "github.com/sirupsen/logrus"
 	"github.com/kolide/osquery-go"
 
+	"context"
 	"encoding/json"
 	"fmt"
 	"time"
@@ -21,7 +22,7 @@ func init() {
 	})
 }
 
-func collectOSQuery(_ []string, writer *ArtifactWriter) {
+func collectOSQuery(ctx context.Context, _ []string, writer *ArtifactWriter) {
 	socket := helpers.OSQuerySocket()
 	client, err := osquery.NewClient(socket, 60*time.Second)
 	if err != nil {
@@ -37,6 +38,10 @@ func collectOSQuery(_ []string, writer *ArtifactWriter) {
 
 	tableNames := getTables(client, writer)
 	for _, table := range tableNames {
+		if ctx.Err() != nil {
+			writer.Error("stopped collecting osquery tables: " + ctx.Err().Error())
+			return
+		}
 		query := fmt.Sprintf("SELECT * FROM %s;", table)
 		resp, err := client.Query(query)
 		if err != nil {
@@ -69,8 +74,9 @@ func collectOSQuery(_ []string, writer *ArtifactWriter) {
 			writer.Error(errstr + ": " + err.Error())
 			continue
 		}
-		writer.Write(
+		writer.WriteFrom(
 			table+"/results.json",
+			"osquery table "+table,
 			data,
 		)
 	}
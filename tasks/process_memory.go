@@ -0,0 +1,245 @@
+package tasks
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/coinbase/dexter/engine/helpers"
+
+	log "github.com/sirupsen/logrus"
+)
+
+func init() {
+	add(Task{
+		Name:                 "process-memory",
+		Description:          "dump the readable memory of processes, by PID or process name pattern",
+		MinimumArguments:     1,
+		ConsensusRequirement: 1,
+		supportedPlatforms:   []string{"linux"},
+		actionFunction:       dumpProcessMemory,
+	})
+}
+
+//
+// The details of a process recorded alongside its memory.
+//
+type processInfo struct {
+	PID         int
+	Name        string
+	Executable  string   `json:",omitempty"`
+	Cmdline     []string `json:",omitempty"`
+	CollectedAt time.Time
+}
+
+//
+// One region of a process's address space, as listed in /proc/<pid>/maps.
+//
+type memoryRegion struct {
+	start       uint64
+	end         uint64
+	permissions string
+	path        string
+}
+
+//
+// Dump each process named by the arguments.  Arguments are PIDs, or glob
+// patterns matched against process names and executable names.  For each
+// process the report holds its map layout and one file per readable
+// region, limited in total by the daemon's configuration.  Processes are
+// not stopped while their memory is read.
+//
+func dumpProcessMemory(ctx context.Context, arguments []string, writer *ArtifactWriter) {
+	log.WithFields(log.Fields{
+		"at":        "tasks.dumpProcessMemory",
+		"path":      writer.path,
+		"arguments": arguments,
+	}).Info("dumping process memory")
+
+	pids := map[int]bool{}
+	for _, arg := range arguments {
+		if pid, err := strconv.Atoi(arg); err == nil {
+			pids[pid] = true
+			continue
+		}
+		if _, err := filepath.Match(arg, ""); err != nil {
+			writer.Error("invalid pattern " + arg + ": " + err.Error())
+			continue
+		}
+		matched, err := processesMatching(arg)
+		if err != nil {
+			writer.Error("unable to list processes: " + err.Error())
+			return
+		}
+		if len(matched) == 0 {
+			writer.Error("no processes match " + arg)
+		}
+		for _, pid := range matched {
+			pids[pid] = true
+		}
+	}
+	sorted := []int{}
+	for pid := range pids {
+		sorted = append(sorted, pid)
+	}
+	sort.Ints(sorted)
+
+	remaining := int64(helpers.ProcessMemoryMaxMB()) << 20
+	for _, pid := range sorted {
+		if ctx.Err() != nil {
+			writer.Error("stopped dumping process memory: " + ctx.Err().Error())
+			return
+		}
+		remaining = dumpProcess(ctx, pid, writer, remaining)
+	}
+}
+
+//
+// Return the PIDs of processes other than this one whose name or
+// executable name matches a pattern.
+//
+func processesMatching(pattern string) ([]int, error) {
+	entries, err := ioutil.ReadDir("/proc")
+	if err != nil {
+		return nil, err
+	}
+	pids := []int{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil || pid == os.Getpid() {
+			continue
+		}
+		info, err := readProcessInfo(pid)
+		if err != nil {
+			continue
+		}
+		for _, name := range []string{info.Name, filepath.Base(info.Executable)} {
+			if matched, _ := filepath.Match(pattern, name); matched && name != "" {
+				pids = append(pids, pid)
+				break
+			}
+		}
+	}
+	return pids, nil
+}
+
+func readProcessInfo(pid int) (processInfo, error) {
+	dir := "/proc/" + strconv.Itoa(pid)
+	name, err := ioutil.ReadFile(dir + "/comm")
+	if err != nil {
+		return processInfo{}, err
+	}
+	info := processInfo{
+		PID:  pid,
+		Name: strings.TrimSpace(string(name)),
+	}
+	info.Executable, _ = os.Readlink(dir + "/exe")
+	if cmdline, err := ioutil.ReadFile(dir + "/cmdline"); err == nil && len(cmdline) > 0 {
+		info.Cmdline = strings.Split(strings.TrimRight(string(cmdline), "\x00"), "\x00")
+	}
+	return info, nil
+}
+
+//
+// Parse the regions listed in /proc/<pid>/maps.
+//
+func parseMaps(data []byte) []memoryRegion {
+	regions := []memoryRegion{}
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 {
+			continue
+		}
+		bounds := strings.SplitN(fields[0], "-", 2)
+		if len(bounds) != 2 {
+			continue
+		}
+		start, err := strconv.ParseUint(bounds[0], 16, 64)
+		if err != nil {
+			continue
+		}
+		end, err := strconv.ParseUint(bounds[1], 16, 64)
+		if err != nil || end <= start {
+			continue
+		}
+		region := memoryRegion{start: start, end: end, permissions: fields[1]}
+		if len(fields) > 5 {
+			region.path = strings.Join(fields[5:], " ")
+		}
+		regions = append(regions, region)
+	}
+	return regions
+}
+
+//
+// Dump the map layout and readable regions of one process, returning how
+// much more memory may be dumped.
+//
+func dumpProcess(ctx context.Context, pid int, writer *ArtifactWriter, remaining int64) int64 {
+	dir := "/proc/" + strconv.Itoa(pid)
+	prefix := strconv.Itoa(pid) + "/"
+	info, err := readProcessInfo(pid)
+	if err != nil {
+		writer.Error("unable to read process " + strconv.Itoa(pid) + ": " + err.Error())
+		return remaining
+	}
+	info.CollectedAt = time.Now().UTC()
+	data, err := json.MarshalIndent(info, "", "  ")
+	if err == nil {
+		writer.WriteFrom(prefix+"process.json", dir, data)
+	}
+	maps, err := ioutil.ReadFile(dir + "/maps")
+	if err != nil {
+		writer.Error("unable to read memory map of process " + strconv.Itoa(pid) + ": " + err.Error())
+		return remaining
+	}
+	writer.WriteFrom(prefix+"maps.txt", dir+"/maps", maps)
+
+	mem, err := os.Open(dir + "/mem")
+	if err != nil {
+		writer.Error("unable to open memory of process " + strconv.Itoa(pid) + ": " + err.Error())
+		return remaining
+	}
+	defer mem.Close()
+	for _, region := range parseMaps(maps) {
+		if ctx.Err() != nil {
+			return remaining
+		}
+		// regions that cannot be read include guard pages, and the
+		// kernel's own mappings, which live above the largest offset a
+		// file can be read from
+		if !strings.HasPrefix(region.permissions, "r") || region.path == "[vvar]" || region.end > math.MaxInt64 {
+			continue
+		}
+		name := fmt.Sprintf("%x-%x", region.start, region.end)
+		size := int64(region.end - region.start)
+		if size > remaining {
+			writer.Error("skipped region " + name + " of process " + strconv.Itoa(pid) + ", the size limit has been reached")
+			continue
+		}
+		src := &contextReader{ctx: ctx, reader: io.NewSectionReader(mem, int64(region.start), size)}
+		source := dir + "/mem@" + name
+		if region.path != "" {
+			source += " " + region.path
+		}
+		written, err := writer.WriteStream(prefix+"memory/"+name+".bin", source, src, nil)
+		if err != nil {
+			writer.Error("unable to read region " + name + " of process " + strconv.Itoa(pid) + ": " + err.Error())
+			continue
+		}
+		remaining -= written
+	}
+	return remaining
+}
@@ -0,0 +1,62 @@
+package tasks_test
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/coinbase/dexter/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProcessMemoryDumpsAChildProcess(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("process-memory only runs on linux")
+	}
+	assert := assert.New(t)
+	child, marker, kill := startChild(t, "memory")
+	defer kill()
+	pid := strconv.Itoa(child.Process.Pid)
+
+	dir, err := ioutil.TempDir("", "dexter-process-memory")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	task := tasks.Tasks["process-memory"]
+	run := task.Run(context.Background(), dir+"/", []string{pid})
+
+	found := false
+	regions := 0
+	for _, artifact := range run.Artifacts {
+		if !strings.HasPrefix(artifact.Path, "process-memory/"+pid+"/memory/") {
+			continue
+		}
+		regions++
+		data, err := ioutil.ReadFile(filepath.Join(dir, filepath.FromSlash(artifact.Path)))
+		assert.Nil(err)
+		if bytes.Contains(data, []byte(marker)) {
+			found = true
+		}
+	}
+	if regions == 0 {
+		t.Fatal("unable to read the memory of a child process: ", run.Errors)
+	}
+	assert.True(found, "the marker the child holds is in its memory")
+	maps, err := ioutil.ReadFile(filepath.Join(dir, "process-memory", pid, "maps.txt"))
+	assert.Nil(err)
+	assert.Contains(string(maps), "[stack]")
+
+	// processes can also be named by pattern
+	run = task.Run(context.Background(), filepath.Join(dir, "pattern")+"/", []string{filepath.Base(os.Args[0])[:5] + "*"})
+	paths := []string{}
+	for _, artifact := range run.Artifacts {
+		paths = append(paths, artifact.Path)
+	}
+	assert.Contains(paths, "process-memory/"+pid+"/process.json")
+	assert.NotContains(paths, "process-memory/"+strconv.Itoa(os.Getpid())+"/process.json", "dexter does not dump itself")
+}
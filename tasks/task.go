@@ -6,15 +6,24 @@
 package tasks
 
 import (
+	"github.com/coinbase/dexter/engine/helpers"
 	"github.com/coinbase/dexter/util"
 
 	log "github.com/sirupsen/logrus"
 
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
 	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
+	"time"
 )
 
 //
@@ -25,22 +34,106 @@ type Task struct {
 	Description          string
 	MinimumArguments     int
 	ConsensusRequirement int
+	Timeout              time.Duration
 	supportedPlatforms   []string
 	actionFunction
 }
 
 // An ArtifactWriter helps you create files in the correct
-// path for a report.
+// path for a report.  Once a task has finished or timed out
+// the writer is closed, and further writes are discarded.
+// Every file written is recorded for the report's manifest.
+// Streamed files are staged outside of the report until they
+// are complete.
 type ArtifactWriter struct {
-	path   string
-	errors []string
+	path      string
+	staging   string
+	task      string
+	errors    []string
+	artifacts []Artifact
+	lock      sync.Mutex
+	closed    bool
 }
 
 //
-// An actionFunction takes a path prefix and a list of arguments,
-// and contains whatever code will be ran as part of an action.
+// An Artifact records one file a task wrote to a report: its path in the
+// report, what it was collected from on the host, its size and SHA-256,
+// and when it was written.  Files copied from the host also record the
+// metadata the original had when it was collected.
 //
-type actionFunction func([]string, *ArtifactWriter)
+type Artifact struct {
+	Path        string
+	Source      string `json:",omitempty"`
+	Size        int64
+	SHA256      string
+	CollectedAt time.Time
+	Metadata    *FileMetadata `json:",omitempty"`
+}
+
+//
+// The metadata of a file on the host.  Ownership and access and change
+// times are only recorded on platforms that provide them.
+//
+type FileMetadata struct {
+	Mode       string
+	UID        *int   `json:",omitempty"`
+	GID        *int   `json:",omitempty"`
+	Owner      string `json:",omitempty"`
+	Group      string `json:",omitempty"`
+	ModifiedAt time.Time
+	AccessedAt *time.Time `json:",omitempty"`
+	ChangedAt  *time.Time `json:",omitempty"`
+}
+
+//
+// A TaskManifest records a run of a task, the arguments it was given,
+// when it started and finished, the files it wrote and the errors it hit.
+//
+type TaskManifest struct {
+	Task       string
+	Arguments  []string
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Artifacts  []Artifact
+	Errors     []string `json:",omitempty"`
+}
+
+//
+// A PathFilter returns true if a task may collect the file at a path on
+// the host.
+//
+type PathFilter func(string) bool
+
+type pathFilterKey struct{}
+
+//
+// Return a context that limits the files a task may collect to those the
+// filter allows.
+//
+func WithPathFilter(ctx context.Context, filter PathFilter) context.Context {
+	return context.WithValue(ctx, pathFilterKey{}, filter)
+}
+
+//
+// Return true if the context allows a file to be collected.
+//
+func pathAllowed(ctx context.Context, path string) bool {
+	filter, ok := ctx.Value(pathFilterKey{}).(PathFilter)
+	return !ok || filter(path)
+}
+
+//
+// Returned when writing to an artifact writer after its task has finished.
+//
+var errWriterClosed = errors.New("task has finished, evidence written after it is discarded")
+
+//
+// An actionFunction takes a context, a list of arguments and an
+// ArtifactWriter, and contains whatever code will be ran as part of
+// an action.  The context is cancelled when the task's deadline
+// passes, and long running actions should stop when it is done.
+//
+type actionFunction func(context.Context, []string, *ArtifactWriter)
 
 //
 // All tasks in Dexter are stored here, added using the `add` function
@@ -66,29 +159,90 @@ func add(t Task) {
 // Run the Task's actionFunction unless Dexter
 // isn't running on a platform the task supports.
 //
-func (task *Task) Run(dir string, args []string) {
+// If the action does not return before the task's timeout,
+// or the DEXTER_TASK_TIMEOUT_SECONDS default, Run records
+// the timeout in the task's errors and returns without
+// waiting for the action any longer.
+//
+// Run returns the manifest of what the task did.
+//
+func (task *Task) Run(ctx context.Context, dir string, args []string) TaskManifest {
+	manifest := TaskManifest{
+		Task:      task.Name,
+		Arguments: args,
+		StartedAt: time.Now().UTC(),
+	}
 	if len(task.supportedPlatforms) > 0 && !util.StringsInclude(task.supportedPlatforms, runtime.GOOS) {
 		log.WithFields(log.Fields{
 			"at":       "task.Run",
 			"task":     task.Name,
 			"platform": runtime.GOOS,
 		}).Error("task not support on platform")
-		return
+		manifest.FinishedAt = time.Now().UTC()
+		manifest.Errors = []string{"task not supported on platform " + runtime.GOOS}
+		return manifest
 	}
-	writer := ArtifactWriter{
-		path: dir + task.Name + "/",
+	timeout := task.Timeout
+	if timeout <= 0 {
+		timeout = helpers.TaskTimeout()
 	}
-	task.actionFunction(
-		args,
-		&writer,
-	)
-	writer.flushErrors()
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	writer := &ArtifactWriter{
+		path:    dir + task.Name + "/",
+		staging: strings.TrimSuffix(dir, "/") + ".partial/" + task.Name + "/",
+		task:    task.Name,
+	}
+	finished := make(chan struct{})
+	go func() {
+		task.actionFunction(
+			ctx,
+			args,
+			writer,
+		)
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		log.WithFields(log.Fields{
+			"at":      "task.Run",
+			"task":    task.Name,
+			"timeout": timeout.String(),
+			"error":   ctx.Err().Error(),
+		}).Error("task did not finish before its deadline")
+		writer.Error("task did not finish before its deadline of " + timeout.String() + ": " + ctx.Err().Error())
+	}
+	writer.close()
+	manifest.FinishedAt = time.Now().UTC()
+	manifest.Artifacts = writer.artifacts
+	manifest.Errors = writer.errors
+	return manifest
 }
 
 //
 // Write a file to the filesystem, logging any errors
 //
 func (writer *ArtifactWriter) Write(dst string, data []byte) {
+	writer.WriteFrom(dst, "", data)
+}
+
+//
+// Write a file to the filesystem, recording the path or other
+// source on the host it was collected from
+//
+func (writer *ArtifactWriter) WriteFrom(dst, source string, data []byte) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	if writer.closed {
+		log.WithFields(log.Fields{
+			"at":   "tasks.Write",
+			"file": dst,
+		}).Warn("discarding evidence written after task finished")
+		return
+	}
 	dst = writer.path + dst
 	dir := path.Dir(dst)
 	err := os.MkdirAll(filepath.FromSlash(dir), 0700)
@@ -107,16 +261,110 @@ func (writer *ArtifactWriter) Write(dst string, data []byte) {
 			"file":  dst,
 			"error": err.Error(),
 		}).Error("unable to write piece of evidence for report")
+		return
 	}
+	sum := sha256.Sum256(data)
+	writer.record(dst, source, int64(len(data)), sum[:], nil)
+}
+
+//
+// Stream a file into the report, recording the source on the host it was
+// collected from and its metadata there.  The writer is not locked while
+// the data is copied, so a large file does not hold up the end of a task.
+// The copy is staged outside of the report and only moved into it if the
+// task has not finished, so nothing changes in the report once it is
+// being zipped.  Returns the number of bytes written.
+//
+func (writer *ArtifactWriter) WriteStream(dst, source string, src io.Reader, metadata *FileMetadata) (int64, error) {
+	writer.lock.Lock()
+	closed := writer.closed
+	writer.lock.Unlock()
+	if closed {
+		return 0, errWriterClosed
+	}
+	err := os.MkdirAll(filepath.FromSlash(writer.staging), 0700)
+	if err != nil {
+		return 0, err
+	}
+	file, err := ioutil.TempFile(filepath.FromSlash(writer.staging), "stream-")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(file.Name())
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(file, hash), src)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return size, err
+	}
+
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	if writer.closed {
+		return size, errWriterClosed
+	}
+	dst = writer.path + dst
+	err = os.MkdirAll(filepath.FromSlash(path.Dir(dst)), 0700)
+	if err == nil {
+		err = os.Chmod(file.Name(), 0644)
+	}
+	if err == nil {
+		err = os.Rename(file.Name(), filepath.FromSlash(dst))
+	}
+	if err != nil {
+		return size, err
+	}
+	writer.record(dst, source, size, hash.Sum(nil), metadata)
+	return size, nil
+}
+
+//
+// Record a file written to the report, replacing the record of
+// any earlier file written to the same path
+//
+func (writer *ArtifactWriter) record(dst, source string, size int64, sum []byte, metadata *FileMetadata) {
+	artifact := Artifact{
+		Path:        path.Clean(writer.task + "/" + dst[len(writer.path):]),
+		Source:      source,
+		Size:        size,
+		SHA256:      hex.EncodeToString(sum),
+		CollectedAt: time.Now().UTC(),
+		Metadata:    metadata,
+	}
+	for i, existing := range writer.artifacts {
+		if existing.Path == artifact.Path {
+			writer.artifacts[i] = artifact
+			return
+		}
+	}
+	writer.artifacts = append(writer.artifacts, artifact)
 }
 
 //
 // Write an error into a tasks's report
 //
 func (writer *ArtifactWriter) Error(message string) {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	if writer.closed {
+		return
+	}
 	writer.errors = append(writer.errors, message)
 }
 
+//
+// Write a task's errors to disk and discard anything
+// written afterwards
+//
+func (writer *ArtifactWriter) close() {
+	writer.lock.Lock()
+	defer writer.lock.Unlock()
+	writer.flushErrors()
+	writer.closed = true
+}
+
 //
 // Write a task's errors to disk
 //
@@ -128,6 +376,10 @@ func (writer *ArtifactWriter) flushErrors() {
 			data = append(data, []byte("\n")...)
 		}
 		data = append(data, []byte("\n")...)
-		ioutil.WriteFile(writer.path+"errors.txt", data, 0644)
+		os.MkdirAll(filepath.FromSlash(writer.path), 0700)
+		if ioutil.WriteFile(writer.path+"errors.txt", data, 0644) == nil {
+			sum := sha256.Sum256(data)
+			writer.record(writer.path+"errors.txt", "", int64(len(data)), sum[:], nil)
+		}
 	}
 }
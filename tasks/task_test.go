@@ -0,0 +1,59 @@
+package tasks_test
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coinbase/dexter/tasks"
+	"github.com/stretchr/testify/assert"
+)
+
+//
+// A reader that blocks until it is released, ignoring any deadline.
+//
+type blockedReader struct {
+	release chan struct{}
+	done    bool
+}
+
+func (reader *blockedReader) Read(p []byte) (int, error) {
+	if reader.done {
+		return 0, io.EOF
+	}
+	<-reader.release
+	reader.done = true
+	return copy(p, "evidence"), nil
+}
+
+func TestStreamsFinishingAfterTheDeadlineAreDiscarded(t *testing.T) {
+	assert := assert.New(t)
+	dir, err := ioutil.TempDir("", "dexter-task")
+	assert.Nil(err)
+	defer os.RemoveAll(dir)
+	report := filepath.Join(dir, "report") + "/"
+
+	reader := &blockedReader{release: make(chan struct{})}
+	written := make(chan error)
+	task := tasks.NewTestTask("slow-task", 50*time.Millisecond, func(ctx context.Context, args []string, writer *tasks.ArtifactWriter) {
+		_, err := writer.WriteStream("evidence.txt", "", reader, nil)
+		written <- err
+	})
+	run := task.Run(context.Background(), report, nil)
+	assert.Len(run.Artifacts, 1)
+	assert.Equal("slow-task/errors.txt", run.Artifacts[0].Path)
+	assert.Len(run.Errors, 1)
+	assert.True(strings.Contains(run.Errors[0], "deadline"))
+
+	close(reader.release)
+	assert.NotNil(<-written)
+	_, err = os.Stat(filepath.Join(report, "slow-task", "evidence.txt"))
+	assert.True(os.IsNotExist(err), "nothing is added to the report once the task has finished")
+	staged, _ := ioutil.ReadDir(filepath.Join(dir, "report.partial", "slow-task"))
+	assert.Empty(staged)
+}